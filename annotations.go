@@ -160,6 +160,29 @@ func GetAnnotations(ctx *Ctx, orgRepo, annoRegexp string) (annotations Annotatio
 	return
 }
 
+// AddAnnotationPoints - adds "annotations" series points for given annotations
+// Period allows storing annotations of other kinds (for example detected anomalies) without overwriting project ones
+func AddAnnotationPoints(ctx *Ctx, pts *TSPoints, annotations []Annotation, period string) {
+	for _, annotation := range annotations {
+		fields := map[string]interface{}{
+			"title":       annotation.Name,
+			"description": annotation.Description,
+		}
+		// Add batch point
+		if ctx.Debug > 0 {
+			Printf(
+				"Series: %v: Date: %v: '%v', '%v'\n",
+				"annotations",
+				ToYMDDate(annotation.Date),
+				annotation.Name,
+				annotation.Description,
+			)
+		}
+		pt := NewTSPoint(ctx, "annotations", period, nil, fields, annotation.Date, false)
+		AddTSPoint(ctx, pts, pt)
+	}
+}
+
 // ProcessAnnotations Creates annotations and quick_series
 func ProcessAnnotations(ctx *Ctx, annotations *Annotations, dates []*time.Time) {
 	// Connect to Postgres
@@ -186,24 +209,7 @@ func ProcessAnnotations(ctx *Ctx, annotations *Annotations, dates []*time.Time)
 	sort.Sort(AnnotationsByDate(annotations.Annotations))
 
 	// Iterate annotations
-	for _, annotation := range annotations.Annotations {
-		fields := map[string]interface{}{
-			"title":       annotation.Name,
-			"description": annotation.Description,
-		}
-		// Add batch point
-		if ctx.Debug > 0 {
-			Printf(
-				"Series: %v: Date: %v: '%v', '%v'\n",
-				"annotations",
-				ToYMDDate(annotation.Date),
-				annotation.Name,
-				annotation.Description,
-			)
-		}
-		pt := NewTSPoint(ctx, "annotations", "", nil, fields, annotation.Date, false)
-		AddTSPoint(ctx, &pts, pt)
-	}
+	AddAnnotationPoints(ctx, &pts, annotations.Annotations, "")
 
 	// If both start and join dates are present then join date must be after start date
 	if startDate == nil || joinDate == nil || (startDate != nil && joinDate != nil && joinDate.After(*startDate)) {
@@ -0,0 +1,394 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Anomaly - single anomalous point of a series table column
+type Anomaly struct {
+	Table  string
+	Series string
+	Period string
+	Column string
+	Time   time.Time
+	Value  float64
+	Median float64
+	MAD    float64
+	Score  float64
+	Reason string
+}
+
+// String - anomaly description used in annotations and logs
+func (a *Anomaly) String() string {
+	name := a.Table
+	if a.Series != "" {
+		name += "/" + a.Series
+	}
+	return fmt.Sprintf(
+		"%s.%s (%s): %s, value %g, baseline median %g, MAD %g, score %.1f",
+		name, a.Column, a.Period, a.Reason, a.Value, a.Median, a.MAD, a.Score,
+	)
+}
+
+// median - returns median of values (values are not modified)
+func median(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0.0
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2.0
+}
+
+// DetectAnomaly - compares value with baseline of previous values using median and MAD (median absolute deviation)
+// Score is the distance from the median in scaled MADs (robust z-score), MAD lower bound is 10% of the median
+// so almost constant series do not report tiny changes. Returns empty reason when value is not an anomaly.
+// Value dropping to zero while all baseline values are positive (typical result of a partial ingestion)
+// is always reported.
+func DetectAnomaly(history []float64, value, threshold float64) (med, mad, score float64, reason string) {
+	if len(history) < 3 {
+		return
+	}
+	med = median(history)
+	devs := make([]float64, len(history))
+	allPositive := true
+	for i, v := range history {
+		devs[i] = math.Abs(v - med)
+		if v <= 0.0 {
+			allPositive = false
+		}
+	}
+	mad = median(devs)
+	scale := math.Max(1.4826*mad, 0.1*math.Abs(med))
+	if scale == 0.0 {
+		scale = 1.0
+	}
+	score = math.Abs(value-med) / scale
+	switch {
+	case value == 0.0 && allPositive:
+		reason = "sudden zero"
+	case score < threshold:
+	case value > med:
+		reason = "spike"
+	default:
+		reason = "drop"
+	}
+	return
+}
+
+// ensureAnomaliesTable - creates detected anomalies table if needed
+func ensureAnomaliesTable(con *sql.DB, ctx *Ctx) error {
+	_, err := ExecSQL(con, ctx, strings.Replace(CreateTable(anomaliesTableDef()), "create table ", "create table if not exists ", 1))
+	return err
+}
+
+// anomaliesTableDef - detected anomalies table definition
+func anomaliesTableDef() string {
+	return "gha_anomalies(" +
+		"dt {{tsnow}} not null, " +
+		"series text not null, " +
+		"series_name text not null, " +
+		"period text not null, " +
+		"column_name text not null, " +
+		"time {{ts}} not null, " +
+		"value double precision not null, " +
+		"baseline double precision not null, " +
+		"mad double precision not null, " +
+		"score double precision not null, " +
+		"reason text not null, " +
+		"primary key(series, series_name, period, column_name, time)" +
+		")"
+}
+
+// anomalyPeriod - checks if series period is a regular time period (h, d7, w, ...), not a quick range or histogram period
+func anomalyPeriod(period string) bool {
+	for _, p := range []string{"h", "d", "w", "m", "q", "y"} {
+		if RetentionPeriodMatches(p, period) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnomalyValues - returns latest value and history (older non-NULL values) of a column, values are ordered by time descending
+// Column can be NULL in some points (merged and multi value series), ok is false when its latest point is NULL
+func AnomalyValues(values []sql.NullFloat64) (latest float64, history []float64, ok bool) {
+	if len(values) == 0 || !values[0].Valid {
+		return
+	}
+	latest, ok = values[0].Float64, true
+	for _, v := range values[1:] {
+		if v.Valid {
+			history = append(history, v.Float64)
+		}
+	}
+	return
+}
+
+// detectSeriesAnomalies - checks latest complete point of a single series (table, series and period) for anomalies
+func detectSeriesAnomalies(con *sql.DB, ctx *Ctx, table, series, period string, columns []string, now time.Time) (anomalies []Anomaly, err error) {
+	_, _, intervalStart, _, _ := GetIntervalFunctions(period, false)
+	quoted := []string{}
+	for _, column := range columns {
+		quoted = append(quoted, "\""+escapeName(column)+"\"")
+	}
+	// Current period is not complete yet, histograms use fake times before 2012-07-02
+	args := []interface{}{period, intervalStart(now), TimeParseAny("2012-07-02")}
+	query := "select time, " + strings.Join(quoted, ", ") + " from \"" + table + "\" where period = $1 and time < $2 and time > $3"
+	if series != "" {
+		args = append(args, series)
+		query += " and series = $4"
+	}
+	query += fmt.Sprintf(" order by time desc limit %d", ctx.AnomalyWindow+1)
+	rows, err := QuerySQL(con, ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	times := []time.Time{}
+	values := make([][]sql.NullFloat64, len(columns))
+	vals := make([]sql.NullFloat64, len(columns))
+	ptrs := []interface{}{new(time.Time)}
+	for i := range vals {
+		ptrs = append(ptrs, &vals[i])
+	}
+	for rows.Next() {
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return
+		}
+		times = append(times, *ptrs[0].(*time.Time))
+		for i, v := range vals {
+			values[i] = append(values[i], v)
+		}
+	}
+	err = rows.Err()
+	if err != nil || len(times) == 0 {
+		return
+	}
+	for i, column := range columns {
+		value, history, ok := AnomalyValues(values[i])
+		if !ok {
+			continue
+		}
+		med, mad, score, reason := DetectAnomaly(history, value, ctx.AnomalyThreshold)
+		if reason == "" {
+			continue
+		}
+		anomalies = append(
+			anomalies,
+			Anomaly{
+				Table:  table,
+				Series: series,
+				Period: period,
+				Column: column,
+				Time:   times[0],
+				Value:  value,
+				Median: med,
+				MAD:    mad,
+				Score:  score,
+				Reason: reason,
+			},
+		)
+	}
+	return
+}
+
+// anomalyTableKeys - returns float columns and (period, series) keys of a series table to check, nothing if table cannot be checked
+func anomalyTableKeys(con *sql.DB, ctx *Ctx, table string) (columns []string, keys [][2]string, err error) {
+	rows, err := QuerySQL(
+		con,
+		ctx,
+		"select column_name, data_type from information_schema.columns "+
+			"where table_schema = 'public' and table_name = $1 order by ordinal_position",
+		table,
+	)
+	if err != nil {
+		return
+	}
+	merged, hasPeriod := false, false
+	name, dataType := "", ""
+	for rows.Next() {
+		err = rows.Scan(&name, &dataType)
+		if err != nil {
+			_ = rows.Close()
+			return
+		}
+		switch name {
+		case "series":
+			merged = true
+		case "period":
+			hasPeriod = true
+		default:
+			if exportColumnType(dataType) == "float" {
+				columns = append(columns, name)
+			}
+		}
+	}
+	err = rows.Err()
+	_ = rows.Close()
+	if err != nil || !hasPeriod || len(columns) == 0 {
+		return nil, nil, err
+	}
+	query := "select distinct period, '' from \"" + table + "\""
+	if merged {
+		query = "select distinct period, series from \"" + table + "\""
+	}
+	rows, err = QuerySQL(con, ctx, query)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	period, series := "", ""
+	for rows.Next() {
+		err = rows.Scan(&period, &series)
+		if err != nil {
+			return
+		}
+		if anomalyPeriod(period) {
+			keys = append(keys, [2]string{period, series})
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// saveAnomalies - saves new anomalies in gha_anomalies table and returns times of newly detected anomalies
+func saveAnomalies(con *sql.DB, ctx *Ctx, anomalies []Anomaly) (newTimes map[time.Time]struct{}, err error) {
+	newTimes = make(map[time.Time]struct{})
+	err = ensureAnomaliesTable(con, ctx)
+	if err != nil {
+		return
+	}
+	for _, a := range anomalies {
+		var (
+			res sql.Result
+			n   int64
+		)
+		res, err = ExecSQL(
+			con,
+			ctx,
+			InsertIgnore(
+				"into gha_anomalies(series, series_name, period, column_name, time, value, baseline, mad, score, reason) "+
+					NValues(10),
+			),
+			a.Table, a.Series, a.Period, a.Column, a.Time, a.Value, a.Median, a.MAD, a.Score, a.Reason,
+		)
+		if err != nil {
+			return
+		}
+		n, err = res.RowsAffected()
+		if err != nil {
+			return
+		}
+		if n > 0 {
+			newTimes[a.Time] = struct{}{}
+		}
+	}
+	return
+}
+
+// anomalyAnnotation - returns annotation describing all anomalies detected at a given time
+func anomalyAnnotation(con *sql.DB, ctx *Ctx, tm time.Time) (annotation Annotation, err error) {
+	rows, err := QuerySQL(
+		con,
+		ctx,
+		"select series, series_name, period, column_name, value, baseline, mad, score, reason "+
+			"from gha_anomalies where time = $1 order by series, series_name, period, column_name",
+		tm,
+	)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	descs := []string{}
+	for rows.Next() {
+		a := Anomaly{Time: tm}
+		err = rows.Scan(&a.Table, &a.Series, &a.Period, &a.Column, &a.Value, &a.Median, &a.MAD, &a.Score, &a.Reason)
+		if err != nil {
+			return
+		}
+		descs = append(descs, a.String())
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+	title := "Anomaly detected"
+	if len(descs) > 1 {
+		title = fmt.Sprintf("%d anomalies detected", len(descs))
+	}
+	annotation = Annotation{
+		Name:        title,
+		Description: strings.Join(descs, "; "),
+		Date:        tm,
+	}
+	return
+}
+
+// DetectAnomalies - checks latest complete points of all series tables matching ctx.AnomalySeries
+// New anomalies are saved in gha_anomalies table and added as "anomaly" period annotations
+// Detection is advisory, so errors are returned instead of being fatal, tables that failed are skipped
+func DetectAnomalies(con *sql.DB, ctx *Ctx, now time.Time) (anomalies []Anomaly, err error) {
+	tables, err := seriesTables(con, ctx)
+	if err != nil {
+		return
+	}
+	errs := []string{}
+	for _, table := range tables {
+		if !strings.HasPrefix(table, "s") || !ctx.AnomalySeries.MatchString(table) {
+			continue
+		}
+		columns, keys, e := anomalyTableKeys(con, ctx, table)
+		if e != nil {
+			errs = append(errs, table+": "+e.Error())
+			continue
+		}
+		for _, key := range keys {
+			found, e := detectSeriesAnomalies(con, ctx, table, key[1], key[0], columns, now)
+			if e != nil {
+				errs = append(errs, fmt.Sprintf("%s %s %s: %v", table, key[1], key[0], e))
+				continue
+			}
+			anomalies = append(anomalies, found...)
+		}
+	}
+	if len(errs) > 0 {
+		err = fmt.Errorf("anomaly detection failed for %d series: %s", len(errs), strings.Join(errs, "; "))
+	}
+	for i := range anomalies {
+		Printf("Anomaly: %s at %s\n", anomalies[i].String(), ToYMDHDate(anomalies[i].Time))
+	}
+	if ctx.SkipTSDB || len(anomalies) == 0 {
+		return
+	}
+
+	// Save new anomalies and annotate their times with all anomalies detected at that time
+	newTimes, e := saveAnomalies(con, ctx, anomalies)
+	if e != nil {
+		return anomalies, e
+	}
+	annotations := []Annotation{}
+	for tm := range newTimes {
+		annotation, e := anomalyAnnotation(con, ctx, tm)
+		if e != nil {
+			return anomalies, e
+		}
+		annotations = append(annotations, annotation)
+	}
+	var pts TSPoints
+	AddAnnotationPoints(ctx, &pts, annotations, "anomaly")
+	if e := WriteTSPointsE(ctx, con, &pts, "", nil); e != nil {
+		return anomalies, e
+	}
+	return
+}
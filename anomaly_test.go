@@ -0,0 +1,62 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestDetectAnomaly(t *testing.T) {
+	var testCases = []struct {
+		history  []float64
+		value    float64
+		median   float64
+		reason   string
+		minScore float64
+	}{
+		{history: []float64{10, 12, 11}, value: 0, median: 11, reason: "sudden zero", minScore: 5},
+		{history: []float64{10, 12, 11, 9, 13}, value: 12, median: 11, reason: ""},
+		{history: []float64{10, 12, 11, 9, 13}, value: 40, median: 11, reason: "spike", minScore: 5},
+		{history: []float64{100, 102, 98, 101, 99}, value: 20, median: 100, reason: "drop", minScore: 5},
+		{history: []float64{0, 3, 1, 0}, value: 0, median: 0.5, reason: ""},
+		{history: []float64{5, 5, 5, 5}, value: 5.2, median: 5, reason: ""},
+		{history: []float64{0, 0, 0, 0}, value: 7, median: 0, reason: "spike", minScore: 7},
+		{history: []float64{10, 12}, value: 0, median: 0, reason: ""},
+	}
+	for index, test := range testCases {
+		med, _, score, reason := lib.DetectAnomaly(test.history, test.value, 5.0)
+		if med != test.median || reason != test.reason || score < test.minScore {
+			t.Errorf(
+				"test number %d, expected median %v, reason '%s', score >= %v, got %v, '%s', %v",
+				index+1, test.median, test.reason, test.minScore, med, reason, score,
+			)
+		}
+	}
+}
+
+func TestAnomalyValues(t *testing.T) {
+	v := func(f float64) sql.NullFloat64 { return sql.NullFloat64{Float64: f, Valid: true} }
+	null := sql.NullFloat64{}
+	var testCases = []struct {
+		values  []sql.NullFloat64
+		latest  float64
+		history []float64
+		ok      bool
+	}{
+		{values: []sql.NullFloat64{v(3), v(1), v(2)}, latest: 3, history: []float64{1, 2}, ok: true},
+		{values: []sql.NullFloat64{v(3), null, v(2), null}, latest: 3, history: []float64{2}, ok: true},
+		{values: []sql.NullFloat64{null, v(1), v(2)}},
+		{values: []sql.NullFloat64{}},
+	}
+	for index, test := range testCases {
+		latest, history, ok := lib.AnomalyValues(test.values)
+		if latest != test.latest || !reflect.DeepEqual(history, test.history) || ok != test.ok {
+			t.Errorf(
+				"test number %d, expected %v, %v, %v, got %v, %v, %v",
+				index+1, test.latest, test.history, test.ok, latest, history, ok,
+			)
+		}
+	}
+}
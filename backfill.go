@@ -0,0 +1,143 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// BackfillCalc - single calc_metric invocation needed to recompute a metric
+// Table is a series table written by the calculation, empty when it cannot be known in advance
+// (series functions without merge_series and histograms)
+// ExpireBefore is a retention cutoff, periods before it are not recalculated (zero when series has no retention rule)
+// Merged is set when Table is a merge_series table shared with other metrics, its points cannot be dropped
+// Def is the expanded metric definition, it is used to schedule calculation the same way sync does (see NewMetricJob)
+type BackfillCalc struct {
+	Metric           string
+	SQLFile          string
+	SeriesNameOrFunc string
+	Period           string
+	Histogram        bool
+	Options          []string
+	Env              map[string]string
+	Table            string
+	Merged           bool
+	ExpireBefore     time.Time
+	Def              *Metric
+}
+
+// BackfillCalcs - expands metric with a given name into all calc_metric invocations, the same way sync does:
+// all SQL files (`sqls`), periods combined with aggregates (without skipped ones) and series names with period suffixes
+func BackfillCalcs(ctx *Ctx, allMetrics *AllMetrics, name string, quickRanges []string, now time.Time) (calcs []BackfillCalc, err error) {
+	found := false
+	for i := range allMetrics.Metrics {
+		metric := &allMetrics.Metrics[i]
+		if metric.Name != name || ExcludedForProject(ctx.Project, metric.Project) {
+			continue
+		}
+		found = true
+		if metric.Disabled {
+			return nil, fmt.Errorf("metric '%s' is disabled", name)
+		}
+		var (
+			variants []Metric
+			periods  []MetricPeriod
+		)
+		variants, err = metric.SQLVariants()
+		if err != nil {
+			return
+		}
+		for j := range variants {
+			variant := &variants[j]
+			periods, err = variant.PeriodVariants(quickRanges)
+			if err != nil {
+				return
+			}
+			for _, period := range periods {
+				calc := BackfillCalc{
+					Metric:           variant.Name,
+					SQLFile:          variant.MetricSQL,
+					SeriesNameOrFunc: variant.SeriesName(period.Name),
+					Period:           period.Name,
+					Histogram:        variant.Histogram,
+					Options:          variant.CalcMetricOptions(ctx),
+					Env:              MetricEnvMap(variant.EnvMap, period.Name),
+					Def:              variant,
+				}
+				series := calc.SeriesNameOrFunc
+				if variant.MergeSeries != "" {
+					series = variant.MergeSeries
+					calc.Merged = true
+				}
+				if !variant.Histogram {
+					if _, ok := metricFuncs[series]; !ok {
+						calc.Table = "s" + series
+					}
+					// Periods expired by retention rules are not recalculated
+					if rule := FindRetentionRule(allMetrics.Retention, series, period.Name); rule != nil {
+						calc.ExpireBefore = rule.Cutoff(now)
+						calc.Options = append(calc.Options, RetentionExpireBefore(allMetrics.Retention, series, period.Name, now))
+					}
+				}
+				calcs = append(calcs, calc)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("metric '%s' not found for project '%s'", name, ctx.Project)
+	}
+	return
+}
+
+// BackfillRange - returns [from, to) range of period's intervals covering given dates, the same as calc_metric uses
+// Range starts no earlier than the interval containing expireBefore (when set), it is empty when whole range is expired
+func BackfillRange(period string, from, to, expireBefore time.Time) (time.Time, time.Time) {
+	_, _, intervalStart, nextIntervalStart, _ := GetIntervalFunctions(period, false)
+	dFrom, dTo := intervalStart(from), nextIntervalStart(to)
+	if !expireBefore.IsZero() && dFrom.Before(expireBefore) {
+		dFrom = intervalStart(expireBefore)
+	}
+	return dFrom, dTo
+}
+
+// BackfillCheckDrop - checks if points of all calculations can be removed before backfill
+// Merged series tables contain series of other metrics that backfill doesn't recalculate, so they cannot be dropped
+func BackfillCheckDrop(calcs []BackfillCalc) error {
+	for _, calc := range calcs {
+		if calc.Merged {
+			return fmt.Errorf(
+				"cannot drop %s period %s: merged series table %s contains series of other metrics, run without GHA2DB_BACKFILL_DROP",
+				calc.Metric, calc.Period, calc.Table,
+			)
+		}
+	}
+	return nil
+}
+
+// BackfillDrop - removes points of calculation's series table and period in the backfilled range, returns number of points
+// Merged series tables are never touched, see BackfillCheckDrop
+// Points expired by retention rules are kept, because calc_metric will not recalculate them
+// In dry-run mode only counts points that would be removed
+func BackfillDrop(con *sql.DB, ctx *Ctx, calc *BackfillCalc, from, to time.Time) (n int64) {
+	if calc.Table == "" || calc.Merged || !TableExists(con, ctx, calc.Table) {
+		return
+	}
+	dFrom, dTo := BackfillRange(calc.Period, from, to, calc.ExpireBefore)
+	if !dFrom.Before(dTo) {
+		Printf("Backfill %s period %s: whole range expired before %v, not removing any points\n", calc.Table, calc.Period, calc.ExpireBefore)
+		return
+	}
+	where := " from \"" + escapeName(calc.Table) + "\" where period = $1 and time >= $2 and time < $3"
+	if ctx.DryRun {
+		FatalOnError(QueryRowSQL(con, ctx, "select count(*)"+where, calc.Period, dFrom, dTo).Scan(&n))
+	} else {
+		res := ExecSQLWithErr(con, ctx, "delete"+where, calc.Period, dFrom, dTo)
+		var err error
+		n, err = res.RowsAffected()
+		FatalOnError(err)
+	}
+	if ctx.Debug > 0 || n > 0 {
+		Printf("Backfill %s period %s: removed %d points in %s - %s\n", calc.Table, calc.Period, n, ToYMDHDate(dFrom), ToYMDHDate(dTo))
+	}
+	return
+}
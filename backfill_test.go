@@ -0,0 +1,182 @@
+package devstatscode
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestMetricPeriodVariants(t *testing.T) {
+	var testCases = []struct {
+		metric   lib.Metric
+		expected string
+		hasError bool
+	}{
+		{metric: lib.Metric{Periods: "d,w,m"}, expected: "d:d,w:w,m:m"},
+		{metric: lib.Metric{Periods: "d,w", Aggregate: "1,7", Skip: "w7,w"}, expected: "d:d,d:d7"},
+		{metric: lib.Metric{Periods: "d", AnnotationsRanges: true, Aggregate: "7"}, expected: "v1:v1,anno_1_2:anno_1_2"},
+		{metric: lib.Metric{Periods: "d", Aggregate: "x"}, hasError: true},
+	}
+	for index, test := range testCases {
+		periods, err := test.metric.PeriodVariants([]string{"v1", "anno_1_2"})
+		got := []string{}
+		for _, period := range periods {
+			got = append(got, period.Period+":"+period.Name)
+		}
+		if (err != nil) != test.hasError || strings.Join(got, ",") != test.expected {
+			t.Errorf("test number %d, expected '%s' (error: %v), got '%s' (%v)", index+1, test.expected, test.hasError, strings.Join(got, ","), err)
+		}
+	}
+}
+
+func TestMetricSQLVariants(t *testing.T) {
+	sqls := []string{"a", "b"}
+	metric := lib.Metric{Name: "m", MetricSQLs: &sqls, Drop: "sm"}
+	variants, err := metric.SQLVariants()
+	if err != nil || len(variants) != 2 {
+		t.Fatalf("expected 2 variants, got %d: %v", len(variants), err)
+	}
+	if variants[0].MetricSQL != "a" || variants[0].Drop != "sm" || variants[1].MetricSQL != "b" || variants[1].Drop != "" || variants[1].MetricSQLs != nil {
+		t.Errorf("unexpected variants: %+v", variants)
+	}
+	metric.MetricSQL = "c"
+	_, err = metric.SQLVariants()
+	if err == nil {
+		t.Errorf("expected error when both 'sql' and 'sqls' are used")
+	}
+}
+
+func TestMetricEnvMap(t *testing.T) {
+	env := map[string]string{"A": "1", "B@d7": "2", "C!d7": "3", "GHA2DB_BACKFILL_TEST_UNSET?": "4"}
+	var testCases = []struct {
+		period   string
+		expected map[string]string
+	}{
+		{period: "d7", expected: map[string]string{"A": "1", "B": "2", "GHA2DB_BACKFILL_TEST_UNSET": "4"}},
+		{period: "w", expected: map[string]string{"A": "1", "C": "3", "GHA2DB_BACKFILL_TEST_UNSET": "4"}},
+	}
+	for index, test := range testCases {
+		got := lib.MetricEnvMap(env, test.period)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test number %d, expected %v, got %v", index+1, test.expected, got)
+		}
+	}
+}
+
+func TestBackfillCalcs(t *testing.T) {
+	var ctx lib.Ctx
+	ctx.Project = "kubernetes"
+	ctx.ProjectScale = 1.0
+	sqls := []string{"prs_a", "prs_b"}
+	allMetrics := lib.AllMetrics{
+		Metrics: []lib.Metric{
+			{Name: "prs", MetricSQLs: &sqls, SeriesNameOrFunc: "multi_row_single_column", MergeSeries: "prs", Periods: "d,w", Skip: "w"},
+			{Name: "age", MetricSQL: "age", SeriesNameOrFunc: "age", AddPeriodToName: true, Periods: "d", Aggregate: "1,7", EnvMap: map[string]string{"X@d7": "1"}},
+			{Name: "top", MetricSQL: "top", SeriesNameOrFunc: "multi_row_single_column", Histogram: true, AnnotationsRanges: true},
+			{Name: "other", MetricSQL: "other", SeriesNameOrFunc: "other", Periods: "d", Project: "prometheus"},
+			{Name: "off", MetricSQL: "off", SeriesNameOrFunc: "off", Periods: "d", Disabled: true},
+		},
+		Retention: []lib.RetentionRule{{Series: "^age_d$", Period: "d", KeepDays: 10}},
+	}
+	now := time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)
+	var testCases = []struct {
+		name     string
+		expected []string
+		hasError bool
+	}{
+		{
+			name: "prs",
+			expected: []string{
+				"prs_a:multi_row_single_column:d:sprs:merge_series:prs,metric:prs:",
+				"prs_b:multi_row_single_column:d:sprs:merge_series:prs,metric:prs:",
+			},
+		},
+		{
+			name: "age",
+			expected: []string{
+				"age:age_d:d:sage_d:metric:age,expire_before:1791460800:",
+				"age:age_d7:d7:sage_d7:metric:age:X=1",
+			},
+		},
+		{
+			name: "top",
+			expected: []string{
+				"top:multi_row_single_column:y::hist,annotations_ranges,metric:top:",
+				"top:multi_row_single_column:anno_0_1::hist,annotations_ranges,metric:top:",
+			},
+		},
+		{name: "other", hasError: true},
+		{name: "off", hasError: true},
+		{name: "unknown", hasError: true},
+	}
+	for index, test := range testCases {
+		calcs, err := lib.BackfillCalcs(&ctx, &allMetrics, test.name, []string{"y", "anno_0_1"}, now)
+		if (err != nil) != test.hasError {
+			t.Errorf("test number %d, expected error: %v, got: %v", index+1, test.hasError, err)
+			continue
+		}
+		got := []string{}
+		for _, calc := range calcs {
+			env := []string{}
+			for k, v := range calc.Env {
+				env = append(env, k+"="+v)
+			}
+			got = append(got, strings.Join([]string{calc.SQLFile, calc.SeriesNameOrFunc, calc.Period, calc.Table, strings.Join(calc.Options, ","), strings.Join(env, ",")}, ":"))
+		}
+		if !test.hasError && !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test number %d, expected:\n%v\ngot:\n%v", index+1, test.expected, got)
+		}
+		// Calculations keep metric definition, so backfill schedules them the same way sync does
+		for _, calc := range calcs {
+			if calc.Def == nil || calc.Def.Name != test.name {
+				t.Errorf("test number %d, expected metric definition of '%s', got %+v", index+1, test.name, calc.Def)
+			}
+		}
+		// Only merged series tables refuse dropping points
+		if err = lib.BackfillCheckDrop(calcs); !test.hasError && (err != nil) != (test.name == "prs") {
+			t.Errorf("test number %d, unexpected drop check result: %v", index+1, err)
+		}
+	}
+}
+
+func TestBackfillRange(t *testing.T) {
+	from := time.Date(2026, 3, 5, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 5, 20, 10, 0, 0, 0, time.UTC)
+	var testCases = []struct {
+		period       string
+		expire       time.Time
+		expFrom, exp time.Time
+	}{
+		{period: "d", expFrom: time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC), exp: time.Date(2026, 5, 21, 0, 0, 0, 0, time.UTC)},
+		{period: "m", expFrom: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), exp: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{period: "d7", expFrom: time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC), exp: time.Date(2026, 5, 21, 0, 0, 0, 0, time.UTC)},
+		{
+			period:  "d",
+			expire:  time.Date(2026, 4, 10, 12, 0, 0, 0, time.UTC),
+			expFrom: time.Date(2026, 4, 10, 0, 0, 0, 0, time.UTC),
+			exp:     time.Date(2026, 5, 21, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			period:  "d",
+			expire:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			expFrom: time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC),
+			exp:     time.Date(2026, 5, 21, 0, 0, 0, 0, time.UTC),
+		},
+		// Whole range is before the retention cutoff, so it is empty
+		{
+			period:  "d",
+			expire:  time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC),
+			expFrom: time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC),
+			exp:     time.Date(2026, 5, 21, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for index, test := range testCases {
+		gotFrom, gotTo := lib.BackfillRange(test.period, from, to, test.expire)
+		if !gotFrom.Equal(test.expFrom) || !gotTo.Equal(test.exp) {
+			t.Errorf("test number %d, expected %v - %v, got %v - %v", index+1, test.expFrom, test.exp, gotFrom, gotTo)
+		}
+	}
+}
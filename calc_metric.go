@@ -0,0 +1,1398 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CalcMetricData structure to hold metric calculation data
+type CalcMetricData struct {
+	Hist              bool
+	MultiValue        bool
+	EscapeValueName   bool
+	AnnotationsRanges bool
+	SkipPast          bool
+	Desc              string
+	MergeSeries       string
+	CustomData        bool
+	SeriesNameMap     map[string]string
+	Drop              []string
+	ProjectScale      string
+	Mergeable         string
+	ExpireBefore      time.Time
+	Metric            string
+}
+
+// CalcMetricDryRun - single interval result printed by calc_metric in dry-run mode
+// It contains the final SQL (after all substitutions) and points that would be written
+type CalcMetricDryRun struct {
+	SeriesNameOrFunc string   `json:"series_name_or_func"`
+	SQLFile          string   `json:"sql_file"`
+	Period           string   `json:"period"`
+	From             string   `json:"from,omitempty"`
+	To               string   `json:"to,omitempty"`
+	SQL              string   `json:"sql"`
+	Points           TSPoints `json:"points"`
+}
+
+// MetricRun - single metric calculation statistics, saved into `gha_metrics_runs` table in `devstats` database
+// Metric is metrics.yaml metric name (empty when calc_metric is called without it), series can be a series function
+type MetricRun struct {
+	Metric           string
+	SeriesNameOrFunc string
+	SQLFile          string
+	Period           string
+	Hist             bool
+	Intervals        int
+	Rows             int
+	Points           int
+	SQLTime          time.Duration
+	WriteTime        time.Duration
+	mtx              sync.Mutex
+}
+
+// metricsRunsTableDef - metrics runs statistics table definition
+func metricsRunsTableDef() string {
+	return "gha_metrics_runs(" +
+		"id {{pkauto}}, " +
+		"dt {{tsnow}}, " +
+		"proj varchar(32) not null, " +
+		"series_name_or_func text not null, " +
+		"sql_file text not null, " +
+		"period text not null, " +
+		"hist boolean not null, " +
+		"n_intervals int not null, " +
+		"n_rows bigint not null, " +
+		"n_points bigint not null, " +
+		"sql_time double precision not null, " +
+		"write_time double precision not null, " +
+		"total_time double precision not null, " +
+		"metric text not null default ''" +
+		")"
+}
+
+// metricsRunsEnsured - metrics runs statistics table is checked once per process
+var metricsRunsEnsured sync.Once
+
+// metricsRunsSQL - returns statements creating metrics runs statistics table and its indexes if they don't exist
+func metricsRunsSQL() []string {
+	return []string{
+		createIfNotExists(metricsRunsTableDef()),
+		"alter table gha_metrics_runs add column if not exists metric text not null default ''",
+		"create index if not exists metrics_runs_dt_idx on gha_metrics_runs(dt)",
+		"create index if not exists metrics_runs_proj_idx on gha_metrics_runs(proj)",
+		"create index if not exists metrics_runs_sql_file_idx on gha_metrics_runs(sql_file)",
+	}
+}
+
+// EnsureMetricsRunsTable - creates metrics runs statistics table if needed
+// Statistics of all projects are stored in `devstats` database, project databases only migrate to keep schema versions in sync,
+// so this is called for `devstats` database by calc_metric, metrics_report and `structure migrate`
+func EnsureMetricsRunsTable(con *sql.DB, ctx *Ctx) error {
+	for _, stmt := range metricsRunsSQL() {
+		_, err := ExecSQL(con, ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add - adds statistics from a single calculation thread
+func (r *MetricRun) add(rows, points int, sqlTime, writeTime time.Duration) {
+	r.mtx.Lock()
+	r.Rows += rows
+	r.Points += points
+	r.SQLTime += sqlTime
+	r.WriteTime += writeTime
+	r.mtx.Unlock()
+}
+
+// saveMetricRun - saves metric run statistics into `devstats` database
+// It is skipped when DB logging is disabled and in dry-run mode, failures are only reported
+func saveMetricRun(ctx *Ctx, run *MetricRun, totalTime time.Duration) {
+	if !ctx.LogToDB || ctx.DryRun {
+		return
+	}
+	dctx := *ctx
+	dctx.PgDB = Devstats
+	con := PgConn(&dctx)
+	defer func() { _ = con.Close() }()
+	metricsRunsEnsured.Do(func() {
+		err := EnsureMetricsRunsTable(con, &dctx)
+		if err != nil {
+			Printf("warning: cannot create metrics runs statistics table in %s database: %v\n", Devstats, err)
+		}
+	})
+	_, err := ExecSQL(
+		con,
+		&dctx,
+		"insert into gha_metrics_runs(proj, metric, series_name_or_func, sql_file, period, hist, "+
+			"n_intervals, n_rows, n_points, sql_time, write_time, total_time) "+NValues(12),
+		ctx.Project,
+		run.Metric,
+		run.SeriesNameOrFunc,
+		getPathIndependentKey(run.SQLFile),
+		run.Period,
+		run.Hist,
+		run.Intervals,
+		run.Rows,
+		run.Points,
+		run.SQLTime.Seconds(),
+		run.WriteTime.Seconds(),
+		totalTime.Seconds(),
+	)
+	if err != nil {
+		Printf("warning: cannot save metric run statistics for '%s': %v\n", run.SQLFile, err)
+	}
+}
+
+// printDryRun - prints dry-run result as a single JSON line on stdout
+func printDryRun(dr *CalcMetricDryRun) {
+	if dr.Points == nil {
+		dr.Points = TSPoints{}
+	}
+	data, err := json.Marshal(dr)
+	FatalOnError(err)
+	_, err = os.Stdout.Write(append(data, '\n'))
+	FatalOnError(err)
+}
+
+// some metrics can define series_name_map to change internal series names generated
+func mapName(cfg *CalcMetricData, name string) string {
+	if cfg.SeriesNameMap == nil {
+		return name
+	}
+	for k, v := range cfg.SeriesNameMap {
+		name = strings.Replace(name, k, v, -1)
+	}
+	return name
+}
+
+// Returns multi row and multi column series names array (different for different rows)
+// Each row must be in format: 'prefix;rowName;series1,series2,..,seriesN' serVal1 serVal2 ... serValN
+// if multivalue is true then rowName is not used for generating series name
+// Series name is independent from rowName, and metric returns "series_name;rowName"
+// Multivalue series can even have partialy multivalue row: "this_comes_to_multivalues`this_comes_to_series_name", separator is `
+func multiRowMultiColumn(cfg *CalcMetricData, expr string, multivalue, escapeValueName bool) (result []string) {
+	ary := strings.Split(expr, ";")
+	pref := ary[0]
+	if pref == "" {
+		Printf("multiRowMultiColumn: Info: prefix '%v' (ary=%+v,expr=%+v,mv=%+v,data=%+v) skipping\n", pref, ary, expr, multivalue, *cfg)
+		return
+	}
+	splitColumns := strings.Split(ary[2], ",")
+	if multivalue {
+		rowNameAry := strings.Split(ary[1], "`")
+		rowName := rowNameAry[0]
+		if escapeValueName {
+			rowName = NormalizeName(rowName)
+		}
+		rowName = mapName(cfg, rowName)
+		if len(rowNameAry) > 1 {
+			rowNameNonMulti := NormalizeName(rowNameAry[1])
+			for _, series := range splitColumns {
+				result = append(result, fmt.Sprintf("%s%s%s;%s", pref, rowNameNonMulti, series, rowName))
+			}
+			return
+		}
+		for _, series := range splitColumns {
+			result = append(result, fmt.Sprintf("%s%s;%s", pref, series, rowName))
+		}
+		return
+	}
+	rowName := NormalizeName(ary[1])
+	if rowName == "" {
+		Printf("multiRowMultiColumn: Info: rowName '%v' (%+v) maps to empty string, skipping\n", ary[1], ary)
+		return
+	}
+	rowName = mapName(cfg, rowName)
+	for _, series := range splitColumns {
+		result = append(result, fmt.Sprintf("%s%s%s", pref, rowName, series))
+	}
+	return
+}
+
+// Return default series names from multi row result single column
+// Each row is "prefix,rowName", value (prefix is hardcoded in metric, so it is assumed safe)
+// and returns array [a_q, b_q, c_q, .., z_q]
+// if multivalue is true then rowName is not used for generating series name
+// Series name is independent from rowName, and metric returns "series_name;rowName"
+// Multivalue series can even have partialy multivalue row: "this_comes_to_multivalues`this_comes_to_series_name", separator is `
+func multiRowSingleColumn(cfg *CalcMetricData, col string, multivalue, escapeValueName bool) (result []string) {
+	ary := strings.Split(col, ",")
+	pref := ary[0]
+	if pref == "" {
+		Printf("multiRowSingleColumn: Info: prefix '%v' (ary=%+v,col=%+v,mv=%+v,data=%+v) skipping\n", pref, ary, col, multivalue, *cfg)
+		return
+	}
+	if multivalue {
+		rowNameAry := strings.Split(ary[1], "`")
+		rowName := rowNameAry[0]
+		if escapeValueName {
+			rowName = NormalizeName(rowName)
+		}
+		rowName = mapName(cfg, rowName)
+		if len(rowNameAry) > 1 {
+			rowNameNonMulti := NormalizeName(rowNameAry[1])
+			return []string{fmt.Sprintf("%s%s;%s", pref, rowNameNonMulti, rowName)}
+		}
+		return []string{fmt.Sprintf("%s;%s", pref, rowName)}
+	}
+	rowName := NormalizeName(ary[1])
+	if rowName == "" {
+		Printf("multiRowSingleColumn: Info: rowName '%v' (%+v) maps to empty string, skipping\n", ary[1], ary)
+		return
+	}
+	rowName = mapName(cfg, rowName)
+	return []string{fmt.Sprintf("%s%s", pref, rowName)}
+}
+
+// Generate name for given series row and period
+func nameForMetricsRow(cfg *CalcMetricData, metric, name string, multivalue, escapeValueName bool) []string {
+	switch metric {
+	case "single_row_multi_column":
+		return strings.Split(name, ",")
+	case "multi_row_single_column":
+		return multiRowSingleColumn(cfg, name, multivalue, escapeValueName)
+	case "multi_row_multi_column":
+		return multiRowMultiColumn(cfg, name, multivalue, escapeValueName)
+	default:
+		Printf("Error\nUnknown metric '%v'\n", metric)
+		fmt.Fprintf(os.Stdout, "Error\nUnknown metric '%v'\n", metric)
+		os.Exit(1)
+	}
+	return []string{""}
+}
+
+func mergeESSeriesName(mergeSeries, sqlFile string) string {
+	if mergeSeries != "" {
+		return mergeSeries
+	}
+	ary := strings.Split(sqlFile, "/")
+	l := len(ary)
+	series := ary[l-1]
+	ary = strings.Split(series, ".")
+	series = strings.TrimSpace(ary[0])
+	return series
+}
+
+func randString() string {
+	return fmt.Sprintf("%d", rand.Uint64())
+}
+
+func calcRange(
+	ch chan bool,
+	ctx *Ctx,
+	sqlc *sql.DB,
+	seriesNameOrFunc, sqlFile, sqlQueryOrig, excludeBots, period string,
+	cfg *CalcMetricData,
+	nIntervals int,
+	dtAry, fromAry, toAry []time.Time,
+	mut *sync.Mutex,
+	run *MetricRun,
+) {
+	// Optional ElasticSearch output
+	var es *ES
+	mergeSeriesES := ""
+	if ctx.UseES {
+		es = ESConn(ctx, "d_")
+		mergeSeriesES = mergeESSeriesName(cfg.MergeSeries, sqlFile)
+	}
+
+	// Get BatchPoints
+	var (
+		pts     TSPoints
+		nRows   int
+		sqlTime time.Duration
+	)
+	sqlQueryOrig = strings.Replace(sqlQueryOrig, "{{n}}", strconv.Itoa(nIntervals)+".0", -1)
+	sqlQueryOrig = strings.Replace(sqlQueryOrig, "{{exclude_bots}}", excludeBots, -1)
+	for idx, dt := range dtAry {
+		from := fromAry[idx]
+		to := toAry[idx]
+
+		// Prepare SQL query
+		sFrom := ToYMDHMSDate(from)
+		sTo := ToYMDHMSDate(to)
+		sHours := RangeHours(from, to)
+		sqlQuery := strings.Replace(sqlQueryOrig, "{{from}}", sFrom, -1)
+		sqlQuery = strings.Replace(sqlQuery, "{{to}}", sTo, -1)
+		sqlQuery = strings.Replace(sqlQuery, "{{range}}", sHours, -1)
+		sqlQuery = strings.Replace(sqlQuery, "{{project_scale}}", cfg.ProjectScale, -1)
+		sqlQuery = strings.Replace(sqlQuery, "{{rnd}}", randString(), -1)
+		nPts := len(pts)
+
+		// Execute SQL query
+		dtq := time.Now()
+		rows := QuerySQLWithErr(sqlc, ctx, sqlQuery)
+
+		// Get Number of columns
+		// We support either query returnign single row with single numeric value
+		// Or multiple rows, each containing string (series name) and its numeric value(s)
+		columns, err := rows.Columns()
+		FatalOnError(err)
+		nColumns := len(columns)
+
+		// Use value descriptions?
+		useDesc := cfg.Desc != ""
+
+		// Metric Results, assume they're floats
+		var (
+			pValue  *float64
+			value   float64
+			name    string
+			cFloat  float64
+			cTime   time.Time
+			cString string
+		)
+		// Single row & single column result
+		if nColumns == 1 {
+			rowCount := 0
+			for rows.Next() {
+				FatalOnError(rows.Scan(&pValue))
+				rowCount++
+			}
+			nRows += rowCount
+			FatalOnError(rows.Err())
+			FatalOnError(rows.Close())
+			if rowCount != 1 {
+				Printf(
+					"Error:\nQuery should return either single value or "+
+						"multiple rows, each containing string and numbers\n"+
+						"Got %d rows, each containing single number\nQuery:%s\n",
+					rowCount, sqlQuery,
+				)
+			}
+			// Handle nulls
+			if pValue != nil {
+				value = *pValue
+			}
+			// In this simplest case 1 row, 1 column - series name is taken directly from YAML (metrics.yaml)
+			// It usually uses `add_period_to_name: true` to have _period suffix, period{=h,d,w,m,q,y}
+			name = seriesNameOrFunc
+			if ctx.Debug > 0 {
+				Printf("%v - %v -> %v, %v\n", from, to, name, value)
+			}
+			// Add batch point
+			fields := map[string]interface{}{"value": value}
+			if useDesc {
+				fields["descr"] = valueDescription(cfg.Desc, value)
+			}
+			AddTSPoint(
+				ctx,
+				&pts,
+				NewTSPoint(ctx, name, period, nil, fields, dt, false),
+			)
+		} else if nColumns >= 2 {
+			// Multiple rows, each with (series name, value(s))
+			// Alocate nColumns numeric values (first is series name)
+			pValues := make([]interface{}, nColumns)
+			for i := range columns {
+				pValues[i] = new(sql.RawBytes)
+			}
+			allFields := make(map[string]map[string]interface{})
+			for rows.Next() {
+				// Get row values
+				FatalOnError(rows.Scan(pValues...))
+				nRows++
+				// Get first column name, and using it all series names
+				// First column should contain nColumns - 1 names separated by ","
+				name := string(*pValues[0].(*sql.RawBytes))
+				names := nameForMetricsRow(cfg, seriesNameOrFunc, name, cfg.MultiValue, cfg.EscapeValueName)
+				if ctx.Debug > 0 {
+					Printf("nameForMetricsRow: %s -> %v\n", name, names)
+				}
+				if len(names) > 0 {
+					// Iterate values
+					if cfg.CustomData {
+						pCustVals := pValues[1:]
+						// values tripples (time, float, string)
+						for idx, pVal := range pCustVals {
+							valType := idx % 3
+							cidx := idx / 3
+							if valType == 0 {
+								if pVal != nil {
+									sTime := string(*pVal.(*sql.RawBytes))
+									cTime = TimeParseAny(sTime)
+								} else {
+									cTime = time.Now()
+								}
+							} else if valType == 1 {
+								if pVal != nil {
+									cFloat, _ = strconv.ParseFloat(string(*pVal.(*sql.RawBytes)), 64)
+								} else {
+									cFloat = 0.0
+								}
+							} else {
+								if pVal != nil {
+									cString = string(*pVal.(*sql.RawBytes))
+								} else {
+									cString = ""
+								}
+								if cfg.MultiValue {
+									nameArr := strings.Split(names[cidx], ";")
+									seriesName := nameArr[0]
+									seriesValueName := nameArr[1]
+									if ctx.Debug > 0 {
+										Printf("%v - %v -> (%v, %v): %v[%v], (%v, %v, %v)\n", from, to, idx, cidx, seriesName, seriesValueName, cTime, cFloat, cString)
+									}
+									if _, ok := allFields[seriesName]; !ok {
+										allFields[seriesName] = make(map[string]interface{})
+									}
+									allFields[seriesName][seriesValueName+"_t"] = cTime
+									allFields[seriesName][seriesValueName+"_v"] = cFloat
+									allFields[seriesName][seriesValueName+"_s"] = cString
+								} else {
+									name = names[cidx]
+									if ctx.Debug > 0 {
+										Printf("%v - %v -> (%v, %v): %v, (%v, %v, %v)\n", from, to, idx, cidx, name, cTime, cFloat, cString)
+									}
+									// Add batch point
+									fields := map[string]interface{}{"value": cFloat, "str": cString, "dt": cTime}
+									if useDesc {
+										fields["descr"] = valueDescription(cfg.Desc, cFloat)
+									}
+									AddTSPoint(
+										ctx,
+										&pts,
+										NewTSPoint(ctx, name, period, nil, fields, cTime, true),
+									)
+								}
+							}
+						}
+					} else {
+						pFloats := pValues[1:]
+						for idx, pVal := range pFloats {
+							if pVal != nil {
+								value, _ = strconv.ParseFloat(string(*pVal.(*sql.RawBytes)), 64)
+							} else {
+								value = 0.0
+							}
+							if cfg.MultiValue {
+								nameArr := strings.Split(names[idx], ";")
+								seriesName := nameArr[0]
+								seriesValueName := nameArr[1]
+								if ctx.Debug > 0 {
+									Printf("%v - %v -> %v: %v[%v], %v\n", from, to, idx, seriesName, seriesValueName, value)
+								}
+								if _, ok := allFields[seriesName]; !ok {
+									allFields[seriesName] = make(map[string]interface{})
+								}
+								allFields[seriesName][seriesValueName] = value
+							} else {
+								name = names[idx]
+								if ctx.Debug > 0 {
+									Printf("%v - %v -> %v: %v, %v\n", from, to, idx, name, value)
+								}
+								// Add batch point
+								fields := map[string]interface{}{"value": value}
+								if useDesc {
+									fields["descr"] = valueDescription(cfg.Desc, value)
+								}
+								AddTSPoint(
+									ctx,
+									&pts,
+									NewTSPoint(ctx, name, period, nil, fields, dt, false),
+								)
+							}
+						}
+					}
+				}
+			}
+			// Multivalue series if any
+			for seriesName, seriesValues := range allFields {
+				AddTSPoint(
+					ctx,
+					&pts,
+					NewTSPoint(ctx, seriesName, period, nil, seriesValues, dt, cfg.CustomData),
+				)
+			}
+			FatalOnError(rows.Err())
+			FatalOnError(rows.Close())
+		}
+		sqlTime += time.Since(dtq)
+		if ctx.DryRun {
+			printDryRun(
+				&CalcMetricDryRun{
+					SeriesNameOrFunc: seriesNameOrFunc,
+					SQLFile:          sqlFile,
+					Period:           period,
+					From:             sFrom,
+					To:               sTo,
+					SQL:              sqlQuery,
+					Points:           pts[nPts:],
+				},
+			)
+		}
+	}
+	// Write the batch
+	dtw := time.Now()
+	if !ctx.SkipTSDB && !ctx.UseESOnly && !ctx.UsePromOnly {
+		err := WriteTSPointsE(ctx, sqlc, &pts, cfg.MergeSeries, mut)
+		if err != nil {
+			Printf("Skipped invalid series: %v\n", err)
+		}
+		UpdateSeriesCatalog(sqlc, ctx, SeriesCatalogEntries(&pts, cfg.MergeSeries, getPathIndependentKey(sqlFile), err))
+		// Invalid series are not written to other outputs either
+		pts = ValidTSPoints(&pts, err)
+	} else if ctx.Debug > 0 {
+		Printf("Skipping series write\n")
+	}
+	if ctx.UseES {
+		es.WriteESPoints(ctx, &pts, mergeSeriesES, [3]bool{false, false, true})
+	}
+	if ctx.UseProm {
+		WritePromPoints(ctx, &pts, cfg.MergeSeries)
+	}
+	if ctx.UseInflux {
+		WriteInfluxPoints(ctx, &pts, cfg.MergeSeries)
+	}
+	run.add(nRows, len(pts), sqlTime, time.Since(dtw))
+
+	// Synchronize go routine
+	if ch != nil {
+		ch <- true
+	}
+}
+
+// getPathIndependentKey (return path value independent from install path
+// /etc/gha2db/metrics/kubernetes/key.sql --> kubernetes/key.sql
+// ./metrics/kubernetes/key.sql --> kubernetes/key.sql
+func getPathIndependentKey(key string) string {
+	keyAry := strings.Split(key, "/")
+	length := len(keyAry)
+	if length < 3 {
+		return key
+	}
+	return keyAry[length-2] + "/" + keyAry[length-1]
+}
+
+// isAlreadyComputed check if given quick range period was already computed
+// It will skip past period marked as computed unless special flags are passed
+func isAlreadyComputed(con *sql.DB, ctx *Ctx, key, sdt string) bool {
+	key = getPathIndependentKey(key)
+	dt := TimeParseAny(sdt)
+	rows := QuerySQLWithErr(
+		con,
+		ctx,
+		fmt.Sprintf(
+			"select 1 from gha_computed where "+
+				"metric = %s and dt = %s",
+			NValue(1),
+			NValue(2),
+		),
+		key,
+		dt,
+	)
+	defer func() { FatalOnError(rows.Close()) }()
+	i := 0
+	for rows.Next() {
+		FatalOnError(rows.Scan(&i))
+	}
+	FatalOnError(rows.Err())
+	return i > 0
+}
+
+// setAlreadyComputed marks given quick range period as computed
+// Should be called inside: if !ctx.SkipTSDB { ... }
+func setAlreadyComputed(con *sql.DB, ctx *Ctx, key, sdt string) {
+	key = getPathIndependentKey(key)
+	dt := TimeParseAny(sdt)
+	ExecSQLWithErr(
+		con,
+		ctx,
+		InsertIgnore("into gha_computed(metric, dt) "+NValues(2)),
+		key,
+		dt,
+	)
+}
+
+func handleSeriesDrop(ctx *Ctx, con *sql.DB, cfg *CalcMetricData) {
+	if cfg.Hist && len(cfg.Drop) > 0 {
+		Fatalf("you cannot use drop series property on histogram metrics: %+v", &cfg)
+	}
+	if !ctx.EnableMetricsDrop {
+		return
+	}
+	for _, table := range cfg.Drop {
+		if !ctx.SkipTSDB {
+			if TableExists(con, ctx, table) {
+				if ctx.Debug >= 0 {
+					Printf("Truncating table %s\n", table)
+				}
+				// ExecSQLWithErr(con, ctx, "truncate "+table)
+				// ExecSQLWithErr(con, ctx, "drop table if exists "+table)
+				_, err := ExecSQL(con, ctx, "drop table "+table)
+				if err != nil {
+					Printf("warning: failed dropping table '%s': %+v\n", table, err)
+				}
+			}
+		}
+	}
+}
+
+func calcHistogram(ctx *Ctx, sqlc *sql.DB, seriesNameOrFunc, sqlFile, sqlQuery, excludeBots, interval, intervalAbbr string, nIntervals int, cfg *CalcMetricData, run *MetricRun) {
+
+	// Optional ElasticSearch output
+	var es *ES
+	mergeSeriesES := ""
+	if ctx.UseES {
+		es = ESConn(ctx, "d_")
+		mergeSeriesES = mergeESSeriesName(cfg.MergeSeries, sqlFile)
+	}
+
+	// Get BatchPoints
+	var pts TSPoints
+
+	Printf("calc_metric.go: Histogram running interval '%v,%v' n:%d anno:%v past:%v multi:%v\n", interval, intervalAbbr, nIntervals, cfg.AnnotationsRanges, cfg.SkipPast, cfg.MultiValue)
+
+	// If using annotations ranges, then get their values
+	// Mergeable histograms need an explicit time range (period ranges end now)
+	var qrDt *string
+	sqlOrig := sqlQuery
+	rangePeriod, rangeFrom, rangeTo := "", "", ""
+	if cfg.AnnotationsRanges {
+		// Get Quick Ranges from TSDB (it is filled by annotations command)
+		quickRanges := GetTagValues(sqlc, ctx, "quick_ranges", "quick_ranges_data")
+		if ctx.Debug > 0 {
+			Printf("Quick ranges: %+v\n", quickRanges)
+		}
+		found := false
+		for _, data := range quickRanges {
+			ary := strings.Split(data, ";")
+			sfx := ary[0]
+			if intervalAbbr == sfx {
+				found = true
+				Printf("Found quick range: %+v\n", ary)
+				period := ary[1]
+				from := ary[2]
+				to := ary[3]
+				// We can skip past data sometimes
+				if cfg.SkipPast && period == "" {
+					dtTo := TimeParseAny(to)
+					prevHour := PrevHourStart(time.Now())
+					if dtTo.Before(prevHour) && isAlreadyComputed(sqlc, ctx, sqlFile, to) {
+						Printf("Skipping past quick range: %v-%v (already computed)\n", from, to)
+						return
+					}
+				}
+				rangePeriod, rangeFrom, rangeTo = period, from, to
+				sHours := ""
+				sqlQuery, sHours = PrepareQuickRangeQuery(sqlQuery, period, from, to)
+				sqlQuery = strings.Replace(sqlQuery, "{{exclude_bots}}", excludeBots, -1)
+				sqlQuery = strings.Replace(sqlQuery, "{{range}}", sHours, -1)
+				sqlQuery = strings.Replace(sqlQuery, "{{project_scale}}", cfg.ProjectScale, -1)
+				sqlQuery = strings.Replace(sqlQuery, "{{rnd}}", randString(), -1)
+				if period == "" {
+					dtTo := TimeParseAny(to)
+					prevHour := PrevHourStart(time.Now())
+					if dtTo.Before(prevHour) {
+						qrDt = &to
+					}
+				}
+				break
+			}
+		}
+		if !found {
+			Fatalf("quick range not found: '%s' known quick ranges: %+v", intervalAbbr, quickRanges)
+		}
+	} else {
+		if strings.HasPrefix(intervalAbbr, "range:") {
+			ary := strings.Split(intervalAbbr[6:], ",")
+			if len(ary) != 2 {
+				Fatalf("range should be specified as 'range:YYYY-MM-DD,YYYY-MM-DD'\n")
+			}
+			sHours, from, to, period := "", ary[0], ary[1], ""
+			sqlQuery, sHours = PrepareQuickRangeQuery(sqlQuery, period, from, to)
+			from = ToYMDHMSDate(TimeParseAny(from))
+			to = ToYMDHMSDate(TimeParseAny(to))
+			intervalAbbr = "range:" + from + "," + to
+			rangeFrom, rangeTo = from, to
+			sqlQuery = strings.Replace(sqlQuery, "{{exclude_bots}}", excludeBots, -1)
+			sqlQuery = strings.Replace(sqlQuery, "{{range}}", sHours, -1)
+			sqlQuery = strings.Replace(sqlQuery, "{{project_scale}}", cfg.ProjectScale, -1)
+			sqlQuery = strings.Replace(sqlQuery, "{{rnd}}", randString(), -1)
+		} else {
+			// Prepare SQL query
+			dbInterval := fmt.Sprintf("%d %s", nIntervals, interval)
+			if interval == Quarter {
+				dbInterval = fmt.Sprintf("%d month", nIntervals*3)
+			}
+			rangePeriod = dbInterval
+			sHours := IntervalHours(dbInterval)
+			sqlQuery = strings.Replace(sqlQuery, "{{period}}", dbInterval, -1)
+			sqlQuery = strings.Replace(sqlQuery, "{{n}}", strconv.Itoa(nIntervals)+".0", -1)
+			sqlQuery = strings.Replace(sqlQuery, "{{rnd}}", randString(), -1)
+			sqlQuery = strings.Replace(sqlQuery, "{{exclude_bots}}", excludeBots, -1)
+			sqlQuery = strings.Replace(sqlQuery, "{{range}}", sHours, -1)
+			sqlQuery = strings.Replace(sqlQuery, "{{project_scale}}", cfg.ProjectScale, -1)
+		}
+	}
+
+	// Mergeable histogram: merge stored per day partial results instead of scanning the whole range
+	dtq := time.Now()
+	nRows := 0
+	if cfg.Mergeable != "" {
+		var from, to time.Time
+		if rangePeriod != "" {
+			FatalOnError(
+				QueryRowSQL(sqlc, ctx, "select now() - $1::interval, now()", rangePeriod).Scan(&from, &to),
+			)
+		} else {
+			from, to = TimeParseAny(rangeFrom), TimeParseAny(rangeTo)
+		}
+		histRows, nHistRows := MergeableHistogram(sqlc, ctx, sqlFile, sqlOrig, excludeBots, cfg, from.UTC(), to.UTC())
+		nRows = nHistRows
+		sqlQuery = histRangeQuery(sqlOrig, excludeBots, cfg, from.UTC(), to.UTC())
+		dropHistogramData(ctx, sqlc, es, cfg, seriesNameOrFunc, mergeSeriesES, intervalAbbr)
+		tm := TimeParseAny("2012-07-01")
+		for _, row := range histRows {
+			fields := map[string]interface{}{"name": row.Name, "value": row.Value}
+			AddTSPoint(
+				ctx,
+				&pts,
+				NewTSPoint(ctx, seriesNameOrFunc, intervalAbbr, nil, fields, tm, false),
+			)
+			tm = tm.Add(-time.Hour)
+		}
+		if ctx.Debug > 0 {
+			Printf("mergeable hist %v, %v %v: %v rows\n", seriesNameOrFunc, nIntervals, interval, len(histRows))
+		}
+		writeHistogram(ctx, sqlc, es, seriesNameOrFunc, sqlFile, sqlQuery, intervalAbbr, mergeSeriesES, cfg, pts, qrDt, run, nRows, time.Since(dtq))
+		return
+	}
+
+	// Execute SQL query
+	rows := QuerySQLWithErr(sqlc, ctx, sqlQuery)
+	defer func() { FatalOnError(rows.Close()) }()
+
+	// Get number of columns, for histograms there should be exactly 2 columns
+	columns, err := rows.Columns()
+	FatalOnError(err)
+	nColumns := len(columns)
+
+	// Expect 2 columns: string column with name and float column with value
+	var (
+		value float64
+		name  string
+	)
+	if nColumns == 2 {
+		dropHistogramData(ctx, sqlc, es, cfg, seriesNameOrFunc, mergeSeriesES, intervalAbbr)
+
+		// Add new data
+		tm := TimeParseAny("2012-07-01")
+		rowCount := 0
+		for rows.Next() {
+			FatalOnError(rows.Scan(&name, &value))
+			if ctx.Debug > 0 {
+				Printf("hist %v, %v %v -> %v, %v\n", seriesNameOrFunc, nIntervals, interval, name, value)
+			}
+			// Add batch point
+			fields := map[string]interface{}{"name": name, "value": value}
+			AddTSPoint(
+				ctx,
+				&pts,
+				NewTSPoint(ctx, seriesNameOrFunc, intervalAbbr, nil, fields, tm, false),
+			)
+			rowCount++
+			tm = tm.Add(-time.Hour)
+		}
+		nRows = rowCount
+		if ctx.Debug > 0 {
+			Printf("hist %v, %v %v: %v rows\n", seriesNameOrFunc, nIntervals, interval, rowCount)
+		}
+		FatalOnError(rows.Err())
+	} else if nColumns >= 3 {
+		var (
+			fValue  float64
+			sValue  string
+			s2Value string
+			dtValue time.Time
+		)
+		columns, err := rows.Columns()
+		FatalOnError(err)
+		nColumns := len(columns)
+		pValues := make([]interface{}, nColumns)
+		for i := range columns {
+			pValues[i] = new(sql.RawBytes)
+		}
+		seriesToClear := make(map[string]time.Time)
+		for rows.Next() {
+			// Get row values
+			FatalOnError(rows.Scan(pValues...))
+			nRows++
+			name := string(*pValues[0].(*sql.RawBytes))
+			names := nameForMetricsRow(cfg, seriesNameOrFunc, name, cfg.MultiValue, false)
+			if ctx.Debug > 0 {
+				Printf("nameForMetricsRow: %s -> %v\n", name, names)
+			}
+			// multivalue will return names as [ser_name1;a,b,c]
+			valueNames := []string{}
+			if cfg.MultiValue {
+				if len(names) > 1 {
+					Fatalf("should return only one series name when using multi value, got: %+v", names)
+				}
+				namesAry := strings.Split(names[0], ";")
+				names = []string{namesAry[0]}
+				if len(namesAry) > 1 {
+					valueNames = strings.Split(namesAry[1], ",")
+				}
+			}
+			nNames := len(names)
+			if cfg.MultiValue {
+				fields := map[string]interface{}{}
+				name = names[0]
+				for i, valueData := range valueNames {
+					va := strings.Split(valueData, ":")
+					valueName := va[0]
+					valueType := va[1]
+					if pValues[i+1] == nil {
+						fields[valueName] = nil
+						Fatalf("nulls are unsupported, name: %+v, i: %d, valueData: %s", name, i, valueData)
+					} else {
+						switch valueType {
+						case "s":
+							v := string(*pValues[i+1].(*sql.RawBytes))
+							fields[valueName] = v
+						case "f":
+							v, e := strconv.ParseFloat(string(*pValues[i+1].(*sql.RawBytes)), 64)
+							FatalOnError(e)
+							fields[valueName] = v
+						default:
+							Fatalf("unknown data type: %v (%v), i: %d, valuedata: %s", valueType, valueData, i, valueData)
+						}
+					}
+				}
+				tm, ok := seriesToClear[name]
+				if ok {
+					tm = tm.Add(-time.Hour)
+					seriesToClear[name] = tm
+				} else {
+					tm = TimeParseAny("2012-07-01")
+					seriesToClear[name] = tm
+				}
+				if ctx.Debug > 0 {
+					//Printf("hist %v, %v %v -> %+v\n", name, nIntervals, interval, fields)
+				}
+				// Add batch point
+				AddTSPoint(
+					ctx,
+					&pts,
+					NewTSPoint(ctx, name, intervalAbbr, nil, fields, tm, false),
+				)
+			} else {
+				if nNames > 0 {
+					if cfg.CustomData {
+						// seriesName + N * (name, dt_value, f_value, s_value) 4-tupples
+						for i := 0; i < nNames; i++ {
+							pName := pValues[4*i+1]
+							if pName != nil {
+								sValue = string(*pName.(*sql.RawBytes))
+							} else {
+								sValue = Nil
+							}
+							pDtVal := pValues[4*i+2]
+							if pDtVal != nil {
+								sTime := string(*pDtVal.(*sql.RawBytes))
+								dtValue = TimeParseAny(sTime)
+							} else {
+								dtValue = time.Now()
+							}
+							pVal := pValues[4*i+3]
+							if pVal != nil {
+								fValue, _ = strconv.ParseFloat(string(*pVal.(*sql.RawBytes)), 64)
+							} else {
+								fValue = 0.0
+							}
+							pSVal := pValues[4*i+4]
+							if pSVal != nil {
+								s2Value = string(*pSVal.(*sql.RawBytes))
+							} else {
+								s2Value = ""
+							}
+							name = names[i]
+							if ctx.Debug > 0 {
+								Printf("hist %v, %v %v -> %v, %v, %v, %v\n", name, nIntervals, interval, sValue, dtValue, fValue, s2Value)
+							}
+							tm, ok := seriesToClear[name]
+							if ok {
+								tm = tm.Add(-time.Hour)
+								seriesToClear[name] = tm
+							} else {
+								tm = TimeParseAny("2012-07-01")
+								seriesToClear[name] = tm
+							}
+							// Add batch point
+							fields := map[string]interface{}{"name": sValue, "value": fValue, "str": s2Value, "dt": dtValue}
+							AddTSPoint(
+								ctx,
+								&pts,
+								NewTSPoint(ctx, name, intervalAbbr, nil, fields, tm, false),
+							)
+						}
+					} else {
+						// seriesName + N * (name, value) pairs
+						for i := 0; i < nNames; i++ {
+							pName := pValues[2*i+1]
+							if pName != nil {
+								sValue = string(*pName.(*sql.RawBytes))
+							} else {
+								sValue = Nil
+							}
+							pVal := pValues[2*i+2]
+							if pVal != nil {
+								fValue, _ = strconv.ParseFloat(string(*pVal.(*sql.RawBytes)), 64)
+							} else {
+								fValue = 0.0
+							}
+							name = names[i]
+							if ctx.Debug > 0 {
+								Printf("hist %v, %v %v -> %v, %v\n", name, nIntervals, interval, sValue, fValue)
+							}
+							tm, ok := seriesToClear[name]
+							if ok {
+								tm = tm.Add(-time.Hour)
+								seriesToClear[name] = tm
+							} else {
+								tm = TimeParseAny("2012-07-01")
+								seriesToClear[name] = tm
+							}
+							// Add batch point
+							fields := map[string]interface{}{"name": sValue, "value": fValue}
+							AddTSPoint(
+								ctx,
+								&pts,
+								NewTSPoint(ctx, name, intervalAbbr, nil, fields, tm, false),
+							)
+						}
+					}
+				}
+			}
+		}
+		FatalOnError(rows.Err())
+		if len(seriesToClear) > 0 {
+			if !ctx.SkipTSDB {
+				if cfg.MergeSeries == "" {
+					for series := range seriesToClear {
+						table := "s" + series
+						if TableExists(sqlc, ctx, table) {
+							ExecSQLWithErr(sqlc, ctx, fmt.Sprintf("delete from \""+table+"\" where period = %s", NValue(1)), intervalAbbr)
+							if ctx.Debug > 0 {
+								Printf("Dropped from table %s with %s period\n", table, intervalAbbr)
+							}
+						}
+					}
+				} else {
+					table := "s" + cfg.MergeSeries
+					if TableExists(sqlc, ctx, table) {
+						for series := range seriesToClear {
+							ExecSQLWithErr(sqlc, ctx,
+								fmt.Sprintf(
+									"delete from \""+table+"\" where series = %s and period = %s",
+									NValue(1),
+									NValue(2),
+								),
+								series,
+								intervalAbbr,
+							)
+							if ctx.Debug > 0 {
+								Printf("Dropped from table %s with %s series and %s period\n", table, series, intervalAbbr)
+							}
+						}
+					}
+				}
+			}
+			if ctx.UseES {
+				if es.IndexExists(ctx) {
+					for series := range seriesToClear {
+						es.DeleteByQuery(ctx, []string{"type", "series", "period"}, []interface{}{mergeSeriesES, series, intervalAbbr})
+						if ctx.Debug > 0 {
+							Printf("Dropped data from index with %s type and %s series and %s period\n", mergeSeriesES, seriesNameOrFunc, intervalAbbr)
+						}
+					}
+				}
+			}
+		}
+	}
+	writeHistogram(ctx, sqlc, es, seriesNameOrFunc, sqlFile, sqlQuery, intervalAbbr, mergeSeriesES, cfg, pts, qrDt, run, nRows, time.Since(dtq))
+}
+
+// dropHistogramData - drops existing histogram data for a given series and period before writing new data
+func dropHistogramData(ctx *Ctx, sqlc *sql.DB, es *ES, cfg *CalcMetricData, seriesNameOrFunc, mergeSeriesES, intervalAbbr string) {
+	if !ctx.SkipTSDB {
+		// Drop existing data
+		if cfg.MergeSeries == "" {
+			table := "s" + seriesNameOrFunc
+			if TableExists(sqlc, ctx, table) {
+				ExecSQLWithErr(sqlc, ctx, fmt.Sprintf("delete from \""+table+"\" where period = %s", NValue(1)), intervalAbbr)
+				if ctx.Debug > 0 {
+					Printf("Dropped data from %s table with %s period\n", table, intervalAbbr)
+				}
+			}
+		} else {
+			table := "s" + cfg.MergeSeries
+			if TableExists(sqlc, ctx, table) {
+				ExecSQLWithErr(sqlc, ctx,
+					fmt.Sprintf(
+						"delete from \""+table+"\" where series = %s and period = %s",
+						NValue(1),
+						NValue(2),
+					),
+					seriesNameOrFunc,
+					intervalAbbr,
+				)
+				if ctx.Debug > 0 {
+					Printf("Dropped data from %s table with %s series and %s period\n", table, seriesNameOrFunc, intervalAbbr)
+				}
+			}
+		}
+	}
+	if ctx.UseES {
+		if es.IndexExists(ctx) {
+			es.DeleteByQuery(ctx, []string{"type", "series", "period"}, []interface{}{mergeSeriesES, seriesNameOrFunc, intervalAbbr})
+			if ctx.Debug > 0 {
+				Printf("Dropped data from index with %s type and %s series and %s period\n", mergeSeriesES, seriesNameOrFunc, intervalAbbr)
+			}
+		}
+	}
+}
+
+// writeHistogram - writes histogram points to all configured outputs and saves run statistics
+func writeHistogram(ctx *Ctx, sqlc *sql.DB, es *ES, seriesNameOrFunc, sqlFile, sqlQuery, intervalAbbr, mergeSeriesES string, cfg *CalcMetricData, pts TSPoints, qrDt *string, run *MetricRun, nRows int, sqlTime time.Duration) {
+	if ctx.DryRun {
+		printDryRun(
+			&CalcMetricDryRun{
+				SeriesNameOrFunc: seriesNameOrFunc,
+				SQLFile:          sqlFile,
+				Period:           intervalAbbr,
+				SQL:              sqlQuery,
+				Points:           pts,
+			},
+		)
+	}
+	// Write the batch
+	dtw := time.Now()
+	if !ctx.SkipTSDB && !ctx.UseESOnly && !ctx.UsePromOnly {
+		err := WriteTSPointsE(ctx, sqlc, &pts, cfg.MergeSeries, nil)
+		if err != nil {
+			Printf("Skipped invalid series: %v\n", err)
+		}
+		UpdateSeriesCatalog(sqlc, ctx, SeriesCatalogEntries(&pts, cfg.MergeSeries, getPathIndependentKey(sqlFile), err))
+		// Mark this metric & period as already computed if this is a QR period, unless some series were skipped,
+		// so they are retried by the next run; invalid series are not written to other outputs either
+		if qrDt != nil && err == nil {
+			setAlreadyComputed(sqlc, ctx, sqlFile, *qrDt)
+		}
+		pts = ValidTSPoints(&pts, err)
+	} else if ctx.Debug > 0 {
+		Printf("Skipping series write\n")
+	}
+	if ctx.UseES {
+		es.WriteESPoints(ctx, &pts, mergeSeriesES, [3]bool{false, false, true})
+	}
+	if ctx.UseProm {
+		WritePromPoints(ctx, &pts, cfg.MergeSeries)
+	}
+	if ctx.UseInflux {
+		WriteInfluxPoints(ctx, &pts, cfg.MergeSeries)
+	}
+	run.add(nRows, len(pts), sqlTime, time.Since(dtw))
+}
+
+// MetricsCalculator calculates metrics in-process, it is used by calc_metric and gha2db_sync tools
+// It shares Postgres connection pool and caches SQL files between metric calculations
+type MetricsCalculator struct {
+	ctx  *Ctx
+	con  *sql.DB
+	mtx  sync.Mutex
+	sqls map[string]string
+}
+
+// NewMetricsCalculator - returns metrics calculator using given context and Postgres connection pool
+func NewMetricsCalculator(ctx *Ctx, con *sql.DB) *MetricsCalculator {
+	return &MetricsCalculator{ctx: ctx, con: con, sqls: make(map[string]string)}
+}
+
+// readSQL - returns SQL file contents, each file is only read once
+func (mc *MetricsCalculator) readSQL(ctx *Ctx, path string) string {
+	mc.mtx.Lock()
+	defer mc.mtx.Unlock()
+	sql, ok := mc.sqls[path]
+	if ok {
+		return sql
+	}
+	bytes, err := ReadFile(ctx, path)
+	FatalOnError(err)
+	sql = string(bytes)
+	mc.sqls[path] = sql
+	return sql
+}
+
+// ParseCalcMetricOptions - parses calc_metric options: comma separated list of
+// hist,desc:time_diff_as_string,multivalue,escape_value_name,annotations_ranges,skip_past,merge_series:name,
+// custom_data,drop:table1;table2,series_name_map:map[a:b c:d],project_scale:float,mergeable:sum|max|min,
+// expire_before:unix_timestamp (periods ending before this time are expired by retention rules and not recalculated)
+func ParseCalcMetricOptions(options string) *CalcMetricData {
+	cfg := &CalcMetricData{ProjectScale: "1.0"}
+	if options == "" {
+		return cfg
+	}
+	opts := strings.Split(options, ",")
+	optMap := make(map[string]string)
+	for _, opt := range opts {
+		optArr := strings.Split(opt, ":")
+		optName := optArr[0]
+		optVal := ""
+		if len(optArr) > 1 {
+			optVal = optArr[1]
+		}
+		if optName == "series_name_map" {
+			optMap[optName] = strings.Join(optArr[1:], ":")
+		} else {
+			optMap[optName] = optVal
+		}
+	}
+	if _, ok := optMap["hist"]; ok {
+		cfg.Hist = true
+	}
+	if _, ok := optMap["multivalue"]; ok {
+		cfg.MultiValue = true
+	}
+	if _, ok := optMap["escape_value_name"]; ok {
+		cfg.EscapeValueName = true
+	}
+	if _, ok := optMap["annotations_ranges"]; ok {
+		cfg.AnnotationsRanges = true
+	}
+	if _, ok := optMap["skip_past"]; ok {
+		cfg.SkipPast = true
+	}
+	if d, ok := optMap["desc"]; ok {
+		cfg.Desc = d
+	}
+	if d, ok := optMap["drop"]; ok {
+		cfg.Drop = strings.Split(d, ";")
+	}
+	if ms, ok := optMap["merge_series"]; ok {
+		cfg.MergeSeries = ms
+	}
+	if _, ok := optMap["custom_data"]; ok {
+		cfg.CustomData = true
+	}
+	if snm, ok := optMap["series_name_map"]; ok {
+		cfg.SeriesNameMap = MapFromString(snm)
+	}
+	if pss, ok := optMap["project_scale"]; ok {
+		ps, err := strconv.ParseFloat(pss, 64)
+		if err == nil && ps >= 0.0 {
+			cfg.ProjectScale = fmt.Sprintf("%f", ps)
+		}
+	}
+	if m, ok := optMap["mergeable"]; ok {
+		cfg.Mergeable = m
+	}
+	if eb, ok := optMap["expire_before"]; ok {
+		ts, err := strconv.ParseInt(eb, 10, 64)
+		if err == nil {
+			cfg.ExpireBefore = time.Unix(ts, 0).UTC()
+		}
+	}
+	if m, ok := optMap["metric"]; ok {
+		name, err := url.QueryUnescape(m)
+		if err == nil {
+			cfg.Metric = name
+		}
+	}
+	return cfg
+}
+
+// CalcMetric - calculates metric (or histogram when cfg.Hist is set) using given series name or function,
+// SQL file, date range and period, writes results to TSDB and/or ElasticSearch
+func (mc *MetricsCalculator) CalcMetric(seriesNameOrFunc, sqlFile, from, to, intervalAbbr string, cfg *CalcMetricData) {
+	if intervalAbbr == "" {
+		Fatalf("you need to define period")
+	}
+	if cfg.Desc != "" {
+		FatalOnError(CheckValueDescription(cfg.Desc))
+	}
+	// Each calculation uses its own copy of the context
+	dtStart := time.Now()
+	ctx := *mc.ctx
+
+	// Dry-run mode: only print final SQLs and resulting points, do not write TSDB, ES or other outputs
+	if ctx.DryRun {
+		ctx.SkipTSDB = true
+		ctx.UseES = false
+		ctx.UseESOnly = false
+		ctx.UseProm = false
+		ctx.UsePromOnly = false
+		ctx.UseInflux = false
+	}
+
+	// Local or cron mode?
+	dataPrefix := ctx.DataDir
+	if ctx.Local {
+		dataPrefix = "./"
+	}
+
+	// Read SQL file.
+	sqlQuery := mc.readSQL(&ctx, sqlFile)
+
+	// Read bots exclusion partial SQL
+	excludeBots := mc.readSQL(&ctx, dataPrefix+"util_sql/exclude_bots.sql")
+
+	// Process interval
+	allowUnknowns := cfg.AnnotationsRanges
+	if !allowUnknowns {
+		allowUnknowns = strings.HasPrefix(intervalAbbr, "range:")
+	}
+	interval, nIntervals, intervalStart, nextIntervalStart, prevIntervalStart := GetIntervalFunctions(intervalAbbr, allowUnknowns)
+	run := &MetricRun{Metric: cfg.Metric, SeriesNameOrFunc: seriesNameOrFunc, SQLFile: sqlFile, Period: intervalAbbr, Hist: cfg.Hist, Intervals: 1}
+
+	if cfg.Hist {
+		calcHistogram(
+			&ctx,
+			mc.con,
+			seriesNameOrFunc,
+			sqlFile,
+			sqlQuery,
+			excludeBots,
+			interval,
+			intervalAbbr,
+			nIntervals,
+			cfg,
+			run,
+		)
+		saveMetricRun(&ctx, run, time.Since(dtStart))
+		return
+	}
+
+	// Handle 'drop:' metric flag
+	handleSeriesDrop(&ctx, mc.con, cfg)
+
+	// Parse input dates
+	dFrom := TimeParseAny(from)
+	dTo := TimeParseAny(to)
+
+	// Round dates to the given interval
+	dFrom = intervalStart(dFrom)
+	dTo = nextIntervalStart(dTo)
+
+	// Do not recalculate periods expired by retention rules
+	if !cfg.ExpireBefore.IsZero() && dFrom.Before(cfg.ExpireBefore) {
+		dFrom = intervalStart(cfg.ExpireBefore)
+		if ctx.Debug > 0 {
+			Printf("calc_metric.go: %s: skipping periods expired before %v\n", sqlFile, cfg.ExpireBefore)
+		}
+		if !dFrom.Before(dTo) {
+			Printf("calc_metric.go: %s: whole range %s - %s expired before %v, nothing to calculate\n", sqlFile, from, to, cfg.ExpireBefore)
+			saveMetricRun(&ctx, run, time.Since(dtStart))
+			return
+		}
+	}
+
+	// Get number of CPUs available
+	thrN := GetThreadsNum(&ctx)
+
+	// Run
+	Printf(
+		"calc_metric.go: %s: Running (on %d CPUs): %v - %v with interval %d %s, descriptions '%s', multivalue: %v, escape_value_name: %v, custom_data: %v\n",
+		sqlFile, thrN, dFrom, dTo, nIntervals, interval, cfg.Desc, cfg.MultiValue, cfg.EscapeValueName, cfg.CustomData,
+	)
+
+	dt := dFrom
+	dta := [][]time.Time{}
+	ndta := [][]time.Time{}
+	pdta := [][]time.Time{}
+	i := 0
+	var pDt time.Time
+	for dt.Before(dTo) {
+		nDt := nextIntervalStart(dt)
+		if nIntervals <= 1 {
+			pDt = dt
+		} else {
+			pDt = AddNIntervals(dt, 1-nIntervals, nextIntervalStart, prevIntervalStart)
+		}
+		t := i % thrN
+		if len(dta) < t+1 {
+			dta = append(dta, []time.Time{})
+		}
+		if len(ndta) < t+1 {
+			ndta = append(ndta, []time.Time{})
+		}
+		if len(pdta) < t+1 {
+			pdta = append(pdta, []time.Time{})
+		}
+		dta[t] = append(dta[t], dt)
+		ndta[t] = append(ndta[t], nDt)
+		pdta[t] = append(pdta[t], pDt)
+		dt = nDt
+		i++
+	}
+	ldt := len(dta)
+	run.Intervals = i
+	if thrN > 1 {
+		mut := &sync.Mutex{}
+		ch := make(chan bool)
+		for i := 0; i < thrN; i++ {
+			if i == ldt {
+				break
+			}
+			go calcRange(
+				ch,
+				&ctx,
+				mc.con,
+				seriesNameOrFunc,
+				sqlFile,
+				sqlQuery,
+				excludeBots,
+				intervalAbbr,
+				cfg,
+				nIntervals,
+				dta[i],
+				pdta[i],
+				ndta[i],
+				mut,
+				run,
+			)
+		}
+		nThreads := ldt
+		for nThreads > 0 {
+			<-ch
+			nThreads--
+		}
+	} else {
+		Printf("Using single threaded version\n")
+		for i := 0; i < ldt; i++ {
+			calcRange(
+				nil,
+				&ctx,
+				mc.con,
+				seriesNameOrFunc,
+				sqlFile,
+				sqlQuery,
+				excludeBots,
+				intervalAbbr,
+				cfg,
+				nIntervals,
+				dta[i],
+				pdta[i],
+				ndta[i],
+				nil,
+				run,
+			)
+		}
+	}
+	saveMetricRun(&ctx, run, time.Since(dtStart))
+	// Finished
+	Printf("All done.\n")
+}
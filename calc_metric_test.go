@@ -0,0 +1,99 @@
+package devstatscode
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestParseCalcMetricOptions(t *testing.T) {
+	// Test cases
+	var testCases = []struct {
+		options  string
+		expected lib.CalcMetricData
+	}{
+		{
+			options:  "",
+			expected: lib.CalcMetricData{ProjectScale: "1.0"},
+		},
+		{
+			options:  "hist,multivalue,escape_value_name,annotations_ranges,skip_past,custom_data",
+			expected: lib.CalcMetricData{Hist: true, MultiValue: true, EscapeValueName: true, AnnotationsRanges: true, SkipPast: true, CustomData: true, ProjectScale: "1.0"},
+		},
+		{
+			options:  "desc:time_diff_as_string,merge_series:prs_age,drop:sa;sb,project_scale:2",
+			expected: lib.CalcMetricData{Desc: "time_diff_as_string", MergeSeries: "prs_age", Drop: []string{"sa", "sb"}, ProjectScale: "2.000000"},
+		},
+		{
+			options:  "project_scale:-1,series_name_map:map[a:b c:d]",
+			expected: lib.CalcMetricData{SeriesNameMap: map[string]string{"a": "b", "c": "d"}, ProjectScale: "1.0"},
+		},
+		{
+			options:  "hist,mergeable:max",
+			expected: lib.CalcMetricData{Hist: true, Mergeable: "max", ProjectScale: "1.0"},
+		},
+		{
+			options:  "expire_before:1614556800",
+			expected: lib.CalcMetricData{ExpireBefore: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), ProjectScale: "1.0"},
+		},
+		{
+			options:  "hist,metric:" + url.QueryEscape("PRs: opened, merged"),
+			expected: lib.CalcMetricData{Hist: true, Metric: "PRs: opened, merged", ProjectScale: "1.0"},
+		},
+	}
+	// Execute test cases
+	for index, test := range testCases {
+		got := lib.ParseCalcMetricOptions(test.options)
+		if !reflect.DeepEqual(*got, test.expected) {
+			t.Errorf(
+				"test number %d, expected %+v, got %+v",
+				index+1, test.expected, *got,
+			)
+		}
+	}
+}
+
+func TestCalcMetricDryRunJSON(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	pts := lib.TSPoints{}
+	lib.AddTSPoint(
+		&ctx,
+		&pts,
+		lib.NewTSPoint(
+			&ctx,
+			"prs_opened",
+			"d",
+			map[string]string{"repo": "a"},
+			map[string]interface{}{"value": 2.0},
+			time.Date(2021, 3, 1, 10, 30, 0, 0, time.UTC),
+			false,
+		),
+	)
+	data, err := json.Marshal(
+		&lib.CalcMetricDryRun{
+			SeriesNameOrFunc: "prs_opened",
+			SQLFile:          "prs.sql",
+			Period:           "d",
+			From:             "2021-03-01 00:00:00",
+			To:               "2021-03-02 00:00:00",
+			SQL:              "select 2.0",
+			Points:           pts,
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{"series_name_or_func":"prs_opened","sql_file":"prs.sql","period":"d",` +
+		`"from":"2021-03-01 00:00:00","to":"2021-03-02 00:00:00","sql":"select 2.0",` +
+		`"points":[{"time":"2021-03-01T10:00:00Z","name":"prs_opened","period":"d","tags":{"repo":"a"},"fields":{"value":2}}]}`
+	if string(data) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, string(data))
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -36,6 +37,7 @@ var allAPIs = []string{
 	lib.DevActCntComp,
 	lib.ComStatsRepoGrp,
 	lib.SiteStats,
+	lib.SeriesCatalog,
 }
 
 var (
@@ -88,6 +90,28 @@ type siteStatsPayload struct {
 	BOC           int64  `json:"boc"`
 }
 
+type seriesCatalogColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type seriesCatalogItem struct {
+	Name         string                `json:"name"`
+	Kind         string                `json:"kind"`
+	Sources      []string              `json:"sources"`
+	Periods      []string              `json:"periods"`
+	Columns      []seriesCatalogColumn `json:"columns"`
+	FirstTime    *time.Time            `json:"first_time"`
+	LastTime     *time.Time            `json:"last_time"`
+	LastComputed time.Time             `json:"last_computed"`
+}
+
+type seriesCatalogPayload struct {
+	Project string              `json:"project"`
+	DB      string              `json:"db_name"`
+	Series  []seriesCatalogItem `json:"series"`
+}
+
 type companiesTablePayload struct {
 	Project string    `json:"project"`
 	DB      string    `json:"db_name"`
@@ -2206,6 +2230,65 @@ func requestInfo(r *http.Request) string {
 	return fmt.Sprintf("IP: %s, method: %s, path: %s", r.RemoteAddr, method, path)
 }
 
+func apiSeriesCatalog(info string, w http.ResponseWriter, payload map[string]interface{}) {
+	apiName := lib.SeriesCatalog
+	var err error
+	project, db, err := handleSharedPayload(w, payload)
+	defer func() {
+		lib.Printf("%s(exit): project:%s db:%s payload: %+v err:%v\n", apiName, project, db, payload, err)
+	}()
+	if err != nil {
+		returnError(apiName, w, err)
+		return
+	}
+	params := map[string]string{"kind": "", "series": ""}
+	for paramName := range params {
+		paramValue, err := getPayloadStringParam(paramName, w, payload, true)
+		if err != nil {
+			returnError(apiName, w, err)
+			return
+		}
+		params[paramName] = paramValue
+	}
+	ctx, c, err := getContextAndDB(w, db)
+	if err != nil {
+		returnError(apiName, w, err)
+		return
+	}
+	defer func() { _ = c.Close() }()
+	entries, err := lib.ReadSeriesCatalog(c, ctx, params["kind"], params["series"])
+	if err != nil {
+		returnError(apiName, w, err)
+		return
+	}
+	scpl := seriesCatalogPayload{Project: project, DB: db, Series: []seriesCatalogItem{}}
+	for _, entry := range entries {
+		item := seriesCatalogItem{
+			Name:         entry.Series,
+			Kind:         entry.Kind,
+			Sources:      entry.Sources,
+			Periods:      entry.Periods,
+			Columns:      []seriesCatalogColumn{},
+			LastComputed: entry.LastComputed,
+		}
+		if !entry.FirstTime.IsZero() {
+			firstTime, lastTime := entry.FirstTime, entry.LastTime
+			item.FirstTime, item.LastTime = &firstTime, &lastTime
+		}
+		names := []string{}
+		for name := range entry.Columns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			item.Columns = append(item.Columns, seriesCatalogColumn{Name: name, Type: entry.Columns[name]})
+		}
+		scpl.Series = append(scpl.Series, item)
+	}
+	w.WriteHeader(http.StatusOK)
+	jsoniter.NewEncoder(w).Encode(scpl)
+}
+
 func handleAPI(w http.ResponseWriter, req *http.Request) {
 	info := requestInfo(req)
 	gBgMtx.RLock()
@@ -2268,6 +2351,8 @@ func handleAPI(w http.ResponseWriter, req *http.Request) {
 		apiDevActCntComp(info, w, pl.Payload)
 	case lib.SiteStats:
 		apiSiteStats(info, w, pl.Payload)
+	case lib.SeriesCatalog:
+		apiSeriesCatalog(info, w, pl.Payload)
 	default:
 		err = fmt.Errorf("unknown API '%s'", pl.API)
 		returnError("unknown:"+pl.API, w, err)
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+// Recompute a single metric from metrics.yaml over a given date range
+// All its SQLs, periods, aggregates and series names are expanded the same way sync does,
+// with GHA2DB_BACKFILL_DROP affected series points in the range are removed first (merged series tables are refused)
+func backfillMetric(name, sFrom, sTo string) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	// Local or cron mode?
+	dataPrefix := ctx.DataDir
+	cmdPrefix := ""
+	if ctx.Local {
+		dataPrefix = "./"
+		cmdPrefix = "./"
+	}
+	metricsDir := dataPrefix + "metrics"
+	if ctx.Project != "" {
+		metricsDir += "/" + ctx.Project
+	}
+	from := lib.TimeParseAny(sFrom)
+	to := lib.TimeParseAny(sTo)
+	if to.Before(from) {
+		lib.Fatalf("date to %v is before date from %v", to, from)
+	}
+
+	// Read metrics configuration (with includes and overrides resolved)
+	allMetrics, err := lib.ReadMetrics(&ctx, dataPrefix+ctx.MetricsYaml)
+	lib.FatalOnError(err)
+
+	// Connect to Postgres DB
+	con := lib.PgConn(&ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.CheckSchema(con, &ctx)
+
+	// Annotations ranges metrics are calculated for all quick ranges
+	quickRanges := lib.GetTagValues(con, &ctx, "quick_ranges", "quick_ranges_suffix")
+	calcs, err := lib.BackfillCalcs(&ctx, allMetrics, name, quickRanges, time.Now())
+	lib.FatalOnError(err)
+	lib.Printf("Backfill metric %s: %d calculations, range %s - %s\n", name, len(calcs), lib.ToYMDHDate(from), lib.ToYMDHDate(to))
+
+	// Drop affected series range before any calculation starts
+	if ctx.BackfillDrop {
+		lib.FatalOnError(lib.BackfillCheckDrop(calcs))
+		var dropped int64
+		for i := range calcs {
+			if calcs[i].Table == "" {
+				lib.Printf("Cannot drop %s period %s: series tables are only known after calculation\n", calcs[i].SeriesNameOrFunc, calcs[i].Period)
+				continue
+			}
+			dropped += lib.BackfillDrop(con, &ctx, &calcs[i], from, to)
+		}
+		lib.Printf("Backfill metric %s: removed %d points\n", name, dropped)
+	}
+
+	// Recompute, histograms are always calculated for their current ranges
+	// Jobs use metric's weight and dependencies the same way sync does
+	budget := ctx.MetricsBudget
+	if budget <= 0 {
+		budget = lib.GetThreadsNum(&ctx)
+	}
+	mc := lib.NewMetricsCalculator(&ctx, con)
+	jobs := []*lib.MetricJob{}
+	for _, calc := range calcs {
+		calc := calc
+		sqlFile := fmt.Sprintf("%s/%s.sql", metricsDir, calc.SQLFile)
+		opts := strings.Join(calc.Options, ",")
+		job := lib.NewMetricJob(&ctx, calc.Def, strings.Join([]string{calc.SeriesNameOrFunc, sqlFile, calc.Period}, ","), budget)
+		// Calculations with own environment must run in a separate process
+		if ctx.CalcMetricExec || len(calc.Env) > 0 {
+			cmd := []string{cmdPrefix + "calc_metric", calc.SeriesNameOrFunc, sqlFile, lib.ToYMDHDate(from), lib.ToYMDHDate(to), calc.Period, opts}
+			job.Run = func() {
+				_, err := lib.ExecCommand(&ctx, cmd, calc.Env)
+				lib.FatalOnError(err)
+			}
+		} else {
+			job.Run = func() {
+				mc.CalcMetric(calc.SeriesNameOrFunc, sqlFile, lib.ToYMDHDate(from), lib.ToYMDHDate(to), calc.Period, lib.ParseCalcMetricOptions(opts))
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	_, err = lib.RunMetricJobs(&ctx, jobs, budget)
+	lib.FatalOnError(err)
+}
+
+func main() {
+	dtStart := time.Now()
+	if len(os.Args) < 4 {
+		lib.Printf("Required args: 'metric name' 'date from' 'date to'\n")
+		lib.Printf("Example: 'prs_merged' '2019-01-01' '2020-01-01'\n")
+		os.Exit(1)
+	}
+	backfillMetric(os.Args[1], os.Args[2], os.Args[3])
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+}
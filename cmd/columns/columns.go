@@ -8,18 +8,6 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-// columns contains list of columns that must be present on a certain series
-type columns struct {
-	Columns []column `yaml:"columns"`
-}
-
-// column contain configuration of columns needed on a specific series
-type column struct {
-	TableRegexp string `yaml:"table_regexp"`
-	Tag         string `yaml:"tag"`
-	Column      string `yaml:"column"`
-}
-
 // Ensure that specific TSDB series have all needed columns
 func ensureColumns() {
 	// Environment context parse
@@ -27,13 +15,14 @@ func ensureColumns() {
 	ctx.Init()
 
 	// If skip TSDB or only ES output - nothing to do
-	if ctx.SkipTSDB || ctx.UseESOnly {
+	if ctx.SkipTSDB || ctx.UseESOnly || ctx.UsePromOnly {
 		return
 	}
 
 	// Connect to Postgres DB
 	con := lib.PgConn(&ctx)
 	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.CheckSchema(con, &ctx)
 
 	// Local or cron mode?
 	dataPrefix := ctx.DataDir
@@ -47,7 +36,7 @@ func ensureColumns() {
 		lib.FatalOnError(err)
 		return
 	}
-	var allColumns columns
+	var allColumns lib.Columns
 	lib.FatalOnError(yaml.Unmarshal(data, &allColumns))
 
 	// Per project directory for SQL files
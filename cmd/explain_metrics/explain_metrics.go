@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+// Explain all project's metrics SQLs (or only metrics given as arguments) and store their plans and estimated costs
+// Returns number of metrics SQLs whose estimated cost grew sharply since the previous run
+func explainMetrics(names []string) int {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	// Local or cron mode?
+	dataPrefix := ctx.DataDir
+	if ctx.Local {
+		dataPrefix = "./"
+	}
+
+	// Read metrics configuration (with includes and overrides resolved)
+	allMetrics, err := lib.ReadMetrics(&ctx, dataPrefix+ctx.MetricsYaml)
+	lib.FatalOnError(err)
+
+	// Connect to Postgres DB
+	con := lib.PgConn(&ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+
+	plans := lib.ExplainMetrics(con, &ctx, dataPrefix, allMetrics, names, time.Now())
+	failed, regressions := 0, 0
+	for _, plan := range plans {
+		if plan.Err != nil {
+			failed++
+			continue
+		}
+		if plan.Regression(ctx.ExplainCostRatio, ctx.ExplainMinCost) {
+			regressions++
+			lib.Printf(
+				"Cost regression: metric %s SQL %s period %s: estimated cost %.2f, previous %.2f (%.1fx)\n",
+				plan.Metric, plan.SQL, plan.Period, plan.TotalCost, plan.PrevCost, plan.TotalCost/plan.PrevCost,
+			)
+		}
+	}
+	lib.Printf("Explained %d metrics SQLs, %d cannot be explained, %d cost regressions\n", len(plans), failed, regressions)
+	return regressions
+}
+
+func main() {
+	dtStart := time.Now()
+	regressions := explainMetrics(os.Args[1:])
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+	if regressions > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+// Export project's TSDB series tables to partitioned CSV or Parquet files with a manifest
+func exportTSDB() {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	// Connect to Postgres DB
+	con := lib.PgConn(&ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+
+	manifest := lib.ExportSeries(con, &ctx)
+	files, rows := 0, 0
+	for _, table := range manifest.Tables {
+		for _, part := range table.Partitions {
+			files++
+			rows += part.Rows
+		}
+	}
+	lib.Printf(
+		"Exported %d tables to %s: %d %s files, %d rows, manifest: %s\n",
+		len(manifest.Tables), ctx.ExportDir, files, manifest.Format, rows, ctx.ExportDir+lib.ExportManifestFile,
+	)
+}
+
+func main() {
+	dtStart := time.Now()
+	exportTSDB()
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+}
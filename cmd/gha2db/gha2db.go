@@ -1686,6 +1686,12 @@ func gha2db(args []string) {
 		skipDates[lib.ToYMDHDate(date)] = struct{}{}
 	}
 
+	// Refuse to run on outdated schema, partitioned tables need partitions for the processed range before any event is written
+	con := lib.PgConn(&ctx)
+	lib.CheckSchema(con, &ctx)
+	lib.EnsurePartitions(con, &ctx, dFrom, dTo)
+	lib.FatalOnError(con.Close())
+
 	igc := 0
 	maybeGC := func() {
 		igc++
@@ -12,41 +12,8 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-// metrics contain list of metrics to evaluate
-type metrics struct {
-	Metrics []metric `yaml:"metrics"`
-}
-
-// metric contain each metric data
-// some metrics can be allowed to fail
-type metric struct {
-	Name              string            `yaml:"name"`
-	Periods           string            `yaml:"periods"`
-	SeriesNameOrFunc  string            `yaml:"series_name_or_func"`
-	MetricSQL         string            `yaml:"sql"`
-	MetricSQLs        *[]string         `yaml:"sqls"`
-	AddPeriodToName   bool              `yaml:"add_period_to_name"`
-	Histogram         bool              `yaml:"histogram"`
-	Aggregate         string            `yaml:"aggregate"`
-	Skip              string            `yaml:"skip"`
-	Desc              string            `yaml:"desc"`
-	MultiValue        bool              `yaml:"multi_value"`
-	EscapeValueName   bool              `yaml:"escape_value_name"`
-	AnnotationsRanges bool              `yaml:"annotations_ranges"`
-	MergeSeries       string            `yaml:"merge_series"`
-	CustomData        bool              `yaml:"custom_data"`
-	StartFrom         *time.Time        `yaml:"start_from"`
-	LastHours         int               `yaml:"last_hours"`
-	SeriesNameMap     map[string]string `yaml:"series_name_map"`
-	EnvMap            map[string]string `yaml:"env"`
-	Disabled          bool              `yaml:"disabled"`
-	Drop              string            `yaml:"drop"`
-	Project           string            `yaml:"project"`
-	AllowFail         bool              `yaml:"allow_fail"`
-}
-
-// randomize - shufflues array of metrics to calculate, making sure that ctx.LastSeries is still last
-func (m *metrics) randomize(ctx *lib.Ctx) {
+// randomizeMetrics - shufflues array of metrics to calculate, making sure that ctx.LastSeries is still last
+func randomizeMetrics(ctx *lib.Ctx, m *lib.AllMetrics) {
 	lib.Printf("Randomizing metrics calculation order\n")
 	rand.Seed(time.Now().UnixNano())
 	rand.Shuffle(len(m.Metrics), func(i, j int) { m.Metrics[i], m.Metrics[j] = m.Metrics[j], m.Metrics[i] })
@@ -160,67 +127,6 @@ func createSeriesFromFormula(def string) (result []string) {
 	return
 }
 
-// If env variable ends with ? then only set this value when it's not yet set or is empty
-// If env variable ends with ?? then only set this value when not already defined
-//   so if env variable is defined but empty, it will not set its value
-//   while version with a single ? will
-// If key contains @ (for example key-name@period-name) that means a key key-name should only be set for period=period-name
-// If key contains ! (for example key-name!period-name) that means a key key-name should only be set for period!=period-name
-// Most complex example GHA2DB_NCPUS?@d7=4 - set GHA2DB_NCPUS=4 only when calculating period d7
-//   and that variable was not already set to non-empty value
-func processEnvMap(in map[string]string, period string) (outMap map[string]string) {
-	inMap := make(map[string]string)
-	for k, v := range in {
-		if strings.Contains(k, "@") {
-			ary := strings.Split(k, "@")
-			if ary[1] == period && ary[0] != "" {
-				inMap[ary[0]] = v
-			}
-			continue
-		}
-		if strings.Contains(k, "!") {
-			ary := strings.Split(k, "!")
-			if ary[1] != period && ary[0] != "" {
-				inMap[ary[0]] = v
-			}
-			continue
-		}
-		inMap[k] = v
-	}
-	conditional := false
-	for k := range inMap {
-		if strings.HasSuffix(k, "?") {
-			conditional = true
-			break
-		}
-	}
-	if !conditional {
-		outMap = inMap
-		return
-	}
-	outMap = make(map[string]string)
-	for k, v := range inMap {
-		if strings.HasSuffix(k, "??") {
-			k2 := k[0 : len(k)-2]
-			_, ok := os.LookupEnv(k2)
-			if !ok {
-				outMap[k2] = v
-			}
-			continue
-		}
-		if strings.HasSuffix(k, "?") {
-			k2 := k[0 : len(k)-1]
-			val := os.Getenv(k2)
-			if val == "" {
-				outMap[k2] = v
-			}
-			continue
-		}
-		outMap[k] = v
-	}
-	return
-}
-
 func sync(ctx *lib.Ctx, args []string) {
 	// Strip function to be used by MapString
 	stripFunc := func(x string) string { return strings.TrimSpace(x) }
@@ -251,6 +157,7 @@ func sync(ctx *lib.Ctx, args []string) {
 	// Connect to Postgres DB
 	con := lib.PgConn(ctx)
 	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.CheckSchema(con, ctx)
 
 	// Get max event date from Postgres database
 	var maxDtPtr *time.Time
@@ -389,7 +296,7 @@ func sync(ctx *lib.Ctx, args []string) {
 	}
 
 	// Calc metric
-	if !ctx.SkipTSDB || ctx.UseESOnly {
+	if !ctx.SkipTSDB || ctx.UseESOnly || ctx.UsePromOnly {
 		metricsDir := dataPrefix + "metrics"
 		if ctx.Project != "" {
 			metricsDir += "/" + ctx.Project
@@ -439,24 +346,24 @@ func sync(ctx *lib.Ctx, args []string) {
 		quickRanges := lib.GetTagValues(con, ctx, "quick_ranges", "quick_ranges_suffix")
 		lib.Printf("Quick ranges: %+v\n", quickRanges)
 
-		// Read metrics configuration
-		data, err := lib.ReadFile(ctx, dataPrefix+ctx.MetricsYaml)
+		// Read metrics configuration (with includes and overrides resolved)
+		allMetrics, err := lib.ReadMetrics(ctx, dataPrefix+ctx.MetricsYaml)
 		if err != nil {
 			lib.FatalOnError(err)
 			return
 		}
-		var allMetrics metrics
-		lib.FatalOnError(yaml.Unmarshal(data, &allMetrics))
+		// Fail before any metric is calculated when some metric uses unknown value description function
+		// or when some retention rule could only match series generated by functions
+		lib.FatalOnError(lib.CheckMetricsValueDescriptions(ctx, allMetrics))
+		lib.FatalOnError(lib.CheckRetentionSeries(ctx, allMetrics))
 
 		// randomize metrics order
 		if !ctx.SkipRand {
-			allMetrics.randomize(ctx)
+			randomizeMetrics(ctx, allMetrics)
 		}
 
-		// Keep all histograms here
-		var hists [][]string
-		var envMaps []map[string]string
-		var allowFails []bool
+		// Keep all metric calculations here, they're executed by the dependency-aware scheduler
+		var jobs []*lib.MetricJob
 		onlyMetrics := false
 		if len(ctx.OnlyMetrics) > 0 {
 			onlyMetrics = true
@@ -466,7 +373,17 @@ func sync(ctx *lib.Ctx, args []string) {
 			skipMetrics = true
 		}
 
-		metricsList := []metric{}
+		// Concurrency budget, non-histogram metrics use all CPUs by default
+		budget := ctx.MetricsBudget
+		if budget <= 0 {
+			budget = lib.GetThreadsNum(ctx)
+		}
+		lib.Printf("Metrics concurrency budget: %d\n", budget)
+
+		// In-process metrics calculation shares sync's Postgres connection pool
+		mc := lib.NewMetricsCalculator(ctx, con)
+
+		metricsList := []lib.Metric{}
 		// Iterate all metrics
 		for _, metric := range allMetrics.Metrics {
 			if lib.ExcludedForProject(ctx.Project, metric.Project) {
@@ -476,28 +393,23 @@ func sync(ctx *lib.Ctx, args []string) {
 			if metric.Histogram && metric.Drop != "" {
 				lib.Fatalf("you cannot use drop series property on histogram metrics: %+v", metric)
 			}
-			if metric.MetricSQLs != nil {
-				if metric.MetricSQL != "" {
-					lib.Fatalf("you cannot use both 'sql' and 'sqls' fields'")
-				}
-				dropAdded := false
-				for _, sql := range *metric.MetricSQLs {
-					newMetric := metric
-					newMetric.MetricSQLs = nil
-					newMetric.MetricSQL = sql
-					if !dropAdded {
-						dropAdded = true
-					} else {
-						newMetric.Drop = ""
-					}
-					metricsList = append(metricsList, newMetric)
-				}
-				continue
+			variants, err := metric.SQLVariants()
+			lib.FatalOnError(err)
+			metricsList = append(metricsList, variants...)
+		}
+		if ctx.Debug > 0 {
+			lib.Printf("Effective metrics list (%d):\n", len(metricsList))
+			for _, metric := range metricsList {
+				lib.Printf(
+					"  %s: sql: %s, periods: %s, aggregate: %s, skip: %s, disabled: %v, env: %v\n",
+					metric.Name, metric.MetricSQL, metric.Periods, metric.Aggregate, metric.Skip, metric.Disabled, metric.EnvMap,
+				)
 			}
-			metricsList = append(metricsList, metric)
 		}
 
 		// Iterate all metrics
+		// Jobs that drop series must finish before other jobs of the same metric start
+		dropJobs := make(map[string]*lib.MetricJob)
 		for _, metric := range metricsList {
 			if metric.Disabled {
 				continue
@@ -538,187 +450,113 @@ func sync(ctx *lib.Ctx, args []string) {
 				}
 				continue
 			}
-			extraParams := []string{}
-			if ctx.ProjectScale != 1.0 {
-				extraParams = append(extraParams, fmt.Sprintf("project_scale:%f", ctx.ProjectScale))
-			}
-			if metric.Histogram {
-				extraParams = append(extraParams, "hist")
-			}
-			if metric.MultiValue {
-				extraParams = append(extraParams, "multivalue")
-			}
-			if metric.EscapeValueName {
-				extraParams = append(extraParams, "escape_value_name")
-			}
-			if metric.Desc != "" {
-				extraParams = append(extraParams, "desc:"+metric.Desc)
-			}
-			if metric.MergeSeries != "" {
-				extraParams = append(extraParams, "merge_series:"+metric.MergeSeries)
-			}
-			if metric.CustomData {
-				extraParams = append(extraParams, "custom_data")
-			}
-			if metric.SeriesNameMap != nil {
-				extraParams = append(extraParams, "series_name_map:"+fmt.Sprintf("%v", metric.SeriesNameMap))
-			}
-			periods := strings.Split(metric.Periods, ",")
-			aggregate := metric.Aggregate
-			if aggregate == "" {
-				aggregate = "1"
-			}
-			if metric.AnnotationsRanges {
-				extraParams = append(extraParams, "annotations_ranges")
-				periods = quickRanges
-				aggregate = "1"
-			}
-			aggregateArr := strings.Split(aggregate, ",")
-			skips := strings.Split(metric.Skip, ",")
-			skipMap := make(map[string]struct{})
-			for _, skip := range skips {
-				skipMap[skip] = struct{}{}
-			}
+			extraParams := metric.CalcMetricOptions(ctx)
+			periods, err := metric.PeriodVariants(quickRanges)
+			lib.FatalOnError(err)
 			if !ctx.ResetTSDB && !ctx.ResetRanges {
 				extraParams = append(extraParams, "skip_past")
 			}
-			for _, aggrStr := range aggregateArr {
-				_, err := strconv.Atoi(aggrStr)
-				lib.FatalOnError(err)
-				aggrSuffix := aggrStr
-				if aggrSuffix == "1" {
-					aggrSuffix = ""
+			for _, mp := range periods {
+				period, periodAggr := mp.Period, mp.Name
+				recalc := lib.ComputePeriodAtThisDate(ctx, period, to, metric.Histogram)
+				// Mergeable histograms only scan the newest data, so they can be refreshed on every sync
+				if !recalc && metric.Mergeable != "" && ctx.ComputePeriods == nil {
+					recalc = true
 				}
-				for _, period := range periods {
-					periodAggr := period + aggrSuffix
-					_, found := skipMap[periodAggr]
-					if found {
-						if ctx.Debug > 0 {
-							lib.Printf("Skipped period %s\n", periodAggr)
-						}
-						continue
-					}
-					recalc := lib.ComputePeriodAtThisDate(ctx, period, to, metric.Histogram)
-					// Because sync probab can be less than 100% and that may cause gaps, we should eventually let do recalculation even if that period doesn't need it
-					if !recalc {
-						rand.Seed(time.Now().UnixNano())
-						val := rand.Intn(ctx.RecalcReciprocal)
-						if val == 0 {
-							recalc = true
-						}
-					}
-					if ctx.Debug > 0 {
-						lib.Printf("Recalculate period \"%s%s\", hist %v for date to %v: %v\n", period, aggrSuffix, metric.Histogram, to, recalc)
-					}
-					if (!ctx.ResetTSDB || ctx.ComputePeriods != nil) && !recalc {
-						lib.Printf("Skipping recalculating period \"%s%s\", hist %v for date to %v\n", period, aggrSuffix, metric.Histogram, to)
-						continue
+				// Because sync probab can be less than 100% and that may cause gaps, we should eventually let do recalculation even if that period doesn't need it
+				if !recalc {
+					rand.Seed(time.Now().UnixNano())
+					val := rand.Intn(ctx.RecalcReciprocal)
+					if val == 0 {
+						recalc = true
 					}
-					seriesNameOrFunc := metric.SeriesNameOrFunc
-					if metric.AddPeriodToName {
-						seriesNameOrFunc += "_" + periodAggr
+				}
+				if ctx.Debug > 0 {
+					lib.Printf("Recalculate period \"%s\", hist %v for date to %v: %v\n", periodAggr, metric.Histogram, to, recalc)
+				}
+				if (!ctx.ResetTSDB || ctx.ComputePeriods != nil) && !recalc {
+					lib.Printf("Skipping recalculating period \"%s\", hist %v for date to %v\n", periodAggr, metric.Histogram, to)
+					continue
+				}
+				seriesNameOrFunc := metric.SeriesName(periodAggr)
+				eParams := append([]string{}, extraParams...)
+				// Periods expired by retention rules are not recalculated, series functions are only matched by rules without series regexp
+				if len(allMetrics.Retention) > 0 && !metric.Histogram {
+					series := seriesNameOrFunc
+					if metric.MergeSeries != "" {
+						series = metric.MergeSeries
 					}
-					// Histogram metrics usualy take long time, but executes single query, so there is no way to
-					// Implement multi threading inside "calc_metric" call for them
-					// So we're creating array of such metrics to be executed at the end - each in a separate go routine
-					eParams := extraParams
-					if ctx.EnableMetricsDrop && !dropProcessed {
-						if metric.Drop != "" {
-							eParams = append(eParams, "drop:"+metric.Drop)
-						}
-						dropProcessed = true
+					expire := lib.RetentionExpireBefore(allMetrics.Retention, series, periodAggr, time.Now())
+					if expire != "" {
+						eParams = append(eParams, expire)
 					}
-					envMap := processEnvMap(metric.EnvMap, periodAggr)
-					if metric.Histogram {
-						lib.Printf("Scheduled histogram metric %v, period %v, desc: '%v', aggregate: '%v' ...\n", metric.Name, period, metric.Desc, aggrSuffix)
-						hists = append(
-							hists,
-							[]string{
-								cmdPrefix + "calc_metric",
-								seriesNameOrFunc,
-								fmt.Sprintf("%s/%s.sql", metricsDir, metric.MetricSQL),
-								lib.ToYMDHDate(fromDate),
-								lib.ToYMDHDate(to),
-								periodAggr,
-								strings.Join(extraParams, ","),
-							},
-						)
-						envMaps = append(envMaps, envMap)
-						allowFails = append(allowFails, metric.AllowFail)
-					} else {
-						lib.Printf("Calculate metric %v, period %v, desc: '%v', aggregate: '%v' ...\n", metric.Name, period, metric.Desc, aggrSuffix)
-						execFatal := ctx.ExecFatal
-						if metric.AllowFail {
-							ctx.ExecFatal = false
-						}
-						_, err = lib.ExecCommand(
-							ctx,
-							[]string{
-								cmdPrefix + "calc_metric",
-								seriesNameOrFunc,
-								fmt.Sprintf("%s/%s.sql", metricsDir, metric.MetricSQL),
-								lib.ToYMDHDate(fromDate),
-								lib.ToYMDHDate(to),
-								periodAggr,
-								strings.Join(eParams, ","),
-							},
-							envMap,
-						)
-						if metric.AllowFail {
-							ctx.ExecFatal = execFatal
-						}
-						if !metric.AllowFail {
-							lib.FatalOnError(err)
-						} else if err != nil {
-							lib.Printf("WARNING: %+v failed: %+v\n", metric, err)
-							err = nil
-						}
+				}
+				dropJob := false
+				if ctx.EnableMetricsDrop && !dropProcessed {
+					if metric.Drop != "" {
+						eParams = append(eParams, "drop:"+metric.Drop)
+						dropJob = true
 					}
+					dropProcessed = true
 				}
+				envMap := lib.MetricEnvMap(metric.EnvMap, periodAggr)
+				calcMetricCmd := []string{
+					cmdPrefix + "calc_metric",
+					seriesNameOrFunc,
+					fmt.Sprintf("%s/%s.sql", metricsDir, metric.MetricSQL),
+					lib.ToYMDHDate(fromDate),
+					lib.ToYMDHDate(to),
+					periodAggr,
+					strings.Join(eParams, ","),
+				}
+				job := lib.NewMetricJob(ctx, &metric, strings.Join(calcMetricCmd[1:6], ","), budget)
+				lib.Printf("Scheduled metric %v, period %v, hist: %v, desc: '%v', aggregate: '%v', weight: %d, depends on: %v ...\n", metric.Name, period, metric.Histogram, metric.Desc, strings.TrimPrefix(periodAggr, period), job.Weight, metric.DependsOn)
+				if dropJob {
+					dropJobs[metric.Name] = job
+				} else if dj, ok := dropJobs[metric.Name]; ok {
+					job.After = []*lib.MetricJob{dj}
+				}
+				allowFail := metric.AllowFail
+				// Metrics with own environment or allowed to fail must run in a separate process
+				if ctx.CalcMetricExec || len(envMap) > 0 || allowFail {
+					job.Run = func() { calcMetric(ctx, calcMetricCmd, envMap, allowFail) }
+				} else {
+					job.Run = func() { calcMetricInProcess(mc, calcMetricCmd) }
+				}
+				jobs = append(jobs, job)
+			}
+		}
+		// Histograms are scheduled after all other metrics (metrics order was already randomized)
+		nonHists := []*lib.MetricJob{}
+		hists := []*lib.MetricJob{}
+		for _, job := range jobs {
+			if job.Histogram {
+				hists = append(hists, job)
+			} else {
+				nonHists = append(nonHists, job)
 			}
 		}
 		// randomize histograms
 		if !ctx.SkipRand {
 			lib.Printf("Randomizing histogram metrics calculation order\n")
 			rand.Seed(time.Now().UnixNano())
-			rand.Shuffle(
-				len(hists),
-				func(i, j int) {
-					hists[i], hists[j] = hists[j], hists[i]
-					envMaps[i], envMaps[j] = envMaps[j], envMaps[i]
-					allowFails[i], allowFails[j] = allowFails[j], allowFails[i]
-				},
-			)
+			rand.Shuffle(len(hists), func(i, j int) { hists[i], hists[j] = hists[j], hists[i] })
 		}
-		// Process histograms (possibly MT)
-		// Get number of CPUs available
-		thrN := lib.GetThreadsNum(ctx)
-		if ctx.MaxHistograms > 0 && thrN > ctx.MaxHistograms {
-			thrN = ctx.MaxHistograms
-			lib.Printf("Number of parallel histograms limited to %d\n", thrN)
-		}
-		if thrN > 1 {
-			lib.Printf("Now processing %d histograms using MT%d version\n", len(hists), thrN)
-			ch := make(chan bool)
-			nThreads := 0
-			for idx, hist := range hists {
-				go calcHistogram(ch, ctx, hist, envMaps[idx], allowFails[idx])
-				nThreads++
-				if nThreads == thrN {
-					<-ch
-					nThreads--
-				}
-			}
-			lib.Printf("Final threads join\n")
-			for nThreads > 0 {
-				<-ch
-				nThreads--
-			}
-		} else {
-			lib.Printf("Now processing %d histograms using ST version\n", len(hists))
-			for idx, hist := range hists {
-				calcHistogram(nil, ctx, hist, envMaps[idx], allowFails[idx])
-			}
+		jobs = append(nonHists, hists...)
+
+		// Process metrics (possibly MT), respecting dependencies and concurrency budget
+		lib.Printf("Now processing %d metrics calculations using budget %d, max histograms: %d\n", len(jobs), budget, ctx.MaxHistograms)
+		dtMetrics := time.Now()
+		critical, err := lib.RunMetricJobs(ctx, jobs, budget)
+		lib.FatalOnError(err)
+		wall := time.Now().Sub(dtMetrics)
+		var criticalTime time.Duration
+		for _, job := range critical {
+			criticalTime += job.Duration()
+		}
+		lib.Printf("Metrics calculated in %v, critical path (%d metrics) takes %v:\n", wall, len(critical), criticalTime)
+		for _, job := range critical {
+			lib.Printf("  %s (%s): %v\n", job.Name, job.Info, job.Duration())
 		}
 
 		// TSDB ensure that calculated metric have all columns from tags
@@ -730,6 +568,16 @@ func sync(ctx *lib.Ctx, args []string) {
 				lib.Printf("Skipping `columns` recalculation, it is only computed once per day\n")
 			}
 		}
+
+		// Optional anomaly detection on latest points of selected series
+		if ctx.AnomalySeries != nil {
+			// Detection is advisory, its errors must not fail the sync
+			anomalies, err := lib.DetectAnomalies(con, ctx, time.Now())
+			if err != nil {
+				lib.Printf("Anomaly detection error (ignored): %v\n", err)
+			}
+			lib.Printf("Anomaly detection: %d anomalies found\n", len(anomalies))
+		}
 	}
 
 	// Vars (some tables/dashboards require vars calculation)
@@ -750,51 +598,60 @@ func sync(ctx *lib.Ctx, args []string) {
 	lib.Printf("Sync success\n")
 }
 
-// calcHistogram - calculate single histogram by calling "calc_metric" program with parameters from "hist"
-func calcHistogram(ch chan bool, ctx *lib.Ctx, hist []string, envMap map[string]string, allowFail bool) {
-	if len(hist) != 7 {
-		lib.Fatalf("calcHistogram, expected 7 strings, got: %d: %v", len(hist), hist)
+// calcMetric - calculate single metric or histogram by calling "calc_metric" program with parameters from "calcMetricCmd"
+func calcMetric(ctx *lib.Ctx, calcMetricCmd []string, envMap map[string]string, allowFail bool) {
+	if len(calcMetricCmd) != 7 {
+		lib.Fatalf("calcMetric, expected 7 strings, got: %d: %v", len(calcMetricCmd), calcMetricCmd)
 	}
 	lib.Printf(
-		"Calculate histogram %s,%s,%s,%s,%s,%s ...\n",
-		hist[1],
-		hist[2],
-		hist[3],
-		hist[4],
-		hist[5],
-		hist[6],
+		"Calculate metric %s,%s,%s,%s,%s,%s ...\n",
+		calcMetricCmd[1],
+		calcMetricCmd[2],
+		calcMetricCmd[3],
+		calcMetricCmd[4],
+		calcMetricCmd[5],
+		calcMetricCmd[6],
 	)
-	execFatal := ctx.ExecFatal
+	// Metrics are calculated concurrently, so use a local context copy when allowing failures
 	if allowFail {
-		ctx.ExecFatal = false
+		actx := *ctx
+		actx.ExecFatal = false
+		ctx = &actx
 	}
 	// Execute "calc_metric"
-	_, err := lib.ExecCommand(
-		ctx,
-		[]string{
-			hist[0],
-			hist[1],
-			hist[2],
-			hist[3],
-			hist[4],
-			hist[5],
-			hist[6],
-		},
-		envMap,
-	)
-	if allowFail {
-		ctx.ExecFatal = execFatal
-	}
+	_, err := lib.ExecCommand(ctx, calcMetricCmd, envMap)
 	if !allowFail {
 		lib.FatalOnError(err)
 	} else if err != nil {
-		lib.Printf("WARNING: histogram %+v %+v failed: %+v\n", envMap, hist, err)
-		err = nil
+		lib.Printf("WARNING: metric %+v %+v failed: %+v\n", envMap, calcMetricCmd, err)
 	}
-	// Synchronize go routine
-	if ch != nil {
-		ch <- true
+}
+
+// calcMetricInProcess - calculate single metric or histogram using shared metrics calculator, "calcMetricCmd" is the same as for "calc_metric" program
+func calcMetricInProcess(mc *lib.MetricsCalculator, calcMetricCmd []string) {
+	if len(calcMetricCmd) != 7 {
+		lib.Fatalf("calcMetricInProcess, expected 7 strings, got: %d: %v", len(calcMetricCmd), calcMetricCmd)
 	}
+	lib.Printf(
+		"Calculate metric in-process %s,%s,%s,%s,%s,%s ...\n",
+		calcMetricCmd[1],
+		calcMetricCmd[2],
+		calcMetricCmd[3],
+		calcMetricCmd[4],
+		calcMetricCmd[5],
+		calcMetricCmd[6],
+	)
+	dtStart := time.Now()
+	mc.CalcMetric(
+		calcMetricCmd[1],
+		calcMetricCmd[2],
+		calcMetricCmd[3],
+		calcMetricCmd[4],
+		calcMetricCmd[5],
+		lib.ParseCalcMetricOptions(calcMetricCmd[6]),
+	)
+	dtEnd := time.Now()
+	lib.Printf("Time(%s): %v\n", calcMetricCmd[2], dtEnd.Sub(dtStart))
 }
 
 // Return per project args (if no args given) or get args from command line (if given)
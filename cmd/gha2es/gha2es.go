@@ -202,7 +202,18 @@ type esRawText struct {
 	FullBody         string   `json:"full_body"`
 }
 
-func generateRawES(ch chan struct{}, ctx *lib.Ctx, con *sql.DB, es *lib.ES, dtf, dtt time.Time, sqls map[string]string) {
+// esRawDoc - document waiting for its day's fingerprint to be checked
+type esRawDoc struct {
+	doc       interface{}
+	id        string
+	createdAt time.Time
+}
+
+// generateRawES - generates raw ES documents for dtf - dtt range
+// In incremental mode range is a single day, document types are only indexed from their start day and
+// when their day's fingerprint changed
+// Returns max indexed row timestamp per document type, it is only returned when all bulks completed
+func generateRawES(ch chan map[string]time.Time, ctx *lib.Ctx, con *sql.DB, es *lib.ES, dtf, dtt time.Time, sqls map[string]string, starts map[string]time.Time) map[string]time.Time {
 	if ctx.Debug > 0 {
 		lib.Printf("Working on %v - %v\n", dtf, dtt)
 	}
@@ -215,6 +226,40 @@ func generateRawES(ch chan struct{}, ctx *lib.Ctx, con *sql.DB, es *lib.ES, dtf,
 	var b lib.ESBulks
 	b.Init(es.GetElasticClient(), ctx)
 
+	// Documents are either added to bulks directly or kept until fingerprints are compared
+	var (
+		stored  map[string]lib.ESRawDay
+		pending map[string][]esRawDoc
+		fps     map[string]*lib.ESRawFingerprint
+	)
+	incremental := starts != nil
+	maxes := make(map[string]time.Time)
+	indexed := func(docType string, createdAt time.Time) {
+		if prev, ok := maxes[docType]; !ok || createdAt.After(prev) {
+			maxes[docType] = createdAt
+		}
+	}
+	if incremental {
+		stored = lib.ReadESRawDays(con, ctx, dtf)
+		pending = make(map[string][]esRawDoc)
+		fps = make(map[string]*lib.ESRawFingerprint)
+		for _, docType := range lib.ESRawDocTypes() {
+			fps[docType] = &lib.ESRawFingerprint{}
+		}
+	}
+	add := func(docType string, doc interface{}, id string, createdAt time.Time) {
+		if !incremental {
+			es.AddBulksItemsI(ctx, &b, doc, id)
+			indexed(docType, createdAt)
+			return
+		}
+		if !lib.ESRawIndexed(starts, docType, dtf) {
+			return
+		}
+		fps[docType].Add(doc)
+		pending[docType] = append(pending[docType], esRawDoc{doc: doc, id: id, createdAt: createdAt})
+	}
+
 	// Commits
 	sql := strings.Replace(sqls["commits"], "{{from}}", sFrom, -1)
 	sql = strings.Replace(sql, "{{to}}", sTo, -1)
@@ -278,7 +323,7 @@ func generateRawES(ch chan struct{}, ctx *lib.Ctx, con *sql.DB, es *lib.ES, dtf,
 		commit.CreatedAt = lib.ToYMDHMSDate(createdAt)
 		commit.Message = lib.TruncToBytes(commit.Message, 0x400)
 		shas[commit.SHA] = struct{}{}
-		es.AddBulksItemsI(ctx, &b, commit, lib.HashArray([]interface{}{commit.Type, commit.SHA, commit.EventID}))
+		add("commits", commit, lib.HashArray([]interface{}{commit.Type, commit.SHA, commit.EventID}), createdAt)
 	}
 	lib.FatalOnError(rows.Err())
 
@@ -364,7 +409,7 @@ func generateRawES(ch chan struct{}, ctx *lib.Ctx, con *sql.DB, es *lib.ES, dtf,
 			issue.ClosedAt = nil
 		}
 		iids[issue.ID] = struct{}{}
-		es.AddBulksItemsI(ctx, &b, issue, lib.HashArray([]interface{}{issue.Type, issue.ID, issue.EventID}))
+		add("issues", issue, lib.HashArray([]interface{}{issue.Type, issue.ID, issue.EventID}), createdAt)
 	}
 	lib.FatalOnError(rows.Err())
 
@@ -476,7 +521,7 @@ func generateRawES(ch chan struct{}, ctx *lib.Ctx, con *sql.DB, es *lib.ES, dtf,
 			pr.MergedAt = nil
 		}
 		prids[pr.ID] = struct{}{}
-		es.AddBulksItemsI(ctx, &b, pr, lib.HashArray([]interface{}{pr.Type, pr.ID, pr.EventID}))
+		add("prs", pr, lib.HashArray([]interface{}{pr.Type, pr.ID, pr.EventID}), createdAt)
 	}
 	lib.FatalOnError(rows.Err())
 
@@ -521,26 +566,54 @@ func generateRawES(ch chan struct{}, ctx *lib.Ctx, con *sql.DB, es *lib.ES, dtf,
 		text.FullBody = text.Body
 		text.Body = lib.TruncToBytes(text.Body, 0x1000)
 		textids[text.EventID] = struct{}{}
-		es.AddBulksItemsI(ctx, &b, text, lib.HashArray([]interface{}{text.Type, text.EventID, text.Body}))
+		add("texts", text, lib.HashArray([]interface{}{text.Type, text.EventID, text.Body}), createdAt)
 	}
 	lib.FatalOnError(rows.Err())
 
-	// Bulk insert to ES
+	// Only index document types with new, late arriving or updated rows
+	changed := []lib.ESRawDay{}
+	if incremental {
+		for _, docType := range lib.ESRawDocTypes() {
+			if !lib.ESRawIndexed(starts, docType, dtf) {
+				continue
+			}
+			// Rows of unchanged days are already indexed, they still count for the checkpoint
+			for _, doc := range pending[docType] {
+				indexed(docType, doc.createdAt)
+			}
+			fp := fps[docType]
+			if prev, ok := stored[docType]; ok && fp.Same(prev) {
+				continue
+			}
+			for _, doc := range pending[docType] {
+				es.AddBulksItemsI(ctx, &b, doc.doc, doc.id)
+			}
+			changed = append(changed, lib.ESRawDay{DocType: docType, Day: dtf, Rows: fp.Rows, Digest: fp.Digest()})
+		}
+	}
+
+	// Bulk insert to ES, it fails when any bulk didn't complete, so no checkpoint is advanced then
 	es.ExecuteBulks(ctx, &b)
 
+	// Fingerprints are saved after documents are indexed, so failed runs will reindex them
+	for _, day := range changed {
+		lib.SaveESRawDay(con, ctx, day)
+	}
+
 	if ctx.Debug > 0 {
 		lib.Printf(
 			"%v - %v: %d commits (%d unique SHAs), %d issue events (%d unique issues), "+
-				"%d PR events (%d unique PRs), %d texts (%d unique)\n",
+				"%d PR events (%d unique PRs), %d texts (%d unique), %d changed document types, bulk stats: %+v\n",
 			sFrom, sTo, nCommits, len(shas), nIssues, len(iids),
-			nPRs, len(prids), nTexts, len(textids),
+			nPRs, len(prids), nTexts, len(textids), len(changed), b.Stats(),
 		)
 	}
 
 	// Synchronize go routine
 	if ch != nil {
-		ch <- struct{}{}
+		ch <- maxes
 	}
+	return maxes
 }
 
 // gha2es - main working function
@@ -561,15 +634,20 @@ func gha2es(args []string) {
 	}
 	// Connect to ElasticSearch
 	es := lib.ESConn(&ctx, "d_raw_")
-	// Create index
-	exists := es.IndexExists(&ctx)
-	if !exists {
-		es.CreateIndex(&ctx, true)
+	// Full reindex builds a new index and swaps alias to it when done, otherwise create index if needed
+	if ctx.ResetESRaw {
+		es.StartReindex(&ctx, true)
+	} else {
+		exists := es.IndexExists(&ctx)
+		if !exists {
+			es.CreateIndex(&ctx, true)
+		}
 	}
 
 	// Connect to Postgres DB
 	con := lib.PgConn(&ctx)
 	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.CheckSchema(con, &ctx)
 
 	// Get raw commits to ES SQL
 	sqls := make(map[string]string)
@@ -641,36 +719,51 @@ func gha2es(args []string) {
 	if hours > 480 {
 		hours = 480
 	}
+	// Incremental mode resumes each document type from its checkpoint (or from the requested earlier date) and processes whole days,
+	// so their fingerprints can be compared
+	var starts map[string]time.Time
+	if !ctx.ResetESRaw {
+		starts = lib.ESRawStarts(dFrom, lib.ReadESRawCheckpoints(con, &ctx), ctx.ESRawLookback)
+		dFrom = lib.ESRawStart(starts)
+		hours = 24
+	}
 	lib.Printf("gha2es.go: Running (%v CPUs): %v - %v, interval %dh\n", thrN, dFrom, dTo, hours)
 
 	dt := dFrom
 	dtN := dt
+	maxes := make(map[string]time.Time)
 	if thrN > 1 {
-		ch := make(chan struct{})
+		ch := make(chan map[string]time.Time)
 		nThreads := 0
 		for dt.Before(dTo) || dt.Equal(dTo) {
 			dtN = dt.Add(time.Hour * time.Duration(hours))
-			go generateRawES(ch, &ctx, con, es, dt, dtN, sqls)
+			go generateRawES(ch, &ctx, con, es, dt, dtN, sqls, starts)
 			dt = dtN
 			nThreads++
 			if nThreads == thrN {
-				<-ch
+				lib.ESRawMaxes(maxes, <-ch)
 				nThreads--
 			}
 		}
 		lib.Printf("Final threads join\n")
 		for nThreads > 0 {
-			<-ch
+			lib.ESRawMaxes(maxes, <-ch)
 			nThreads--
 		}
 	} else {
 		lib.Printf("Using single threaded version\n")
 		for dt.Before(dTo) || dt.Equal(dTo) {
 			dtN = dt.Add(time.Hour * time.Duration(hours))
-			generateRawES(nil, &ctx, con, es, dt, dtN, sqls)
+			lib.ESRawMaxes(maxes, generateRawES(nil, &ctx, con, es, dt, dtN, sqls, starts))
 			dt = dtN
 		}
 	}
+	if ctx.ResetESRaw {
+		es.FinishReindex(&ctx)
+		lib.ClearESRawDays(con, &ctx)
+	}
+	// Each document type checkpoint is its max indexed row timestamp
+	lib.SetESRawCheckpoints(con, &ctx, maxes)
 	// Finished
 	lib.Printf("All done.\n")
 }
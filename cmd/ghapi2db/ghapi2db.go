@@ -24,6 +24,7 @@ func getAPIParams(ctx *lib.Ctx) (repos []string, isSingleRepo bool, singleRepo s
 
 	// Connect to Postgres DB
 	c = lib.PgConn(ctx)
+	lib.CheckSchema(c, ctx)
 
 	// Get list of repositories to process
 	recentReposDt := lib.GetDateAgo(c, ctx, lib.HourStart(time.Now()), ctx.RecentReposRange)
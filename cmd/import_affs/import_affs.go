@@ -386,6 +386,7 @@ func importAffs(jsonFN string) int {
 	// Connect to Postgres DB
 	con := lib.PgConn(&ctx)
 	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.CheckSchema(con, &ctx)
 
 	// Check if given file was already imported
 	currentSHA := ""
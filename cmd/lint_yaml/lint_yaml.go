@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// lintProject - validates metrics.yaml, tags.yaml, columns.yaml and vars files of a given project
+// returns number of problems found
+func lintProject(ctx *lib.Ctx, dataPrefix, project string) (nProblems int) {
+	// Use per project settings (ReadFile's /shared/ fallback and project specific metrics depend on it)
+	pctx := *ctx
+	pctx.Project = project
+	dir := dataPrefix + lib.Metrics + project + "/"
+	metricsYaml := pctx.MetricsYaml
+	tagsYaml := pctx.TagsYaml
+	columnsYaml := pctx.ColumnsYaml
+	varsYamls := []string{pctx.VarsYaml}
+	if ctx.Project == "" {
+		metricsYaml = lib.Metrics + project + "/metrics.yaml"
+		tagsYaml = lib.Metrics + project + "/tags.yaml"
+		columnsYaml = lib.Metrics + project + "/columns.yaml"
+		varsYamls = []string{lib.Metrics + project + "/" + pctx.VarsFnYaml}
+	}
+	// gha2db_sync uses sync_vars.yaml by default, it is optional
+	syncVarsYaml := lib.Metrics + project + "/sync_vars.yaml"
+	if varsYamls[0] != syncVarsYaml {
+		varsYamls = append(varsYamls, syncVarsYaml)
+	}
+
+	report := func(fn string, problems []string) {
+		for _, problem := range problems {
+			lib.Printf("%s: %s\n", fn, problem)
+		}
+		nProblems += len(problems)
+	}
+	read := func(fn string, optional bool) []byte {
+		data, err := lib.ReadFile(&pctx, dataPrefix+fn)
+		if err != nil {
+			if !optional {
+				report(fn, []string{err.Error()})
+			}
+			return nil
+		}
+		return data
+	}
+
+	// Metrics from included files are checked too
+	report(metricsYaml, lib.LintMetricsFile(&pctx, dataPrefix+metricsYaml, dir))
+	if data := read(tagsYaml, false); data != nil {
+		report(tagsYaml, lib.LintTags(&pctx, data, dir))
+	}
+	if data := read(columnsYaml, false); data != nil {
+		report(columnsYaml, lib.LintColumns(data))
+	}
+	for i, varsYaml := range varsYamls {
+		if data := read(varsYaml, i > 0); data != nil {
+			report(varsYaml, lib.LintVars(data))
+		}
+	}
+	lib.Printf("Project %s: %d problem(s) found\n", project, nProblems)
+	return
+}
+
+// lintYAMLs - validates YAML files of the current project or of all projects from projects.yaml
+func lintYAMLs() int {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	// Local or cron mode?
+	dataPrefix := ctx.DataDir
+	if ctx.Local {
+		dataPrefix = "./"
+	}
+
+	// Single project mode
+	if ctx.Project != "" {
+		return lintProject(&ctx, dataPrefix, ctx.Project)
+	}
+
+	// Read defined projects
+	data, err := ioutil.ReadFile(dataPrefix + ctx.ProjectsYaml)
+	lib.FatalOnError(err)
+	var projects lib.AllProjects
+	lib.FatalOnError(yaml.Unmarshal(data, &projects))
+
+	// Get ordered & filtered projects
+	names, _ := lib.GetProjectsList(&ctx, &projects)
+	nProblems := 0
+	for _, name := range names {
+		nProblems += lintProject(&ctx, dataPrefix, name)
+	}
+	return nProblems
+}
+
+func main() {
+	dtStart := time.Now()
+	nProblems := lintYAMLs()
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+	if nProblems > 0 {
+		lib.Printf("Found %d problem(s)\n", nProblems)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+// slowestMetrics - reports metrics with the highest average run time in the recent period
+func slowestMetrics(ctx *lib.Ctx, recent string, limit int) {
+	con := lib.PgConn(ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.FatalOnError(lib.EnsureMetricsRunsTable(con, ctx))
+	rows := lib.QuerySQLWithErr(
+		con,
+		ctx,
+		"select proj, metric, sql_file, period, count(*), avg(total_time), max(total_time), "+
+			"avg(sql_time), avg(write_time), avg(n_rows), avg(n_points) "+
+			"from gha_metrics_runs where dt >= now() - $1::interval "+
+			"group by proj, metric, sql_file, period order by avg(total_time) desc limit $2",
+		recent,
+		limit,
+	)
+	defer func() { lib.FatalOnError(rows.Close()) }()
+	var (
+		proj, metric, sqlFile, period                 string
+		runs                                          int
+		avgTime, maxTime, sqlTime, writeTime, nr, npt float64
+	)
+	lib.Printf("Slowest metrics in the last %s:\n", recent)
+	lib.Printf("%-20s %-30s %-50s %-12s %5s %10s %10s %10s %10s %12s %12s\n", "project", "metric", "sql", "period", "runs", "avg", "max", "sql", "write", "rows", "points")
+	for rows.Next() {
+		lib.FatalOnError(rows.Scan(&proj, &metric, &sqlFile, &period, &runs, &avgTime, &maxTime, &sqlTime, &writeTime, &nr, &npt))
+		lib.Printf(
+			"%-20s %-30s %-50s %-12s %5d %10.2f %10.2f %10.2f %10.2f %12.0f %12.0f\n",
+			proj, metric, sqlFile, period, runs, avgTime, maxTime, sqlTime, writeTime, nr, npt,
+		)
+	}
+	lib.FatalOnError(rows.Err())
+}
+
+// growingMetrics - reports metrics whose average run time grew the most
+// Compares the recent period with the previous period (which ends where the recent one starts)
+func growingMetrics(ctx *lib.Ctx, recent, previous string, days, limit int) {
+	con := lib.PgConn(ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.FatalOnError(lib.EnsureMetricsRunsTable(con, ctx))
+	rows := lib.QuerySQLWithErr(
+		con,
+		ctx,
+		"with recent as (select proj, metric, sql_file, period, avg(total_time) as t from gha_metrics_runs "+
+			"where dt >= now() - $1::interval group by proj, metric, sql_file, period), "+
+			"prev as (select proj, metric, sql_file, period, avg(total_time) as t from gha_metrics_runs "+
+			"where dt < now() - $1::interval and dt >= now() - $2::interval group by proj, metric, sql_file, period) "+
+			"select r.proj, r.metric, r.sql_file, r.period, p.t, r.t, r.t / p.t from recent r "+
+			"join prev p on r.proj = p.proj and r.metric = p.metric and r.sql_file = p.sql_file and r.period = p.period "+
+			"where p.t > 0 order by r.t / p.t desc limit $3",
+		recent,
+		previous,
+		limit,
+	)
+	defer func() { lib.FatalOnError(rows.Close()) }()
+	var (
+		proj, metric, sqlFile, period string
+		prevTime, recentTime, growth  float64
+	)
+	lib.Printf("Fastest growing metrics (last %s vs. previous %d days):\n", recent, 4*days)
+	lib.Printf("%-20s %-30s %-50s %-12s %10s %10s %8s\n", "project", "metric", "sql", "period", "before", "recent", "growth")
+	for rows.Next() {
+		lib.FatalOnError(rows.Scan(&proj, &metric, &sqlFile, &period, &prevTime, &recentTime, &growth))
+		lib.Printf("%-20s %-30s %-50s %-12s %10.2f %10.2f %7.2fx\n", proj, metric, sqlFile, period, prevTime, recentTime, growth)
+	}
+	lib.FatalOnError(rows.Err())
+}
+
+func main() {
+	dtStart := time.Now()
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+	// Metrics runs statistics are stored in `devstats` database
+	ctx.PgDB = lib.Devstats
+
+	// Optional arguments: number of recent days and number of metrics to report
+	days, limit := 7, 20
+	if len(os.Args) > 1 {
+		n, err := strconv.Atoi(os.Args[1])
+		lib.FatalOnError(err)
+		days = n
+	}
+	if len(os.Args) > 2 {
+		n, err := strconv.Atoi(os.Args[2])
+		lib.FatalOnError(err)
+		limit = n
+	}
+	if days <= 0 || limit <= 0 {
+		lib.Printf("Usage: %s [days [limit]], days and limit must be positive\n", os.Args[0])
+		os.Exit(1)
+	}
+	recent := fmt.Sprintf("%d days", days)
+	previous := fmt.Sprintf("%d days", 5*days)
+	slowestMetrics(&ctx, recent, limit)
+	growingMetrics(&ctx, recent, previous, days, limit)
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+// tablePrimaryKey - returns primary key columns of a given table (in key order)
+func tablePrimaryKey(con *sql.DB, ctx *lib.Ctx, table string) (pk []string) {
+	rows := lib.QuerySQLWithErr(
+		con,
+		ctx,
+		"select a.attname from pg_index i, pg_attribute a where i.indrelid = $1::regclass and i.indisprimary "+
+			"and a.attrelid = i.indrelid and a.attnum = any(i.indkey) order by array_position(i.indkey::int2[], a.attnum)",
+		table,
+	)
+	defer func() { lib.FatalOnError(rows.Close()) }()
+	column := ""
+	for rows.Next() {
+		lib.FatalOnError(rows.Scan(&column))
+		pk = append(pk, column)
+	}
+	lib.FatalOnError(rows.Err())
+	return
+}
+
+// tableIndexes - returns names and definitions of all indexes of a given table
+func tableIndexes(con *sql.DB, ctx *lib.Ctx, table string) (indexes []lib.PartitionIndex) {
+	rows := lib.QuerySQLWithErr(
+		con,
+		ctx,
+		"select indexname, indexdef from pg_indexes where schemaname = 'public' and tablename = $1 order by indexname",
+		table,
+	)
+	defer func() { lib.FatalOnError(rows.Close()) }()
+	for rows.Next() {
+		index := lib.PartitionIndex{}
+		lib.FatalOnError(rows.Scan(&index.Name, &index.Def))
+		indexes = append(indexes, index)
+	}
+	lib.FatalOnError(rows.Err())
+	return
+}
+
+// partitionTable - converts a single table into a time range partitioned table, in a single transaction
+func partitionTable(con *sql.DB, ctx *lib.Ctx, pt lib.PartitionTable) {
+	if !lib.TableExists(con, ctx, pt.Name) {
+		lib.Printf("Table %s doesn't exist, skipping\n", pt.Name)
+		return
+	}
+	period := lib.TablePartitionPeriod(con, ctx, pt.Name)
+	if period != "" {
+		lib.Printf("Table %s is already partitioned by '%s', skipping\n", pt.Name, period)
+		return
+	}
+	var from, to *time.Time
+	lib.FatalOnError(lib.QueryRowSQL(con, ctx, "select min("+pt.Column+"), max("+pt.Column+") from "+pt.Name).Scan(&from, &to))
+	now := time.Now()
+	if from == nil || to == nil {
+		from, to = &now, &now
+	}
+	if to.Before(now) {
+		to = &now
+	}
+	_, _, _, nextIntervalStart, _ := lib.GetIntervalFunctions(ctx.Partition, false)
+	stmts := lib.PartitionMigrationSQL(
+		pt.Name,
+		ctx.Partition,
+		tablePrimaryKey(con, ctx, pt.Name),
+		tableIndexes(con, ctx, pt.Name),
+		*from,
+		nextIntervalStart(*to),
+	)
+	if ctx.DryRun {
+		lib.Printf("Would partition %s by %s (%s - %s):\n%s\n", pt.Name, pt.Column, lib.ToYMDDate(*from), lib.ToYMDDate(*to), strings.Join(stmts, ";\n"))
+		return
+	}
+	lib.Printf("Partitioning %s by %s (%s - %s), %d statements\n", pt.Name, pt.Column, lib.ToYMDDate(*from), lib.ToYMDDate(*to), len(stmts))
+	dtStart := time.Now()
+	tx, err := con.Begin()
+	lib.FatalOnError(err)
+	for _, stmt := range stmts {
+		lib.ExecSQLTxWithErr(tx, ctx, stmt)
+	}
+	lib.FatalOnError(tx.Commit())
+	lib.Printf("Partitioned %s in %v\n", pt.Name, time.Now().Sub(dtStart))
+}
+
+// Migrate existing gha_events, gha_payloads, gha_commits and gha_texts tables (or only given ones)
+// to time range partitioned tables using GHA2DB_PARTITION interval
+func partitionTables(names []string) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+	if ctx.Partition == "" {
+		lib.Fatalf("you need to set partition interval via GHA2DB_PARTITION (m, q or y)")
+	}
+	filter := make(map[string]struct{})
+	for _, name := range names {
+		filter[name] = struct{}{}
+	}
+	tables := []lib.PartitionTable{}
+	for _, pt := range lib.PartitionTables() {
+		if _, ok := filter[pt.Name]; len(filter) > 0 && !ok {
+			continue
+		}
+		delete(filter, pt.Name)
+		tables = append(tables, pt)
+	}
+	if len(filter) > 0 {
+		lib.Fatalf("tables cannot be partitioned: %v", lib.StringsSetKeys(filter))
+	}
+
+	// Connect to Postgres DB
+	con := lib.PgConn(&ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.CheckSchema(con, &ctx)
+
+	// Tables are locked and fully copied, ask for confirmation
+	if !ctx.DryRun {
+		lib.Printf("This program will copy all data of %d tables into partitioned tables\n", len(tables))
+		lib.Printf("Continue? (y/n) ")
+		c := lib.Mgetc(&ctx)
+		lib.Printf("\n")
+		if c != "y" {
+			return
+		}
+	}
+	for _, pt := range tables {
+		partitionTable(con, &ctx, pt)
+	}
+}
+
+func main() {
+	dtStart := time.Now()
+	partitionTables(os.Args[1:])
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+// diffSource - argument is either a database name or a directory with export_tsdb snapshot
+func diffSource(ctx *lib.Ctx, arg string) *lib.DiffSource {
+	info, err := os.Stat(arg)
+	if err == nil && info.IsDir() {
+		return lib.NewSnapshotDiffSource(ctx, arg)
+	}
+	return lib.NewDBDiffSource(ctx, arg)
+}
+
+// Compare series tables between two databases or a database and an exported snapshot
+func seriesDiff(args []string) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	a := diffSource(&ctx, args[0])
+	defer a.Close()
+	b := diffSource(&ctx, args[1])
+	defer b.Close()
+
+	diffs := lib.DiffSeries(&ctx, a, b)
+	counts := make(map[string]int)
+	changed := []*lib.SeriesDiff{}
+	for _, diff := range diffs {
+		status := diff.Status()
+		counts[status]++
+		switch status {
+		case "added":
+			lib.Printf("+ %s: %d points\n", diff.Name(), diff.PointsB)
+		case "removed":
+			lib.Printf("- %s: %d points\n", diff.Name(), diff.PointsA)
+		case "changed":
+			changed = append(changed, diff)
+		}
+	}
+	sort.SliceStable(changed, func(i, j int) bool {
+		return changed[i].Added+changed[i].Removed+changed[i].Changed > changed[j].Added+changed[j].Removed+changed[j].Changed
+	})
+	for _, diff := range changed {
+		lib.Printf(
+			"~ %s: points %d -> %d, added %d, removed %d, changed %d, max diff %g (%.2f%%), sum %g -> %g\n",
+			diff.Name(), diff.PointsA, diff.PointsB, diff.Added, diff.Removed, diff.Changed,
+			diff.MaxAbsDiff, diff.MaxRelDiff*100.0, diff.SumA, diff.SumB,
+		)
+	}
+	lib.Printf(
+		"%s vs %s (tolerance %g): %d series, %d equal, %d added, %d removed, %d changed\n",
+		a.Name, b.Name, ctx.DiffTolerance, len(diffs), counts[""], counts["added"], counts["removed"], counts["changed"],
+	)
+}
+
+func main() {
+	dtStart := time.Now()
+	if len(os.Args) < 3 {
+		fmt.Printf("%s: required two arguments: database name or export_tsdb snapshot directory\n", os.Args[0])
+		fmt.Printf("Example: %s gha gha_test\n", os.Args[0])
+		fmt.Printf("Example: %s gha ./export/\n", os.Args[0])
+		fmt.Printf("Use GHA2DB_DIFF_SERIES to limit compared series tables and GHA2DB_DIFF_TOLERANCE to ignore small differences\n")
+		os.Exit(1)
+	}
+	seriesDiff(os.Args[1:])
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+}
@@ -1,17 +1,53 @@
 package main
 
 import (
+	"os"
+	"strconv"
 	"time"
 
 	lib "github.com/cncf/devstatscode"
 )
 
+// migrate - migrates existing database schema to a given version (latest by default)
+func migrate(ctx *lib.Ctx, args []string) {
+	target := lib.LatestSchemaVersion()
+	if len(args) > 0 {
+		var err error
+		target, err = strconv.Atoi(args[0])
+		lib.FatalOnError(err)
+	}
+	con := lib.PgConn(ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.MigrateSchema(con, ctx, target)
+	// Metrics runs statistics of all projects are stored in `devstats` database, ensuring also adds columns added later
+	if ctx.DryRun {
+		return
+	}
+	if ctx.PgDB == lib.Devstats {
+		lib.FatalOnError(lib.EnsureMetricsRunsTable(con, ctx))
+		return
+	}
+	dctx := *ctx
+	dctx.PgDB = lib.Devstats
+	dcon := lib.PgConn(&dctx)
+	defer func() { lib.FatalOnError(dcon.Close()) }()
+	lib.FatalOnError(lib.EnsureMetricsRunsTable(dcon, &dctx))
+}
+
 func main() {
 	dtStart := time.Now()
 	// Environment context parse
 	var ctx lib.Ctx
 	ctx.Init()
 
+	// Migrate existing database instead of (re)creating its structure
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(&ctx, os.Args[2:])
+		dtEnd := time.Now()
+		lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+		return
+	}
+
 	// Create database if needed
 	createdDatabase := lib.CreateDatabaseIfNeeded(&ctx)
 
@@ -20,6 +56,7 @@ func main() {
 	if !createdDatabase {
 		if ctx.Table {
 			lib.Printf("This program will recreate DB structure (dropping all existing data)\n")
+			lib.Printf("Use 'structure migrate' to upgrade existing database schema instead\n")
 		}
 		lib.Printf("Continue? (y/n) ")
 		c := lib.Mgetc(&ctx)
@@ -16,6 +16,7 @@ func calcTags() {
 	// Connect to Postgres DB
 	con := lib.PgConn(&ctx)
 	defer func() { lib.FatalOnError(con.Close()) }()
+	lib.CheckSchema(con, &ctx)
 
 	// Optional ElasticSearch output
 	var es *lib.ES
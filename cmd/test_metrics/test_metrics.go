@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// testMetric - loads fixture into a scratch database, calculates metric and compares TSDB tables with golden file
+// Returns true when metric output matches golden file (or when golden file was updated)
+func testMetric(ctx *lib.Ctx, dataPrefix string, allMetrics *lib.AllMetrics, fn string) bool {
+	lib.Printf("Metric test %s\n", fn)
+	data, err := lib.ReadFile(ctx, fn)
+	lib.FatalOnError(err)
+	var fixture lib.MetricFixture
+	lib.FatalOnError(yaml.UnmarshalStrict(data, &fixture))
+
+	// Get metric parameters from metrics.yaml if metric name is given
+	seriesNameOrFunc := fixture.SeriesNameOrFunc
+	sqlFile := fixture.SQL
+	opts := []string{}
+	if fixture.Metric != "" {
+		found := false
+		for _, metric := range allMetrics.Metrics {
+			if metric.Name != fixture.Metric || lib.ExcludedForProject(ctx.Project, metric.Project) {
+				continue
+			}
+			found = true
+			if seriesNameOrFunc == "" {
+				seriesNameOrFunc = metric.SeriesNameOrFunc
+				if metric.AddPeriodToName {
+					seriesNameOrFunc += "_" + fixture.Period
+				}
+			}
+			if sqlFile == "" {
+				if metric.MetricSQLs != nil {
+					lib.Fatalf("%s: metric '%s' uses 'sqls', fixture must specify 'sql'", fn, metric.Name)
+				}
+				sqlFile = metric.MetricSQL
+			}
+			opts = metric.CalcMetricOptions(ctx)
+			break
+		}
+		if !found {
+			lib.Fatalf("%s: metric '%s' not found in metrics.yaml", fn, fixture.Metric)
+		}
+	}
+	if fixture.Options != "" {
+		opts = append(opts, fixture.Options)
+	}
+	if seriesNameOrFunc == "" || sqlFile == "" || fixture.From == "" || fixture.To == "" || fixture.Period == "" {
+		lib.Fatalf("%s: series_name_or_func, sql, from, to and period are required: %+v", fn, fixture)
+	}
+
+	// Scratch database with gha_* tables only
+	lib.DropDatabaseIfExists(ctx)
+	lib.CreateDatabaseIfNeeded(ctx)
+	sctx := *ctx
+	sctx.Table = true
+	sctx.Index = false
+	sctx.Tools = false
+	lib.Structure(&sctx)
+	con := lib.PgConn(ctx)
+	defer func() {
+		lib.FatalOnError(con.Close())
+		lib.DropDatabaseIfExists(ctx)
+	}()
+	lib.InsertFixtureRows(con, ctx, fixture.Tables)
+	before := make(map[string]struct{})
+	for _, table := range lib.GetSeriesTables(con, ctx) {
+		before[table] = struct{}{}
+	}
+
+	// Calculate metric in-process, the same way as calc_metric does
+	lib.NewMetricsCalculator(ctx, con).CalcMetric(
+		seriesNameOrFunc,
+		dataPrefix+lib.Metrics+ctx.Project+"/"+sqlFile+".sql",
+		fixture.From,
+		fixture.To,
+		fixture.Period,
+		lib.ParseCalcMetricOptions(strings.Join(opts, ",")),
+	)
+
+	// Get all TSDB tables created by the metric
+	tables := []string{}
+	for _, table := range lib.GetSeriesTables(con, ctx) {
+		if _, ok := before[table]; !ok {
+			tables = append(tables, table)
+		}
+	}
+	got := lib.DumpSeriesRows(con, ctx, tables)
+
+	// Update or compare with golden file
+	goldenFn := strings.TrimSuffix(fn, ".yaml") + ".golden.yaml"
+	if ctx.UpdateGolden {
+		lib.ObjectToYAML(got, goldenFn)
+		lib.Printf("Metric test %s: written %d tables to %s\n", fn, len(got), goldenFn)
+		return true
+	}
+	data, err = lib.ReadFile(ctx, goldenFn)
+	lib.FatalOnError(err)
+	var expected lib.SeriesRows
+	lib.FatalOnError(yaml.Unmarshal(data, &expected))
+	diffs := lib.DiffSeriesRows(expected, got)
+	for _, diff := range diffs {
+		lib.Printf("%s\n", diff)
+	}
+	if len(diffs) > 0 {
+		lib.Printf("Metric test %s: FAILED, %d differences\n", fn, len(diffs))
+		return false
+	}
+	lib.Printf("Metric test %s: OK\n", fn)
+	return true
+}
+
+// testMetrics - runs given metric fixtures or all fixtures from metrics/{project}/tests/
+// Returns number of failed tests
+func testMetrics(fixtures []string) int {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+	ctx.SkipTSDB = false
+	ctx.UseES = false
+	ctx.UseESOnly = false
+	ctx.UseProm = false
+	ctx.UsePromOnly = false
+	ctx.UseInflux = false
+
+	// Scratch database is dropped and recreated for every fixture
+	if ctx.PgDB != "dbtest" {
+		lib.Fatalf("metric tests can only be run on \"dbtest\" database")
+	}
+	if ctx.Project == "" {
+		lib.Fatalf("you have to set project via GHA2DB_PROJECT environment variable")
+	}
+
+	// Local or cron mode?
+	dataPrefix := ctx.DataDir
+	if ctx.Local {
+		dataPrefix = "./"
+	}
+
+	// Read metrics configuration
+	allMetrics, err := lib.ReadMetrics(&ctx, dataPrefix+ctx.MetricsYaml)
+	lib.FatalOnError(err)
+
+	if len(fixtures) == 0 {
+		fns, err := filepath.Glob(dataPrefix + lib.Metrics + ctx.Project + "/tests/*.yaml")
+		lib.FatalOnError(err)
+		for _, fn := range fns {
+			if !strings.HasSuffix(fn, ".golden.yaml") {
+				fixtures = append(fixtures, fn)
+			}
+		}
+	}
+	failed := 0
+	for _, fn := range fixtures {
+		if !testMetric(&ctx, dataPrefix, allMetrics, fn) {
+			failed++
+		}
+	}
+	lib.Printf("Metric tests: %d, failed: %d\n", len(fixtures), failed)
+	return failed
+}
+
+func main() {
+	dtStart := time.Now()
+	failed := testMetrics(os.Args[1:])
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+// Apply project's TSDB retention rules (metrics.yaml `retention:` section): remove expired fine-grained
+// points, optionally rolling them up into a coarser period first
+func tsdbRetention() {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	// Local or cron mode?
+	dataPrefix := ctx.DataDir
+	if ctx.Local {
+		dataPrefix = "./"
+	}
+
+	// Read metrics configuration (with includes and overrides resolved)
+	allMetrics, err := lib.ReadMetrics(&ctx, dataPrefix+ctx.MetricsYaml)
+	lib.FatalOnError(err)
+	lib.FatalOnError(lib.CheckRetentionRules(allMetrics.Retention))
+	lib.FatalOnError(lib.CheckRetentionSeries(&ctx, allMetrics))
+	if len(allMetrics.Retention) == 0 {
+		lib.Printf("No retention rules defined in %s\n", ctx.MetricsYaml)
+		return
+	}
+
+	// Connect to Postgres DB
+	con := lib.PgConn(&ctx)
+	defer func() { lib.FatalOnError(con.Close()) }()
+
+	stats := lib.ApplyRetention(con, &ctx, allMetrics.Retention, time.Now())
+	var rolledUp, deleted int64
+	for _, st := range stats {
+		rolledUp += st.RolledUp
+		deleted += st.Deleted
+	}
+	if ctx.DryRun {
+		lib.Printf("Dry run: %d tables/periods checked, %d points would be removed\n", len(stats), deleted)
+		return
+	}
+	lib.Printf("Retention applied to %d tables/periods: rolled up %d, removed %d points\n", len(stats), rolledUp, deleted)
+}
+
+func main() {
+	dtStart := time.Now()
+	tsdbRetention()
+	dtEnd := time.Now()
+	lib.Printf("Time: %v\n", dtEnd.Sub(dtStart))
+}
@@ -13,28 +13,6 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-// vars contain list of Postgres variables to set
-type pvars struct {
-	Vars []pvar `yaml:"vars"`
-}
-
-// pvar contain each Postgres data
-type pvar struct {
-	Name          string     `yaml:"name"`
-	Type          string     `yaml:"type"`
-	Value         string     `yaml:"value"`
-	Command       []string   `yaml:"command"`
-	Replaces      [][]string `yaml:"replaces"`
-	Disabled      bool       `yaml:"disabled"`
-	NoWrite       bool       `yaml:"no_write"`
-	Queries       [][]string `yaml:"queries"`
-	Loops         [][]int    `yaml:"loops"`
-	QueriesBefore bool       `yaml:"queries_before"`
-	QueriesAfter  bool       `yaml:"queries_after"`
-	LoopsBefore   bool       `yaml:"loops_before"`
-	LoopsAfter    bool       `yaml:"loops_after"`
-}
-
 func processLoops(str string, loops [][]int) string {
 	for _, loop := range loops {
 		loopN := loop[0]
@@ -178,7 +156,7 @@ func pdbVars() {
 		lib.FatalOnError(err)
 		return
 	}
-	var allVars pvars
+	var allVars lib.Vars
 	lib.FatalOnError(yaml.Unmarshal(data, &allVars))
 
 	// All key name - values are stored in map
@@ -191,6 +169,8 @@ func pdbVars() {
 	}
 	// Queries
 	queries := make(map[string]map[string][][]string)
+	// Written variables are described in series catalog
+	catalog := []lib.SeriesCatalogEntry{}
 	// Iterate vars
 	for _, va := range allVars.Vars {
 		// If given variable name is in the exclude list, skip it
@@ -322,11 +302,23 @@ func pdbVars() {
 				va.Value,
 				va.Name,
 			)
+			catalog = append(
+				catalog,
+				lib.SeriesCatalogEntry{
+					Series:  va.Name,
+					Kind:    "var",
+					Sources: []string{"vars:" + ctx.VarsYaml},
+					Columns: map[string]string{"value_" + va.Type: va.Type},
+				},
+			)
 		} else if ctx.Debug > 0 {
 			lib.Printf("Skipping postgres vars write\n")
 		}
 	}
 
+	// Variables are not TSDB series, but dashboards use them the same way
+	lib.UpdateSeriesCatalog(c, &ctx, catalog)
+
 	// Output to ElasticSearch
 	if ctx.UseES {
 		if es.IndexExists(&ctx) {
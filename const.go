@@ -147,6 +147,9 @@ const Companies string = "Companies"
 // SiteStats - common constant string
 const SiteStats string = "SiteStats"
 
+// SeriesCatalog - common constant string
+const SeriesCatalog string = "SeriesCatalog"
+
 // Day - common constant string
 const Day string = "day"
 
@@ -15,7 +15,7 @@ type Ctx struct {
 	Debug                    int                          // From GHA2DB_DEBUG Debug level: 0-no, 1-info, 2-verbose, including SQLs, default 0
 	CmdDebug                 int                          // From GHA2DB_CMDDEBUG Commands execution Debug level: 0-no, 1-only output commands, 2-output commands and their output, 3-output full environment as well, default 0
 	GitHubDebug              int                          // From GHA2DB_GITHUB_DEBUG debug GitHub rate limits
-	DryRun                   bool                         // From GHA2DB_DRY_RUN, import_affs tool - stop before doing any updates
+	DryRun                   bool                         // From GHA2DB_DRY_RUN, import_affs tool - stop before doing any updates, calc_metric tool - print final SQLs and resulting points as JSON instead of writing them, tsdb_retention tool - only count expired points
 	JSONOut                  bool                         // From GHA2DB_JSON gha2db: write JSON files? default false
 	DBOut                    bool                         // From GHA2DB_NODB gha2db: write to SQL database, default true
 	ST                       bool                         // From GHA2DB_ST true: use single threaded version, false: use multi threaded version, default false
@@ -150,6 +150,36 @@ type Ctx struct {
 	CommitsLOCStatsEnabled   bool                         // True, can be disabled by GHA2DB_SKIP_COMMITS_LOC, get_repos tool
 	RecalcReciprocal         int                          // From GHA2DB_RECALC_RECIPROCAL: 1/RecalcReciprocal of recalc metric at given datetime, even if it should be calculated at this datetime, default 24 (means 4.1(6)%, or about once/day)
 	MaxHistograms            int                          // From GHA2DB_MAX_HIST: maximum histogram concurrency, default: 0 - means unlimited
+	CalcMetricExec           bool                         // From GHA2DB_CALC_METRIC_EXEC, gha2db_sync tool: run a separate calc_metric process for every metric instead of calculating metrics in-process, default false
+	UpdateGolden             bool                         // From GHA2DB_UPDATE_GOLDEN, test_metrics tool: write metric tests golden files instead of comparing with them, default false
+	MetricsBudget            int                          // From GHA2DB_METRICS_BUDGET, gha2db_sync tool: metrics scheduler concurrency budget (max sum of running metrics weights), default: 0 - means number of CPUs
+	UseProm                  bool                         // From GHA2DB_USE_PROM, calc_metric tool - also write TS points to Prometheus (remote-write or OpenMetrics files), default false
+	UsePromOnly              bool                         // From GHA2DB_USE_PROM_ONLY, calc_metric tool - enable Prometheus output and do not write PSQL TSDB, default false
+	PromURL                  string                       // From GHA2DB_PROM_URL, calc_metric tool - Prometheus remote-write endpoint, if not set OpenMetrics text files are written to PromDir
+	PromDir                  string                       // From GHA2DB_PROM_DIR, calc_metric tool - directory for OpenMetrics text files, default "./prom/"
+	UseInflux                bool                         // From GHA2DB_USE_INFLUX, calc_metric tool - also write TS points in InfluxDB line protocol, default false
+	InfluxURL                string                       // From GHA2DB_INFLUX_URL, calc_metric tool - InfluxDB write endpoint (for example http://127.0.0.1:8086/write?db=devstats), if not set line protocol files are written to InfluxDir
+	InfluxDir                string                       // From GHA2DB_INFLUX_DIR, calc_metric tool - directory for line protocol files, default "./influx/"
+	ExportDir                string                       // From GHA2DB_EXPORT_DIR, export_tsdb tool - output directory, default "./export/"
+	ExportFormat             string                       // From GHA2DB_EXPORT_FORMAT, export_tsdb tool - "csv" or "parquet", default "csv"
+	ExportFrom               time.Time                    // From GHA2DB_EXPORT_FROM, export_tsdb tool - export series rows with time >= this date (rounded down to month start, partitions are whole months), default: not set - no lower bound
+	ExportTo                 time.Time                    // From GHA2DB_EXPORT_TO, export_tsdb tool - export series rows with time < this date (rounded up to next month start), default: not set - no upper bound
+	ExportSeries             *regexp.Regexp               // From GHA2DB_EXPORT_SERIES, export_tsdb tool - export only series tables matching this regexp, default "" which means all series tables
+	ExportIncremental        bool                         // From GHA2DB_EXPORT_INCREMENTAL, export_tsdb tool - only export data since the last export (uses manifest from export directory), default false
+	AnomalySeries            *regexp.Regexp               // From GHA2DB_ANOMALY_SERIES, gha2db_sync tool - after sync check latest points of series tables matching this regexp for anomalies, default "" which means no anomaly detection
+	AnomalyWindow            int                          // From GHA2DB_ANOMALY_WINDOW, gha2db_sync tool - number of previous points used as anomaly detection baseline, default 12
+	AnomalyThreshold         float64                      // From GHA2DB_ANOMALY_THRESHOLD, gha2db_sync tool - robust z-score (distance from baseline median in scaled MADs) above which point is an anomaly, default 5.0
+	DiffSeries               *regexp.Regexp               // From GHA2DB_DIFF_SERIES, series_diff tool - compare only series tables matching this regexp, default "" which means all series tables
+	DiffTolerance            float64                      // From GHA2DB_DIFF_TOLERANCE, series_diff tool - values differing by at most tolerance * max(1, |a|, |b|) are equal, default 0
+	ExplainCostRatio         float64                      // From GHA2DB_EXPLAIN_COST_RATIO, explain_metrics tool - report regression when estimated cost grows at least this many times since the previous run, default 2.0
+	ExplainMinCost           float64                      // From GHA2DB_EXPLAIN_MIN_COST, explain_metrics tool - do not report regressions of queries with estimated cost below this value, default 1000
+	BackfillDrop             bool                         // From GHA2DB_BACKFILL_DROP, backfill_metric tool - remove metric's points in the backfilled range before recalculating (not allowed for merge_series metrics), default false
+	Partition                string                       // From GHA2DB_PARTITION, structure and partition_tables tools - create gha_events, gha_payloads, gha_commits and gha_texts as time range partitioned tables with this partition interval (m, q or y), default "" (not partitioned)
+	SkipSchemaCheck          bool                         // From GHA2DB_SKIP_SCHEMA_CHECK, all tools - do not refuse to run when database schema is not at the latest migration version, default false
+	ESIndexSettings          string                       // From GHA2DB_ES_INDEX_SETTINGS, calc_metric, tags, annotations, vars and gha2es tools - JSON object with settings of created ES indexes and templates, default {"number_of_shards":5,"number_of_replicas":0}
+	ESBulkRetries            int                          // From GHA2DB_ES_BULK_RETRIES, calc_metric, tags, annotations, vars and gha2es tools - how many times rejected bulk items (or failed bulk requests) are retried with backoff, default 5
+	ESRawLookback            int                          // From GHA2DB_ES_RAW_LOOKBACK, gha2es tool - number of already indexed days checked for late arriving rows by incremental raw ES indexing, default 2
+	HistPartialsLag          int                          // From GHA2DB_HIST_PARTIALS_LAG, calc_metric tool - mergeable histograms only store days ending this many hours before the last synced hour, default 2
 }
 
 // Init - get context from environment variables
@@ -672,6 +702,158 @@ func (ctx *Ctx) Init() {
 		ctx.ESBulkSize = size
 	}
 
+	// Prometheus
+	ctx.UsePromOnly = os.Getenv("GHA2DB_USE_PROM_ONLY") != ""
+	ctx.UseProm = os.Getenv("GHA2DB_USE_PROM") != "" || ctx.UsePromOnly
+	ctx.PromURL = os.Getenv("GHA2DB_PROM_URL")
+	ctx.PromDir = os.Getenv("GHA2DB_PROM_DIR")
+	if ctx.PromDir == "" {
+		ctx.PromDir = "./prom/"
+	}
+	if ctx.PromDir[len(ctx.PromDir)-1:] != "/" {
+		ctx.PromDir += "/"
+	}
+
+	// InfluxDB line protocol
+	ctx.UseInflux = os.Getenv("GHA2DB_USE_INFLUX") != ""
+	ctx.InfluxURL = os.Getenv("GHA2DB_INFLUX_URL")
+	ctx.InfluxDir = os.Getenv("GHA2DB_INFLUX_DIR")
+	if ctx.InfluxDir == "" {
+		ctx.InfluxDir = "./influx/"
+	}
+	if ctx.InfluxDir[len(ctx.InfluxDir)-1:] != "/" {
+		ctx.InfluxDir += "/"
+	}
+
+	// TSDB export
+	ctx.ExportDir = os.Getenv("GHA2DB_EXPORT_DIR")
+	if ctx.ExportDir == "" {
+		ctx.ExportDir = "./export/"
+	}
+	if ctx.ExportDir[len(ctx.ExportDir)-1:] != "/" {
+		ctx.ExportDir += "/"
+	}
+	ctx.ExportFormat = os.Getenv("GHA2DB_EXPORT_FORMAT")
+	if ctx.ExportFormat == "" {
+		ctx.ExportFormat = "csv"
+	}
+	if ctx.ExportFormat != "csv" && ctx.ExportFormat != "parquet" {
+		FatalNoLog(fmt.Errorf("unknown export format '%s', allowed: csv, parquet", ctx.ExportFormat))
+	}
+	if os.Getenv("GHA2DB_EXPORT_FROM") != "" {
+		ctx.ExportFrom = TimeParseAny(os.Getenv("GHA2DB_EXPORT_FROM"))
+	}
+	if os.Getenv("GHA2DB_EXPORT_TO") != "" {
+		ctx.ExportTo = TimeParseAny(os.Getenv("GHA2DB_EXPORT_TO"))
+	}
+	exportSeries := os.Getenv("GHA2DB_EXPORT_SERIES")
+	if exportSeries != "" {
+		ctx.ExportSeries = regexp.MustCompile(exportSeries)
+	}
+	ctx.ExportIncremental = os.Getenv("GHA2DB_EXPORT_INCREMENTAL") != ""
+
+	// Anomaly detection
+	anomalySeries := os.Getenv("GHA2DB_ANOMALY_SERIES")
+	if anomalySeries != "" {
+		ctx.AnomalySeries = regexp.MustCompile(anomalySeries)
+	}
+	ctx.AnomalyWindow = 12
+	if os.Getenv("GHA2DB_ANOMALY_WINDOW") != "" {
+		aw, err := strconv.Atoi(os.Getenv("GHA2DB_ANOMALY_WINDOW"))
+		FatalNoLog(err)
+		if aw > 2 {
+			ctx.AnomalyWindow = aw
+		}
+	}
+	ctx.AnomalyThreshold = 5.0
+	if os.Getenv("GHA2DB_ANOMALY_THRESHOLD") != "" {
+		at, err := strconv.ParseFloat(os.Getenv("GHA2DB_ANOMALY_THRESHOLD"), 64)
+		FatalNoLog(err)
+		if at > 0.0 {
+			ctx.AnomalyThreshold = at
+		}
+	}
+
+	// Series diff
+	diffSeries := os.Getenv("GHA2DB_DIFF_SERIES")
+	if diffSeries != "" {
+		ctx.DiffSeries = regexp.MustCompile(diffSeries)
+	}
+	if os.Getenv("GHA2DB_DIFF_TOLERANCE") != "" {
+		dt, err := strconv.ParseFloat(os.Getenv("GHA2DB_DIFF_TOLERANCE"), 64)
+		FatalNoLog(err)
+		if dt > 0.0 {
+			ctx.DiffTolerance = dt
+		}
+	}
+
+	// Explain plans
+	ctx.ExplainCostRatio = 2.0
+	if os.Getenv("GHA2DB_EXPLAIN_COST_RATIO") != "" {
+		ratio, err := strconv.ParseFloat(os.Getenv("GHA2DB_EXPLAIN_COST_RATIO"), 64)
+		FatalNoLog(err)
+		if ratio > 1.0 {
+			ctx.ExplainCostRatio = ratio
+		}
+	}
+	ctx.ExplainMinCost = 1000.0
+	if os.Getenv("GHA2DB_EXPLAIN_MIN_COST") != "" {
+		mc, err := strconv.ParseFloat(os.Getenv("GHA2DB_EXPLAIN_MIN_COST"), 64)
+		FatalNoLog(err)
+		if mc >= 0.0 {
+			ctx.ExplainMinCost = mc
+		}
+	}
+
+	// Backfill
+	ctx.BackfillDrop = os.Getenv("GHA2DB_BACKFILL_DROP") != ""
+
+	// Partitioning
+	ctx.Partition = os.Getenv("GHA2DB_PARTITION")
+	if ctx.Partition != "" {
+		FatalNoLog(CheckPartitionPeriod(ctx.Partition))
+	}
+
+	// Schema migrations
+	ctx.SkipSchemaCheck = os.Getenv("GHA2DB_SKIP_SCHEMA_CHECK") != ""
+
+	// ES index settings
+	ctx.ESIndexSettings = os.Getenv("GHA2DB_ES_INDEX_SETTINGS")
+	if ctx.ESIndexSettings == "" {
+		ctx.ESIndexSettings = `{"number_of_shards":5,"number_of_replicas":0}`
+	}
+	FatalNoLog(CheckESIndexSettings(ctx.ESIndexSettings))
+
+	// ES bulk retries
+	ctx.ESBulkRetries = 5
+	if os.Getenv("GHA2DB_ES_BULK_RETRIES") != "" {
+		retries, err := strconv.Atoi(os.Getenv("GHA2DB_ES_BULK_RETRIES"))
+		FatalNoLog(err)
+		if retries >= 0 {
+			ctx.ESBulkRetries = retries
+		}
+	}
+
+	// ES raw lookback days
+	ctx.ESRawLookback = 2
+	if os.Getenv("GHA2DB_ES_RAW_LOOKBACK") != "" {
+		lookback, err := strconv.Atoi(os.Getenv("GHA2DB_ES_RAW_LOOKBACK"))
+		FatalNoLog(err)
+		if lookback >= 0 {
+			ctx.ESRawLookback = lookback
+		}
+	}
+
+	// Mergeable histograms partials lag
+	ctx.HistPartialsLag = 2
+	if os.Getenv("GHA2DB_HIST_PARTIALS_LAG") != "" {
+		lag, err := strconv.Atoi(os.Getenv("GHA2DB_HIST_PARTIALS_LAG"))
+		FatalNoLog(err)
+		if lag >= 0 {
+			ctx.HistPartialsLag = lag
+		}
+	}
+
 	// HTTP Timeout
 	if os.Getenv("GHA2DB_HTTP_TIMEOUT") == "" {
 		ctx.HTTPTimeout = 3
@@ -820,6 +1002,21 @@ func (ctx *Ctx) Init() {
 		}
 	}
 
+	// Calculate metrics using separate calc_metric processes
+	ctx.CalcMetricExec = os.Getenv("GHA2DB_CALC_METRIC_EXEC") != ""
+
+	// Metric tests golden files update mode
+	ctx.UpdateGolden = os.Getenv("GHA2DB_UPDATE_GOLDEN") != ""
+
+	// MetricsBudget
+	if os.Getenv("GHA2DB_METRICS_BUDGET") != "" {
+		mb, err := strconv.Atoi(os.Getenv("GHA2DB_METRICS_BUDGET"))
+		FatalNoLog(err)
+		if mb > 0 {
+			ctx.MetricsBudget = mb
+		}
+	}
+
 	// Context out if requested
 	if ctx.CtxOut {
 		ctx.Print()
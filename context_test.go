@@ -151,6 +151,36 @@ func copyContext(in *lib.Ctx) *lib.Ctx {
 		EnableMetricsDrop:        in.EnableMetricsDrop,
 		RecalcReciprocal:         in.RecalcReciprocal,
 		MaxHistograms:            in.MaxHistograms,
+		CalcMetricExec:           in.CalcMetricExec,
+		UpdateGolden:             in.UpdateGolden,
+		MetricsBudget:            in.MetricsBudget,
+		UseProm:                  in.UseProm,
+		UsePromOnly:              in.UsePromOnly,
+		PromURL:                  in.PromURL,
+		PromDir:                  in.PromDir,
+		UseInflux:                in.UseInflux,
+		InfluxURL:                in.InfluxURL,
+		InfluxDir:                in.InfluxDir,
+		ExportDir:                in.ExportDir,
+		ExportFormat:             in.ExportFormat,
+		ExportFrom:               in.ExportFrom,
+		ExportTo:                 in.ExportTo,
+		ExportSeries:             in.ExportSeries,
+		ExportIncremental:        in.ExportIncremental,
+		AnomalySeries:            in.AnomalySeries,
+		AnomalyWindow:            in.AnomalyWindow,
+		AnomalyThreshold:         in.AnomalyThreshold,
+		DiffSeries:               in.DiffSeries,
+		DiffTolerance:            in.DiffTolerance,
+		ExplainCostRatio:         in.ExplainCostRatio,
+		ExplainMinCost:           in.ExplainMinCost,
+		BackfillDrop:             in.BackfillDrop,
+		Partition:                in.Partition,
+		SkipSchemaCheck:          in.SkipSchemaCheck,
+		ESIndexSettings:          in.ESIndexSettings,
+		ESBulkRetries:            in.ESBulkRetries,
+		ESRawLookback:            in.ESRawLookback,
+		HistPartialsLag:          in.HistPartialsLag,
 	}
 	return &out
 }
@@ -425,6 +455,36 @@ func TestInit(t *testing.T) {
 		EnableMetricsDrop:        false,
 		RecalcReciprocal:         24,
 		MaxHistograms:            0,
+		CalcMetricExec:           false,
+		UpdateGolden:             false,
+		MetricsBudget:            0,
+		UseProm:                  false,
+		UsePromOnly:              false,
+		PromURL:                  "",
+		PromDir:                  "./prom/",
+		UseInflux:                false,
+		InfluxURL:                "",
+		InfluxDir:                "./influx/",
+		ExportDir:                "./export/",
+		ExportFormat:             "csv",
+		ExportFrom:               time.Time{},
+		ExportTo:                 time.Time{},
+		ExportSeries:             nil,
+		ExportIncremental:        false,
+		AnomalySeries:            nil,
+		AnomalyWindow:            12,
+		AnomalyThreshold:         5.0,
+		DiffSeries:               nil,
+		DiffTolerance:            0.0,
+		ExplainCostRatio:         2.0,
+		ExplainMinCost:           1000.0,
+		BackfillDrop:             false,
+		Partition:                "",
+		SkipSchemaCheck:          false,
+		ESIndexSettings:          `{"number_of_shards":5,"number_of_replicas":0}`,
+		ESBulkRetries:            5,
+		ESRawLookback:            2,
+		HistPartialsLag:          2,
 	}
 
 	var nilRegexp *regexp.Regexp
@@ -1856,6 +1916,206 @@ func TestInit(t *testing.T) {
 				map[string]interface{}{"MaxHistograms": 16},
 			),
 		},
+		{
+			"Setting metrics budget to 8",
+			map[string]string{"GHA2DB_METRICS_BUDGET": "8"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"MetricsBudget": 8},
+			),
+		},
+		{
+			"Setting calc metric exec mode",
+			map[string]string{"GHA2DB_CALC_METRIC_EXEC": "1"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"CalcMetricExec": true},
+			),
+		},
+		{
+			"Setting update golden mode",
+			map[string]string{"GHA2DB_UPDATE_GOLDEN": "y"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"UpdateGolden": true},
+			),
+		},
+		{
+			"Set Prometheus params",
+			map[string]string{
+				"GHA2DB_USE_PROM_ONLY": "1",
+				"GHA2DB_PROM_URL":      "http://prometheus:9090/api/v1/write",
+				"GHA2DB_PROM_DIR":      "/tmp/prom",
+			},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{
+					"UseProm":     true,
+					"UsePromOnly": true,
+					"PromURL":     "http://prometheus:9090/api/v1/write",
+					"PromDir":     "/tmp/prom/",
+				},
+			),
+		},
+		{
+			"Set InfluxDB params",
+			map[string]string{
+				"GHA2DB_USE_INFLUX": "1",
+				"GHA2DB_INFLUX_URL": "http://127.0.0.1:8086/write?db=devstats",
+				"GHA2DB_INFLUX_DIR": "/tmp/influx",
+			},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{
+					"UseInflux": true,
+					"InfluxURL": "http://127.0.0.1:8086/write?db=devstats",
+					"InfluxDir": "/tmp/influx/",
+				},
+			),
+		},
+		{
+			"Set TSDB export params",
+			map[string]string{
+				"GHA2DB_EXPORT_DIR":         "/tmp/export",
+				"GHA2DB_EXPORT_FORMAT":      "parquet",
+				"GHA2DB_EXPORT_FROM":        "2020-01-01",
+				"GHA2DB_EXPORT_TO":          "2021-01-01 12:00:00",
+				"GHA2DB_EXPORT_SERIES":      "^sprs",
+				"GHA2DB_EXPORT_INCREMENTAL": "1",
+			},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{
+					"ExportDir":         "/tmp/export/",
+					"ExportFormat":      "parquet",
+					"ExportFrom":        time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+					"ExportTo":          time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC),
+					"ExportSeries":      regexp.MustCompile("^sprs"),
+					"ExportIncremental": true,
+				},
+			),
+		},
+		{
+			"Set anomaly detection parameters",
+			map[string]string{
+				"GHA2DB_ANOMALY_SERIES":    "^s(prs|issues)",
+				"GHA2DB_ANOMALY_WINDOW":    "24",
+				"GHA2DB_ANOMALY_THRESHOLD": "3.5",
+			},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{
+					"AnomalySeries":    regexp.MustCompile("^s(prs|issues)"),
+					"AnomalyWindow":    24,
+					"AnomalyThreshold": 3.5,
+				},
+			),
+		},
+		{
+			"Set series diff parameters",
+			map[string]string{
+				"GHA2DB_DIFF_SERIES":    "^sprs",
+				"GHA2DB_DIFF_TOLERANCE": "0.01",
+			},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{
+					"DiffSeries":    regexp.MustCompile("^sprs"),
+					"DiffTolerance": 0.01,
+				},
+			),
+		},
+		{
+			"Set explain plans regression thresholds",
+			map[string]string{
+				"GHA2DB_EXPLAIN_COST_RATIO": "1.5",
+				"GHA2DB_EXPLAIN_MIN_COST":   "0",
+			},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{
+					"ExplainCostRatio": 1.5,
+					"ExplainMinCost":   0.0,
+				},
+			),
+		},
+		{
+			"Ignore invalid explain cost ratio",
+			map[string]string{"GHA2DB_EXPLAIN_COST_RATIO": "0.5"},
+			copyContext(&defaultContext),
+		},
+		{
+			"Set backfill drop",
+			map[string]string{"GHA2DB_BACKFILL_DROP": "1"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"BackfillDrop": true},
+			),
+		},
+		{
+			"Set partition interval",
+			map[string]string{"GHA2DB_PARTITION": "q"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"Partition": "q"},
+			),
+		},
+		{
+			"Skip schema check",
+			map[string]string{"GHA2DB_SKIP_SCHEMA_CHECK": "1"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"SkipSchemaCheck": true},
+			),
+		},
+		{
+			"Set ES index settings",
+			map[string]string{"GHA2DB_ES_INDEX_SETTINGS": `{"number_of_shards":1,"number_of_replicas":1}`},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"ESIndexSettings": `{"number_of_shards":1,"number_of_replicas":1}`},
+			),
+		},
+		{
+			"Set ES bulk retries",
+			map[string]string{"GHA2DB_ES_BULK_RETRIES": "0"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"ESBulkRetries": 0},
+			),
+		},
+		{
+			"Set ES raw lookback",
+			map[string]string{"GHA2DB_ES_RAW_LOOKBACK": "7"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"ESRawLookback": 7},
+			),
+		},
+		{
+			"Set mergeable histograms partials lag",
+			map[string]string{"GHA2DB_HIST_PARTIALS_LAG": "0"},
+			dynamicSetFields(
+				t,
+				copyContext(&defaultContext),
+				map[string]interface{}{"HistPartialsLag": 0},
+			),
+		},
 	}
 
 	// Context Init() is verbose when called with CtxDebug
@@ -0,0 +1,264 @@
+package devstatscode
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic"
+)
+
+// ESBulkStats - bulk execution counters
+// Items are all executed delete and add items, each of them ends as Succeeded, NotFound (deletes only) or Failed
+// Retried counts item retries and Requests counts all bulk requests sent (including retries)
+type ESBulkStats struct {
+	Items     int
+	Requests  int
+	Succeeded int
+	NotFound  int
+	Retried   int
+	Failed    int
+}
+
+// ESBulkFailure - bulk item that failed permanently, Doc is the offending bulk request (truncated)
+type ESBulkFailure struct {
+	Status int
+	Type   string
+	Reason string
+	Doc    string
+}
+
+// ESBulkResult - classified results of a single bulk response
+// Retry and Failed contain positions of items in the request
+type ESBulkResult struct {
+	Succeeded int
+	NotFound  int
+	Retry     []int
+	Failed    []int
+	Errors    []ESBulkFailure
+}
+
+// ESBulks keeps array of bulk requests to add/delete
+// each delete/add bulk can hold ESBulkSize items
+type ESBulks struct {
+	add      [][]elastic.BulkableRequest
+	del      [][]elastic.BulkableRequest
+	nBulks   int
+	nItems   int
+	k        int
+	max      int
+	stats    ESBulkStats
+	failures []ESBulkFailure
+}
+
+// Init creates structure to hanle bulk inserts/deletes
+func (b *ESBulks) Init(ec *elastic.Client, ctx *Ctx) {
+	b.add = [][]elastic.BulkableRequest{{}}
+	b.del = [][]elastic.BulkableRequest{{}}
+	b.nBulks = 1
+	b.max = ctx.ESBulkSize
+}
+
+// Add adds delete and add requests of a single document to the current bulks
+func (b *ESBulks) Add(del, add elastic.BulkableRequest) {
+	b.del[b.nBulks-1] = append(b.del[b.nBulks-1], del)
+	b.add[b.nBulks-1] = append(b.add[b.nBulks-1], add)
+}
+
+// Next will increase objects count and possibly switch to another bulk objects
+func (b *ESBulks) Next(ec *elastic.Client) {
+	b.nItems++
+	b.k++
+	if b.k == b.max {
+		b.k = 0
+		b.add = append(b.add, []elastic.BulkableRequest{})
+		b.del = append(b.del, []elastic.BulkableRequest{})
+		b.nBulks++
+	}
+}
+
+// Stats returns counters of all bulks executed so far
+func (b *ESBulks) Stats() ESBulkStats {
+	return b.stats
+}
+
+// Failures returns items that failed permanently (up to 100 are kept)
+func (b *ESBulks) Failures() []ESBulkFailure {
+	return b.failures
+}
+
+// String - output bulks config
+func (b *ESBulks) String() string {
+	return fmt.Sprintf("{nBulks:%d, nItems:%d, k:%d, max:%d, stats:%+v}", b.nBulks, b.nItems, b.k, b.max, b.stats)
+}
+
+// addFailures records permanently failed items
+func (b *ESBulks) addFailures(failures []ESBulkFailure) {
+	b.stats.Failed += len(failures)
+	for _, failure := range failures {
+		Printf("Failed bulk item: status %d, %s: %s, document: %s\n", failure.Status, failure.Type, failure.Reason, failure.Doc)
+		if len(b.failures) < 100 {
+			b.failures = append(b.failures, failure)
+		}
+	}
+}
+
+// ESBulkItemRetryable - checks if bulk item (or whole bulk request) with a given HTTP status can be retried
+// These are rejections because of full queues (429) and temporarily unavailable nodes/shards
+func ESBulkItemRetryable(status int) bool {
+	return status == 429 || status == 502 || status == 503 || status == 504
+}
+
+// ESBulkRequestRetryable - checks if the whole bulk request failed because of a temporary problem
+func ESBulkRequestRetryable(err error) bool {
+	if e, ok := err.(*elastic.Error); ok {
+		return ESBulkItemRetryable(e.Status)
+	}
+	msg := err.Error()
+	for _, temporary := range []string{"transport connection broken", "context deadline exceeded", "connection refused", "connection reset", "EOF", "no available connection"} {
+		if strings.Contains(msg, temporary) {
+			return true
+		}
+	}
+	return false
+}
+
+// ESBulkBackoff - returns time to wait before a given retry (0-based): 500ms doubled each time, up to 30s
+func ESBulkBackoff(attempt int) time.Duration {
+	backoff := 500 * time.Millisecond
+	for i := 0; i < attempt && backoff < 30*time.Second; i++ {
+		backoff *= 2
+	}
+	if backoff > 30*time.Second {
+		backoff = 30 * time.Second
+	}
+	return backoff
+}
+
+// esBulkDoc - returns bulk request description used in failure reports
+func esBulkDoc(req elastic.BulkableRequest) string {
+	return TruncToBytes(req.String(), 0x400)
+}
+
+// ClassifyESBulkResponse - classifies per item results of a bulk response for n requests
+// Deletes of not existing documents are not errors, retryable items are returned in Retry, others in Failed
+func ClassifyESBulkResponse(res *elastic.BulkResponse, n int, del bool) (result ESBulkResult) {
+	if res == nil || len(res.Items) != n {
+		items := 0
+		if res != nil {
+			items = len(res.Items)
+		}
+		for i := 0; i < n; i++ {
+			result.Failed = append(result.Failed, i)
+			result.Errors = append(result.Errors, ESBulkFailure{Type: "invalid_response", Reason: fmt.Sprintf("expected %d items in bulk response, got %d", n, items)})
+		}
+		return
+	}
+	for i, item := range res.Items {
+		var result0 *elastic.BulkResponseItem
+		for _, r := range item {
+			result0 = r
+		}
+		if result0 == nil {
+			result.Failed = append(result.Failed, i)
+			result.Errors = append(result.Errors, ESBulkFailure{Type: "invalid_response", Reason: "empty bulk response item"})
+			continue
+		}
+		status := result0.Status
+		if status >= 200 && status < 300 {
+			result.Succeeded++
+			continue
+		}
+		if del && status == 404 {
+			result.NotFound++
+			continue
+		}
+		if ESBulkItemRetryable(status) {
+			result.Retry = append(result.Retry, i)
+			continue
+		}
+		failure := ESBulkFailure{Status: status}
+		if result0.Error != nil {
+			failure.Type = result0.Error.Type
+			failure.Reason = result0.Error.Reason
+		}
+		result.Failed = append(result.Failed, i)
+		result.Errors = append(result.Errors, failure)
+	}
+	return
+}
+
+// executeBulk executes a single bulk, retrying whole request or its retryable items with backoff
+func (es *ES) executeBulk(ctx *Ctx, b *ESBulks, reqs []elastic.BulkableRequest, del bool) {
+	pending := reqs
+	for attempt := 0; len(pending) > 0; attempt++ {
+		b.stats.Requests++
+		res, err := es.es.Bulk().Add(pending...).Do(es.ctx)
+		if err != nil {
+			if attempt < ctx.ESBulkRetries && ESBulkRequestRetryable(err) {
+				Printf("Bulk request of %d items failed (attempt %d), retrying: %v\n", len(pending), attempt+1, err)
+				b.stats.Retried += len(pending)
+				time.Sleep(ESBulkBackoff(attempt))
+				continue
+			}
+			failures := []ESBulkFailure{}
+			for _, req := range pending {
+				failures = append(failures, ESBulkFailure{Type: "request_failed", Reason: err.Error(), Doc: esBulkDoc(req)})
+			}
+			b.addFailures(failures)
+			return
+		}
+		result := ClassifyESBulkResponse(res, len(pending), del)
+		b.stats.Succeeded += result.Succeeded
+		b.stats.NotFound += result.NotFound
+		for i, pos := range result.Failed {
+			result.Errors[i].Doc = esBulkDoc(pending[pos])
+		}
+		b.addFailures(result.Errors)
+		if len(result.Retry) == 0 {
+			return
+		}
+		retry := []elastic.BulkableRequest{}
+		for _, pos := range result.Retry {
+			retry = append(retry, pending[pos])
+		}
+		if attempt >= ctx.ESBulkRetries {
+			failures := []ESBulkFailure{}
+			for _, req := range retry {
+				failures = append(failures, ESBulkFailure{Type: "retries_exhausted", Reason: fmt.Sprintf("still rejected after %d retries", attempt), Doc: esBulkDoc(req)})
+			}
+			b.addFailures(failures)
+			return
+		}
+		if ctx.Debug > 0 {
+			Printf("Bulk: %d of %d items rejected (attempt %d), retrying\n", len(retry), len(pending), attempt+1)
+		}
+		b.stats.Retried += len(retry)
+		time.Sleep(ESBulkBackoff(attempt))
+		pending = retry
+	}
+}
+
+// ExecuteBulks executes scheduled commands (delete and then inserts)
+// Retryable items are retried, execution fails when any item failed permanently so data is never silently incomplete
+func (es *ES) ExecuteBulks(ctx *Ctx, b *ESBulks) {
+	if ctx.Debug > 0 {
+		Printf("%+v\n", b)
+	}
+	for _, del := range b.del {
+		b.stats.Items += len(del)
+		es.executeBulk(ctx, b, del, true)
+	}
+	for _, add := range b.add {
+		b.stats.Items += len(add)
+		es.executeBulk(ctx, b, add, false)
+	}
+	b.add = [][]elastic.BulkableRequest{{}}
+	b.del = [][]elastic.BulkableRequest{{}}
+	b.nBulks = 1
+	b.nItems = 0
+	b.k = 0
+	if b.stats.Failed > 0 {
+		Fatalf("bulk execution failed: %+v", b.stats)
+	}
+}
@@ -0,0 +1,138 @@
+package devstatscode
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+	"github.com/olivere/elastic"
+)
+
+func TestESBulkItemRetryable(t *testing.T) {
+	var testCases = []struct {
+		status   int
+		expected bool
+	}{
+		{status: 200, expected: false},
+		{status: 201, expected: false},
+		{status: 400, expected: false},
+		{status: 404, expected: false},
+		{status: 409, expected: false},
+		{status: 429, expected: true},
+		{status: 500, expected: false},
+		{status: 502, expected: true},
+		{status: 503, expected: true},
+		{status: 504, expected: true},
+	}
+	for index, test := range testCases {
+		got := lib.ESBulkItemRetryable(test.status)
+		if got != test.expected {
+			t.Errorf("test number %d, expected %v, got %v, test case: %+v", index+1, test.expected, got, test)
+		}
+	}
+}
+
+func TestESBulkBackoff(t *testing.T) {
+	var testCases = []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 0, expected: 500 * time.Millisecond},
+		{attempt: 1, expected: time.Second},
+		{attempt: 2, expected: 2 * time.Second},
+		{attempt: 5, expected: 16 * time.Second},
+		{attempt: 6, expected: 30 * time.Second},
+		{attempt: 100, expected: 30 * time.Second},
+	}
+	for index, test := range testCases {
+		got := lib.ESBulkBackoff(test.attempt)
+		if got != test.expected {
+			t.Errorf("test number %d, expected %v, got %v, test case: %+v", index+1, test.expected, got, test)
+		}
+	}
+}
+
+func TestClassifyESBulkResponse(t *testing.T) {
+	item := func(op string, status int, errType string) map[string]*elastic.BulkResponseItem {
+		res := &elastic.BulkResponseItem{Status: status}
+		if errType != "" {
+			res.Error = &elastic.ErrorDetails{Type: errType, Reason: errType + " reason"}
+		}
+		return map[string]*elastic.BulkResponseItem{op: res}
+	}
+	var testCases = []struct {
+		name     string
+		res      *elastic.BulkResponse
+		n        int
+		del      bool
+		expected lib.ESBulkResult
+	}{
+		{
+			name: "all succeeded",
+			res:  &elastic.BulkResponse{Items: []map[string]*elastic.BulkResponseItem{item("index", 201, ""), item("index", 200, "")}},
+			n:    2,
+			expected: lib.ESBulkResult{
+				Succeeded: 2,
+			},
+		},
+		{
+			name: "delete not found",
+			res:  &elastic.BulkResponse{Items: []map[string]*elastic.BulkResponseItem{item("delete", 404, ""), item("delete", 200, "")}},
+			n:    2,
+			del:  true,
+			expected: lib.ESBulkResult{
+				Succeeded: 1,
+				NotFound:  1,
+			},
+		},
+		{
+			name: "rejected and failed items",
+			res: &elastic.BulkResponse{
+				Items: []map[string]*elastic.BulkResponseItem{
+					item("index", 201, ""),
+					item("index", 429, "es_rejected_execution_exception"),
+					item("index", 400, "mapper_parsing_exception"),
+					item("index", 503, "unavailable_shards_exception"),
+					item("index", 404, "index_not_found_exception"),
+				},
+			},
+			n: 5,
+			expected: lib.ESBulkResult{
+				Succeeded: 1,
+				Retry:     []int{1, 3},
+				Failed:    []int{2, 4},
+				Errors: []lib.ESBulkFailure{
+					{Status: 400, Type: "mapper_parsing_exception", Reason: "mapper_parsing_exception reason"},
+					{Status: 404, Type: "index_not_found_exception", Reason: "index_not_found_exception reason"},
+				},
+			},
+		},
+		{
+			name: "items count mismatch",
+			res:  &elastic.BulkResponse{Items: []map[string]*elastic.BulkResponseItem{item("index", 201, "")}},
+			n:    2,
+			expected: lib.ESBulkResult{
+				Failed: []int{0, 1},
+				Errors: []lib.ESBulkFailure{
+					{Type: "invalid_response", Reason: "expected 2 items in bulk response, got 1"},
+					{Type: "invalid_response", Reason: "expected 2 items in bulk response, got 1"},
+				},
+			},
+		},
+		{
+			name: "no response",
+			n:    1,
+			expected: lib.ESBulkResult{
+				Failed: []int{0},
+				Errors: []lib.ESBulkFailure{{Type: "invalid_response", Reason: "expected 1 items in bulk response, got 0"}},
+			},
+		},
+	}
+	for index, test := range testCases {
+		got := lib.ClassifyESBulkResponse(test.res, test.n, test.del)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test number %d (%s), expected:\n%+v\ngot:\n%+v", index+1, test.name, test.expected, got)
+		}
+	}
+}
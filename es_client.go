@@ -0,0 +1,207 @@
+package devstatscode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic"
+)
+
+// ESVersion - ElasticSearch or OpenSearch server version detected from the root endpoint response
+// Distribution is "elasticsearch" or "opensearch" (ElasticSearch doesn't report it, so it is set to "elasticsearch")
+type ESVersion struct {
+	Distribution string
+	Number       string
+	Major        int
+	Minor        int
+}
+
+// ParseESVersion - parses server's root endpoint ("GET /") JSON response
+func ParseESVersion(data []byte) (ver ESVersion, err error) {
+	var root struct {
+		Version struct {
+			Distribution string `json:"distribution"`
+			Number       string `json:"number"`
+		} `json:"version"`
+	}
+	err = json.Unmarshal(data, &root)
+	if err != nil {
+		return
+	}
+	ver.Distribution = strings.ToLower(root.Version.Distribution)
+	if ver.Distribution == "" {
+		ver.Distribution = "elasticsearch"
+	}
+	ver.Number = root.Version.Number
+	ary := strings.Split(ver.Number, ".")
+	if len(ary) < 2 {
+		err = fmt.Errorf("cannot parse %s version number '%s'", ver.Distribution, ver.Number)
+		return
+	}
+	ver.Major, err = strconv.Atoi(ary[0])
+	if err != nil {
+		return
+	}
+	ver.Minor, err = strconv.Atoi(ary[1])
+	return
+}
+
+// Typeless - returns true if server doesn't support mapping types (ElasticSearch 7+ and all OpenSearch versions)
+func (v ESVersion) Typeless() bool {
+	return v.Distribution == "opensearch" || v.Major >= 7
+}
+
+// String - returns distribution and version number
+func (v ESVersion) String() string {
+	return v.Distribution + " " + v.Number
+}
+
+// ESClient - ElasticSearch/OpenSearch operations used by devstats, independent of the server version
+// Bulk requests are created by the client, so they have the document type set only when server needs it
+type ESClient interface {
+	Version() ESVersion
+	Elastic() *elastic.Client
+	IndexExists(ctx context.Context, index string) (bool, error)
+	CreateIndex(ctx context.Context, index, body string) (bool, error)
+	DeleteByQuery(ctx context.Context, index string, query elastic.Query) (*elastic.BulkIndexByScrollResponse, error)
+	BulkIndexRequest(index, id string, doc interface{}) *elastic.BulkIndexRequest
+	BulkDeleteRequest(index, id string) *elastic.BulkDeleteRequest
+	TemplateVersion(ctx context.Context, name string) (int, bool, error)
+	PutTemplate(ctx context.Context, name, body string) error
+	AliasIndexes(ctx context.Context, alias string) ([]string, error)
+	UpdateAliases(ctx context.Context, body string) error
+}
+
+// esClient - ESClient implementation using ElasticSearch v6 client transport
+// The v6 client works with ElasticSearch 7+ and OpenSearch as long as no mapping types are sent
+type esClient struct {
+	es      *elastic.Client
+	version ESVersion
+}
+
+// NewESClient - connects to ElasticSearch or OpenSearch server and detects its version
+func NewESClient(ctx *Ctx, cctx context.Context) (ESClient, error) {
+	client, err := elastic.NewClient(
+		elastic.SetURL(ctx.ElasticURL),
+		elastic.SetSniff(false),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Ping result of the v6 client doesn't have distribution, so root endpoint response is parsed directly
+	res, err := client.PerformRequest(cctx, elastic.PerformRequestOptions{Method: "GET", Path: "/"})
+	if err != nil {
+		return nil, err
+	}
+	ver, err := ParseESVersion(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Debug > 0 {
+		Printf("ElasticSearch connection code %d and version %s (typeless: %v)\n", res.StatusCode, ver, ver.Typeless())
+	}
+	return &esClient{es: client, version: ver}, nil
+}
+
+// docType - returns document type used in requests, empty for typeless servers
+func (c *esClient) docType() string {
+	if c.version.Typeless() {
+		return ""
+	}
+	return "_doc"
+}
+
+// Version - returns detected server version
+func (c *esClient) Version() ESVersion {
+	return c.version
+}
+
+// Elastic - returns underlying client
+func (c *esClient) Elastic() *elastic.Client {
+	return c.es
+}
+
+// IndexExists checks if index exists
+func (c *esClient) IndexExists(ctx context.Context, index string) (bool, error) {
+	return c.es.IndexExists(index).Do(ctx)
+}
+
+// CreateIndex creates index using given settings and mappings body, returns if creation was acknowledged
+func (c *esClient) CreateIndex(ctx context.Context, index, body string) (bool, error) {
+	res, err := c.es.CreateIndex(index).BodyString(body).Do(ctx)
+	if err != nil {
+		return false, err
+	}
+	return res.Acknowledged, nil
+}
+
+// DeleteByQuery deletes documents matching query from a given index
+func (c *esClient) DeleteByQuery(ctx context.Context, index string, query elastic.Query) (*elastic.BulkIndexByScrollResponse, error) {
+	service := elastic.NewDeleteByQueryService(c.es).Index(index).Query(query)
+	if c.docType() != "" {
+		service = service.Type(c.docType())
+	}
+	return service.Do(ctx)
+}
+
+// BulkIndexRequest - returns bulk index request for a given document
+func (c *esClient) BulkIndexRequest(index, id string, doc interface{}) *elastic.BulkIndexRequest {
+	return elastic.NewBulkIndexRequest().Index(index).Type(c.docType()).Doc(doc).Id(id)
+}
+
+// BulkDeleteRequest - returns bulk delete request for a given document
+func (c *esClient) BulkDeleteRequest(index, id string) *elastic.BulkDeleteRequest {
+	return elastic.NewBulkDeleteRequest().Index(index).Type(c.docType()).Id(id)
+}
+
+// TemplateVersion returns version of (legacy) index template and if it exists, legacy templates are supported by all server versions
+func (c *esClient) TemplateVersion(ctx context.Context, name string) (version int, exists bool, err error) {
+	res, err := c.es.PerformRequest(ctx, elastic.PerformRequestOptions{Method: "GET", Path: "/_template/" + name, IgnoreErrors: []int{404}})
+	if err != nil || res.StatusCode == 404 {
+		return
+	}
+	var templates map[string]struct {
+		Version int `json:"version"`
+	}
+	err = json.Unmarshal(res.Body, &templates)
+	if err != nil {
+		return
+	}
+	template, exists := templates[name]
+	version = template.Version
+	return
+}
+
+// PutTemplate creates or replaces (legacy) index template
+func (c *esClient) PutTemplate(ctx context.Context, name, body string) error {
+	_, err := c.es.PerformRequest(ctx, elastic.PerformRequestOptions{Method: "PUT", Path: "/_template/" + name, Body: body})
+	return err
+}
+
+// AliasIndexes returns names of indexes pointed by a given alias, empty when alias doesn't exist
+func (c *esClient) AliasIndexes(ctx context.Context, alias string) (indexes []string, err error) {
+	res, err := c.es.PerformRequest(ctx, elastic.PerformRequestOptions{Method: "GET", Path: "/_alias/" + alias, IgnoreErrors: []int{404}})
+	if err != nil || res.StatusCode == 404 {
+		return
+	}
+	var aliases map[string]json.RawMessage
+	err = json.Unmarshal(res.Body, &aliases)
+	if err != nil {
+		return
+	}
+	for index := range aliases {
+		indexes = append(indexes, index)
+	}
+	sort.Strings(indexes)
+	return
+}
+
+// UpdateAliases executes alias actions atomically
+func (c *esClient) UpdateAliases(ctx context.Context, body string) error {
+	_, err := c.es.PerformRequest(ctx, elastic.PerformRequestOptions{Method: "POST", Path: "/_aliases", Body: body})
+	return err
+}
@@ -0,0 +1,40 @@
+package devstatscode
+
+import (
+	"testing"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestParseESVersion(t *testing.T) {
+	var testCases = []struct {
+		body         string
+		distribution string
+		major        int
+		minor        int
+		typeless     bool
+		hasError     bool
+	}{
+		{body: `{"name":"n","version":{"number":"6.8.23"}}`, distribution: "elasticsearch", major: 6, minor: 8},
+		{body: `{"version":{"number":"7.17.9","build_flavor":"default"}}`, distribution: "elasticsearch", major: 7, minor: 17, typeless: true},
+		{body: `{"version":{"number":"8.11.1"}}`, distribution: "elasticsearch", major: 8, minor: 11, typeless: true},
+		{body: `{"version":{"distribution":"opensearch","number":"2.11.0"}}`, distribution: "opensearch", major: 2, minor: 11, typeless: true},
+		{body: `{"version":{"distribution":"opensearch","number":"1.3.0"}}`, distribution: "opensearch", major: 1, minor: 3, typeless: true},
+		{body: `{"version":{"number":"x"}}`, hasError: true},
+		{body: `{"version":{"number":"a.b"}}`, hasError: true},
+		{body: `[]`, hasError: true},
+	}
+	for index, test := range testCases {
+		ver, err := lib.ParseESVersion([]byte(test.body))
+		if (err != nil) != test.hasError {
+			t.Errorf("test number %d, expected error: %v, got: %v", index+1, test.hasError, err)
+			continue
+		}
+		if test.hasError {
+			continue
+		}
+		if ver.Distribution != test.distribution || ver.Major != test.major || ver.Minor != test.minor || ver.Typeless() != test.typeless {
+			t.Errorf("test number %d, unexpected version %+v (typeless: %v)", index+1, ver, ver.Typeless())
+		}
+	}
+}
@@ -2,7 +2,6 @@ package devstatscode
 
 import (
 	"context"
-	"fmt"
 	"strings"
 	"time"
 
@@ -10,12 +9,17 @@ import (
 )
 
 // ES - ElasticSearch connection client, context and default mapping
+// Indexes are versioned ("d_kubernetes_20200301120000") and used via alias named "prefix+project" ("d_kubernetes")
+// writeIndex is set while a new index is being built by a full reindex, alias is swapped to it when finished
 type ES struct {
 	ctx           context.Context
 	es            *elastic.Client
-	mapping       string
-	mappingRaw    string
+	client        ESClient
+	settings      string
+	properties    string
+	propertiesRaw string
 	prefix        string
+	writeIndex    string
 	fieldsToMerge map[string]string
 }
 
@@ -29,76 +33,16 @@ type ESDataObject struct {
 	DtValue time.Time `json:"dtvalue"`
 }
 
-// ESBulks keeps array of bulk services to add/delete
-// each delete/add but can hold 10 items
-type ESBulks struct {
-	add     []*elastic.BulkService
-	del     []*elastic.BulkService
-	currAdd *elastic.BulkService
-	currDel *elastic.BulkService
-	nBulks  int
-	nItems  int
-	k       int
-	max     int
-}
-
-// Init creates structure to hanle bulk inserts/deletes
-func (b *ESBulks) Init(ec *elastic.Client, ctx *Ctx) {
-	b.add = append(b.add, ec.Bulk())
-	b.del = append(b.del, ec.Bulk())
-	b.currAdd = b.add[0]
-	b.currDel = b.del[0]
-	b.nBulks = 1
-	b.max = ctx.ESBulkSize
-}
-
-// CurrentDel returns current bulk delete object
-func (b *ESBulks) CurrentDel() *elastic.BulkService {
-	return b.currDel
-}
-
-// CurrentAdd returns current bulk add object
-func (b *ESBulks) CurrentAdd() *elastic.BulkService {
-	return b.currAdd
-}
-
-// Next will increase objects count and possibly switch to another bulk objects
-func (b *ESBulks) Next(ec *elastic.Client) {
-	b.nItems++
-	b.k++
-	if b.k == b.max {
-		b.k = 0
-		b.add = append(b.add, ec.Bulk())
-		b.del = append(b.del, ec.Bulk())
-		b.currAdd = b.add[b.nBulks]
-		b.currDel = b.del[b.nBulks]
-		b.nBulks++
-	}
-}
-
-// String - output bulks config
-func (b *ESBulks) String() string {
-	return fmt.Sprintf("{nBulks:%d, nItems:%d, k:%d, max:%d}", b.nBulks, b.nItems, b.k, b.max)
-}
-
 // ESConn Connects to ElasticSearch
+// Server version is detected on connect, ElasticSearch 7+ and OpenSearch get typeless mappings and requests
 func ESConn(ctx *Ctx, prefix string) *ES {
 	ctxb := context.Background()
 	if ctx.QOut {
 		Printf("ESConnectString: %s\n", ctx.ElasticURL)
 	}
 	// TODO: set sniff enable/disable via context var?
-	client, err := elastic.NewClient(
-		elastic.SetURL(ctx.ElasticURL),
-		elastic.SetSniff(false),
-		//elastic.SetScheme("https"),
-	)
+	client, err := NewESClient(ctx, ctxb)
 	FatalOnError(err)
-	info, code, err := client.Ping(ctx.ElasticURL).Do(ctxb)
-	FatalOnError(err)
-	if ctx.Debug > 0 {
-		Printf("ElasticSearch connection code %d and version %s\n", code, info.Version.Number)
-	}
 	fieldsToMerge := map[string]string{
 		"name":  "svalue",
 		"value": "ivalue",
@@ -108,16 +52,12 @@ func ESConn(ctx *Ctx, prefix string) *ES {
 	}
 	return &ES{
 		ctx:           ctxb,
-		es:            client,
+		es:            client.Elastic(),
+		client:        client,
 		prefix:        prefix,
 		fieldsToMerge: fieldsToMerge,
-		mapping: `{"settings":{"number_of_shards":5,"number_of_replicas":0},` +
-			`"mappings":{"_doc":{` +
-			`"dynamic_templates":[` +
-			`{"not_analyzerd":{"match":"*","match_mapping_type":"string","mapping":{"type":"keyword"}}},` +
-			`{"numbers":{"match":"*","match_mapping_type":"long","mapping":{"type":"float"}}}` +
-			`],"properties":{` +
-			`"type":{"type":"keyword"},` +
+		settings:      ctx.ESIndexSettings,
+		properties: `"type":{"type":"keyword"},` +
 			`"time":{"type":"date","format":"yyyy-MM-dd HH:mm:ss"},` +
 			`"series":{"type":"keyword"},` +
 			`"period":{"type":"keyword"},` +
@@ -134,26 +74,27 @@ func ESConn(ctx *Ctx, prefix string) *ES {
 			`"data.svalue3":{"type":"keyword"},` +
 			`"data.ivalue":{"type":"double"},` +
 			`"data.dtvalue":{"type":"date","format":"yyyy-MM-dd HH:mm:ss"},` +
-			`"value":{"type":"double"}` +
-			`}}}}`,
-		mappingRaw: `{"settings":{"number_of_shards":5,"number_of_replicas":0},` +
-			`"mappings":{"_doc":{` +
-			`"dynamic_templates":[` +
-			`{"not_analyzerd":{"match":"*","match_mapping_type":"string","mapping":{"type":"keyword"}}},` +
-			`{"numbers":{"match":"*","match_mapping_type":"long","mapping":{"type":"float"}}}` +
-			`],"properties":{` +
-			`"type":{"type":"keyword"},` +
+			`"value":{"type":"double"}`,
+		propertiesRaw: `"type":{"type":"keyword"},` +
 			// `"message":{"type":"text"},` +
 			// `"title":{"type":"text"},` +
 			// `"body":{"type":"text"},` +
 			`"full_body":{"type":"text"},` +
-			`"time":{"type":"date","format":"yyyy-MM-dd HH:mm:ss"}` +
-			`}}}}`,
+			`"time":{"type":"date","format":"yyyy-MM-dd HH:mm:ss"}`,
 	}
 }
 
 // ESIndexName returns ES index name "d_{{project}}" --> "d_kubernetes"
+// This is an alias (or a legacy index), while reindexing it returns the new index being built
 func (es *ES) ESIndexName(ctx *Ctx) string {
+	if es.writeIndex != "" {
+		return es.writeIndex
+	}
+	return es.ESAliasName(ctx)
+}
+
+// ESAliasName returns ES alias name used to read and write project's data "d_{{project}}" --> "d_kubernetes"
+func (es *ES) ESAliasName(ctx *Ctx) string {
 	if ctx.Project == "" {
 		Fatalf("you need to specify project via GHA2DB_PROJECT=...")
 	}
@@ -167,30 +108,90 @@ func (es *ES) ESEscapeFieldName(fieldName string) string {
 
 // IndexExists checks if index exists
 func (es *ES) IndexExists(ctx *Ctx) bool {
-	exists, err := es.es.IndexExists(es.ESIndexName(ctx)).Do(es.ctx)
+	exists, err := es.client.IndexExists(es.ctx, es.ESIndexName(ctx))
 	FatalOnError(err)
 	return exists
 }
 
-// CreateIndex creates index
-func (es *ES) CreateIndex(ctx *Ctx, raw bool) {
-	var mapping string
+// mappingProperties - returns properties mapping of data or raw data indexes
+func (es *ES) mappingProperties(raw bool) string {
 	if raw {
-		mapping = es.mappingRaw
-	} else {
-		mapping = es.mapping
+		return es.propertiesRaw
+	}
+	return es.properties
+}
+
+// ensureTemplate installs index template for versioned indexes behind project's alias
+// Template is used when an index is created implicitly, it is replaced when settings or mappings changed
+func (es *ES) ensureTemplate(ctx *Ctx, raw bool) {
+	alias := es.ESAliasName(ctx)
+	name := ESTemplateName(alias)
+	typeless := es.client.Version().Typeless()
+	body, version := ESTemplateBody(ESTemplatePattern(alias), len(es.prefix), es.settings, es.mappingProperties(raw), typeless)
+	current, exists, err := es.client.TemplateVersion(es.ctx, name)
+	FatalOnError(err)
+	if exists && current == version {
+		return
+	}
+	FatalOnError(es.client.PutTemplate(es.ctx, name, body))
+	if ctx.Debug > 0 {
+		Printf("CreateIndex: installed %s index template version %d (replaced: %v)\n", name, version, exists)
+	}
+}
+
+// createVersionedIndex creates a new versioned index, optionally as a write index of alias
+func (es *ES) createVersionedIndex(ctx *Ctx, raw bool, alias string) (string, error) {
+	es.ensureTemplate(ctx, raw)
+	index := ESVersionedIndex(es.ESAliasName(ctx), time.Now())
+	body := ESIndexBody(es.settings, es.mappingProperties(raw), es.client.Version().Typeless(), alias)
+	acknowledged, err := es.client.CreateIndex(es.ctx, index, body)
+	if err != nil {
+		return index, err
+	}
+	if !acknowledged {
+		Fatalf("index %s not created", index)
 	}
-	createIndex, err := es.es.CreateIndex(es.ESIndexName(ctx)).BodyString(mapping).Do(es.ctx)
-	if err != nil && strings.Contains(err.Error(), "already exists") {
+	return index, nil
+}
+
+// CreateIndex creates a new versioned index behind project's alias
+func (es *ES) CreateIndex(ctx *Ctx, raw bool) {
+	index, err := es.createVersionedIndex(ctx, raw, es.ESAliasName(ctx))
+	// Another process could create an index behind this alias in the meantime
+	if err != nil && (strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "more than one write index")) && es.IndexExists(ctx) {
 		if ctx.Debug > 0 {
-			Printf("CreateIndex: %s index already exists: %+v\n", es.ESIndexName(ctx), err)
+			Printf("CreateIndex: %s index already exists: %+v\n", es.ESAliasName(ctx), err)
 		}
 		return
 	}
 	FatalOnError(err)
-	if !createIndex.Acknowledged {
-		Fatalf("index " + es.ESIndexName(ctx) + " not created")
+	if ctx.Debug > 0 {
+		Printf("CreateIndex: created %s index with %s alias\n", index, es.ESAliasName(ctx))
+	}
+}
+
+// StartReindex creates a new empty versioned index, all writes go to it until FinishReindex is called
+// Data behind the alias is still available for queries while the new index is built
+func (es *ES) StartReindex(ctx *Ctx, raw bool) {
+	index, err := es.createVersionedIndex(ctx, raw, "")
+	FatalOnError(err)
+	es.writeIndex = index
+	Printf("Reindexing %s into a new %s index\n", es.ESAliasName(ctx), index)
+}
+
+// FinishReindex atomically swaps project's alias to the index built since StartReindex and removes old indexes
+func (es *ES) FinishReindex(ctx *Ctx) {
+	if es.writeIndex == "" {
+		Fatalf("FinishReindex called without StartReindex")
 	}
+	alias := es.ESAliasName(ctx)
+	oldIndexes, err := es.client.AliasIndexes(es.ctx, alias)
+	FatalOnError(err)
+	// Index without alias named as the alias is a legacy (not versioned) index
+	legacy := len(oldIndexes) == 0 && es.IndexExists(ctx)
+	FatalOnError(es.client.UpdateAliases(es.ctx, ESSwapAliasBody(alias, es.writeIndex, oldIndexes, legacy)))
+	Printf("Swapped %s alias to %s index (removed: %v, legacy: %v)\n", alias, es.writeIndex, oldIndexes, legacy)
+	es.writeIndex = ""
 }
 
 // DeleteByQuery deletes data from given index & type by simple bool query
@@ -201,7 +202,7 @@ func (es *ES) DeleteByQuery(ctx *Ctx, propNames []string, propValues []interface
 	}
 	ne := 0
 	for {
-		result, err := elastic.NewDeleteByQueryService(es.es).Index(es.ESIndexName(ctx)).Type("_doc").Query(boolQuery).Do(es.ctx)
+		result, err := es.client.DeleteByQuery(es.ctx, es.ESIndexName(ctx), boolQuery)
 		if err != nil && strings.Contains(err.Error(), "search_phase_execution_exception") {
 			if ctx.Debug > 0 {
 				Printf("DeleteByQuery: %s index not yet ready for delete (so it doesn't have data for delete anyway): %+v\n", es.ESIndexName(ctx), err)
@@ -226,7 +227,7 @@ func (es *ES) DeleteByWildcardQuery(ctx *Ctx, propName, propQuery string) {
 	wildcardQuery := elastic.NewWildcardQuery(propName, propQuery)
 	ne := 0
 	for {
-		result, err := elastic.NewDeleteByQueryService(es.es).Index(es.ESIndexName(ctx)).Type("_doc").Query(wildcardQuery).Do(es.ctx)
+		result, err := es.client.DeleteByQuery(es.ctx, es.ESIndexName(ctx), wildcardQuery)
 		if err != nil && strings.Contains(err.Error(), "search_phase_execution_exception") {
 			if ctx.Debug > 0 {
 				Printf("DeleteByWildcardQuery: %s index not yet ready for delete (so it doesn't have data for delete anyway): %+v\n", es.ESIndexName(ctx), err)
@@ -251,6 +252,11 @@ func (es *ES) GetElasticClient() *elastic.Client {
 	return es.es
 }
 
+// GetESClient - returns version aware ES client
+func (es *ES) GetESClient() ESClient {
+	return es.client
+}
+
 // Bulks returns Delete and Add requests
 func (es *ES) Bulks() (*elastic.BulkService, *elastic.BulkService) {
 	return es.es.Bulk(), es.es.Bulk()
@@ -259,109 +265,16 @@ func (es *ES) Bulks() (*elastic.BulkService, *elastic.BulkService) {
 // AddBulksItems adds items to the Bulk Request
 func (es *ES) AddBulksItems(ctx *Ctx, b *ESBulks, doc map[string]interface{}, keys []string) {
 	docHash := HashObject(doc, keys)
-	b.CurrentDel().Add(elastic.NewBulkDeleteRequest().Index(es.ESIndexName(ctx)).Type("_doc").Id(docHash))
-	b.CurrentAdd().Add(elastic.NewBulkIndexRequest().Index(es.ESIndexName(ctx)).Type("_doc").Doc(doc).Id(docHash))
+	b.Add(es.client.BulkDeleteRequest(es.ESIndexName(ctx), docHash), es.client.BulkIndexRequest(es.ESIndexName(ctx), docHash, doc))
 	b.Next(es.es)
 }
 
 // AddBulksItemsI adds items to the Bulk Request
 func (es *ES) AddBulksItemsI(ctx *Ctx, b *ESBulks, doc interface{}, docHash string) {
-	b.CurrentDel().Add(elastic.NewBulkDeleteRequest().Index(es.ESIndexName(ctx)).Type("_doc").Id(docHash))
-	b.CurrentAdd().Add(elastic.NewBulkIndexRequest().Index(es.ESIndexName(ctx)).Type("_doc").Doc(doc).Id(docHash))
+	b.Add(es.client.BulkDeleteRequest(es.ESIndexName(ctx), docHash), es.client.BulkIndexRequest(es.ESIndexName(ctx), docHash, doc))
 	b.Next(es.es)
 }
 
-// ExecuteBulkDel executes scheduled commands (delete and then inserts)
-func (es *ES) ExecuteBulkDel(ctx *Ctx, bulkDel *elastic.BulkService) {
-	res, err := bulkDel.Do(es.ctx)
-	if err != nil && strings.Contains(err.Error(), "No bulk actions to commit") {
-		if ctx.Debug > 0 {
-			Printf("ExecuteBulkDel: no actions to commit\n")
-		}
-	} else {
-		actions := bulkDel.NumberOfActions()
-		if actions != 0 {
-			Printf("bulk delete: not all actions executed: %+v\n", actions)
-			if err == nil {
-				err = fmt.Errorf("bulk delete: not all actions executed: %+v", actions)
-			}
-		}
-		failedResults := res.Failed()
-		nFailed := len(failedResults)
-		if len(failedResults) > 0 {
-			for _, failed := range failedResults {
-				if strings.Contains(failed.Result, "not_found") {
-					nFailed--
-				} else {
-					Printf("Failed delete: %+v: %+v\n", failed, failed.Error)
-				}
-			}
-			if nFailed > 0 {
-				Printf("bulk delete failed: %+v\n", failedResults)
-				if err == nil {
-					err = fmt.Errorf("bulk delete failed: %+v", failedResults)
-				}
-			}
-		}
-		FatalOnError(err)
-	}
-}
-
-// ExecuteBulkAdd executes scheduled commands (delete and then inserts)
-func (es *ES) ExecuteBulkAdd(ctx *Ctx, bulkAdd *elastic.BulkService) {
-	res, err := bulkAdd.Do(es.ctx)
-	if err != nil && strings.Contains(err.Error(), "No bulk actions to commit") {
-		if ctx.Debug > 0 {
-			Printf("ExecuteBulkAdd: no actions to commit\n")
-		}
-	} else if err != nil && strings.Contains(err.Error(), "transport connection broken") {
-		Printf("ERROR: ExecuteBulkAdd: transport connection broken, skipping: %+v\n", err)
-		time.Sleep(2000 * time.Millisecond)
-	} else if err != nil && strings.Contains(err.Error(), "context deadline exceeded") {
-		Printf("ERROR: ExecuteBulkAdd: context deadline exceeded, skipping: %+v\n", err)
-		time.Sleep(2000 * time.Millisecond)
-	} else {
-		actions := bulkAdd.NumberOfActions()
-		if actions != 0 {
-			Printf("bulk add not all actions executed: %+v\n", actions)
-			if err == nil {
-				err = fmt.Errorf("bulk add not all actions executed: %+v", actions)
-			}
-		}
-		failedResults := res.Failed()
-		if len(failedResults) > 0 {
-			for _, failed := range failedResults {
-				Printf("Failed add: %+v: %+v\n", failed, failed.Error)
-			}
-			Printf("bulk failed add: %+v\n", failedResults)
-			if err == nil {
-				err = fmt.Errorf("bulk failed add: %+v", failedResults)
-			}
-		}
-		FatalOnError(err)
-	}
-}
-
-// ExecuteBulks executes scheduled commands (delete and then inserts)
-func (es *ES) ExecuteBulks(ctx *Ctx, b *ESBulks) {
-	if ctx.Debug > 0 {
-		Printf("%+v\n", b)
-	}
-	for _, del := range b.del {
-		es.ExecuteBulkDel(ctx, del)
-	}
-	for _, add := range b.add {
-		es.ExecuteBulkAdd(ctx, add)
-	}
-	b.add = []*elastic.BulkService{}
-	b.del = []*elastic.BulkService{}
-	b.currAdd = nil
-	b.currDel = nil
-	b.nBulks = 0
-	b.nItems = 0
-	b.k = 0
-}
-
 // WriteESPoints write batch of points to postgresql
 // outputs[0] - output using variable column name (1 doc) [used by annotations, tags and vars]
 // outputs[1] - output using data[] array containing {name,ivalue,svalue,svalue2,svalue3,dtvalue} (1 doc), any of those keys is optional [not used currently]
@@ -0,0 +1,99 @@
+package devstatscode
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// ESMappings - returns index mappings with given properties (without surrounding braces)
+// Typed mappings (with the "_doc" type wrapper) are used for servers that still require mapping types
+func ESMappings(properties string, typeless bool) string {
+	mappings := `{` +
+		`"dynamic_templates":[` +
+		`{"not_analyzerd":{"match":"*","match_mapping_type":"string","mapping":{"type":"keyword"}}},` +
+		`{"numbers":{"match":"*","match_mapping_type":"long","mapping":{"type":"float"}}}` +
+		`],"properties":{` + properties + `}}`
+	if !typeless {
+		mappings = `{"_doc":` + mappings + `}`
+	}
+	return mappings
+}
+
+// ESIndexBody - returns index creation body, index becomes a write index of alias when alias is not empty
+func ESIndexBody(settings, properties string, typeless bool, alias string) string {
+	body := `{"settings":` + settings + `,"mappings":` + ESMappings(properties, typeless)
+	if alias != "" {
+		body += `,"aliases":{` + esQuote(alias) + `:{"is_write_index":true}}`
+	}
+	return body + `}`
+}
+
+// ESTemplateBody - returns (legacy) index template body applied to indexes matching pattern and its version
+// Version is a hash of the template contents, so a template can be replaced when settings or mappings change
+// Templates with higher order override settings and mappings of templates with lower order
+func ESTemplateBody(pattern string, order int, settings, properties string, typeless bool) (string, int) {
+	body := fmt.Sprintf(
+		`"index_patterns":[%s],"order":%d,"settings":%s,"mappings":%s`,
+		esQuote(pattern),
+		order,
+		settings,
+		ESMappings(properties, typeless),
+	)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(body))
+	version := int(h.Sum32() & 0x7fffffff)
+	return fmt.Sprintf(`{"version":%d,%s}`, version, body), version
+}
+
+// ESTemplatePattern - returns index pattern of versioned indexes behind alias: "d_kubernetes" --> "d_kubernetes_*"
+// Patterns of data ("d_") and raw data ("d_raw_") indexes don't overlap
+func ESTemplatePattern(alias string) string {
+	return alias + "_*"
+}
+
+// ESTemplateName - returns index template name for a given alias: "d_raw_kubernetes" --> "devstats_d_raw_kubernetes"
+func ESTemplateName(alias string) string {
+	return "devstats_" + alias
+}
+
+// ESVersionedIndex - returns name of a new versioned index behind alias: "d_kubernetes" --> "d_kubernetes_20200301120000"
+func ESVersionedIndex(alias string, dt time.Time) string {
+	return alias + "_" + dt.UTC().Format("20060102150405")
+}
+
+// ESSwapAliasBody - returns alias actions making alias point to newIndex only, executed atomically
+// Old indexes are removed in the same request, legacy is a concrete index named as the alias (created before aliases were used)
+func ESSwapAliasBody(alias, newIndex string, oldIndexes []string, legacy bool) string {
+	actions := []string{}
+	if legacy {
+		actions = append(actions, `{"remove_index":{"index":`+esQuote(alias)+`}}`)
+	}
+	for _, index := range oldIndexes {
+		if index == newIndex {
+			continue
+		}
+		actions = append(actions, `{"remove_index":{"index":`+esQuote(index)+`}}`)
+	}
+	actions = append(actions, `{"add":{"index":`+esQuote(newIndex)+`,"alias":`+esQuote(alias)+`,"is_write_index":true}}`)
+	return `{"actions":[` + strings.Join(actions, ",") + `]}`
+}
+
+// CheckESIndexSettings - checks if index settings are a valid JSON object
+func CheckESIndexSettings(settings string) error {
+	var obj map[string]interface{}
+	err := json.Unmarshal([]byte(settings), &obj)
+	if err != nil {
+		return fmt.Errorf("ES index settings must be a JSON object: %v", err)
+	}
+	return nil
+}
+
+// esQuote - returns JSON string literal
+func esQuote(s string) string {
+	data, err := json.Marshal(s)
+	FatalOnError(err)
+	return string(data)
+}
@@ -0,0 +1,124 @@
+package devstatscode
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestESIndexBody(t *testing.T) {
+	settings := `{"number_of_shards":1}`
+	properties := `"type":{"type":"keyword"}`
+	var testCases = []struct {
+		typeless bool
+		alias    string
+		mappings []string
+		aliases  []string
+	}{
+		{typeless: false, mappings: []string{"_doc"}},
+		{typeless: true, mappings: []string{"dynamic_templates", "properties"}},
+		{typeless: true, alias: "d_kubernetes", mappings: []string{"dynamic_templates", "properties"}, aliases: []string{"d_kubernetes"}},
+	}
+	for index, test := range testCases {
+		var body struct {
+			Settings map[string]interface{}     `json:"settings"`
+			Mappings map[string]json.RawMessage `json:"mappings"`
+			Aliases  map[string]struct {
+				IsWriteIndex bool `json:"is_write_index"`
+			} `json:"aliases"`
+		}
+		err := json.Unmarshal([]byte(lib.ESIndexBody(settings, properties, test.typeless, test.alias)), &body)
+		if err != nil {
+			t.Fatalf("test number %d, invalid index body JSON: %v", index+1, err)
+		}
+		mappings := []string{}
+		for key := range body.Mappings {
+			mappings = append(mappings, key)
+		}
+		sort.Strings(mappings)
+		aliases := []string{}
+		for key, alias := range body.Aliases {
+			if alias.IsWriteIndex {
+				aliases = append(aliases, key)
+			}
+		}
+		if len(test.aliases) == 0 {
+			test.aliases = []string{}
+		}
+		if !reflect.DeepEqual(mappings, test.mappings) || !reflect.DeepEqual(aliases, test.aliases) || body.Settings["number_of_shards"] != 1.0 {
+			t.Errorf("test number %d, expected mappings %v and write aliases %v, got %v and %v", index+1, test.mappings, test.aliases, mappings, aliases)
+		}
+	}
+}
+
+func TestESTemplateBody(t *testing.T) {
+	var body struct {
+		Version       int                    `json:"version"`
+		IndexPatterns []string               `json:"index_patterns"`
+		Order         int                    `json:"order"`
+		Settings      map[string]interface{} `json:"settings"`
+		Mappings      map[string]interface{} `json:"mappings"`
+	}
+	data, version := lib.ESTemplateBody("d_raw_kubernetes_*", 6, `{"number_of_shards":1}`, `"type":{"type":"keyword"}`, true)
+	err := json.Unmarshal([]byte(data), &body)
+	if err != nil {
+		t.Fatalf("invalid template JSON: %v", err)
+	}
+	if !reflect.DeepEqual(body.IndexPatterns, []string{"d_raw_kubernetes_*"}) || body.Order != 6 || body.Mappings["properties"] == nil || body.Version != version {
+		t.Errorf("unexpected template: %+v", body)
+	}
+	_, version2 := lib.ESTemplateBody("d_raw_kubernetes_*", 6, `{"number_of_shards":2}`, `"type":{"type":"keyword"}`, true)
+	if version == version2 {
+		t.Errorf("expected template version to change with settings, got %d", version)
+	}
+	if got := lib.ESTemplateName("d_raw_kubernetes"); got != "devstats_d_raw_kubernetes" {
+		t.Errorf("unexpected template name: %s", got)
+	}
+	// Data indexes pattern must not match raw data indexes
+	if pattern := lib.ESTemplatePattern("d_kubernetes"); strings.HasPrefix("d_raw_kubernetes_20200301120507", strings.TrimSuffix(pattern, "*")) {
+		t.Errorf("data indexes pattern %s matches raw data indexes", pattern)
+	}
+	if got := lib.ESVersionedIndex("d_kubernetes", time.Date(2020, 3, 1, 12, 5, 7, 0, time.UTC)); got != "d_kubernetes_20200301120507" {
+		t.Errorf("unexpected versioned index name: %s", got)
+	}
+}
+
+func TestESSwapAliasBody(t *testing.T) {
+	var testCases = []struct {
+		oldIndexes []string
+		legacy     bool
+		expected   string
+	}{
+		{
+			expected: `{"actions":[{"add":{"index":"d_k_2","alias":"d_k","is_write_index":true}}]}`,
+		},
+		{
+			oldIndexes: []string{"d_k_1", "d_k_2"},
+			expected:   `{"actions":[{"remove_index":{"index":"d_k_1"}},{"add":{"index":"d_k_2","alias":"d_k","is_write_index":true}}]}`,
+		},
+		{
+			legacy:   true,
+			expected: `{"actions":[{"remove_index":{"index":"d_k"}},{"add":{"index":"d_k_2","alias":"d_k","is_write_index":true}}]}`,
+		},
+	}
+	for index, test := range testCases {
+		got := lib.ESSwapAliasBody("d_k", "d_k_2", test.oldIndexes, test.legacy)
+		if got != test.expected {
+			t.Errorf("test number %d, expected:\n%s\ngot:\n%s", index+1, test.expected, got)
+		}
+	}
+}
+
+func TestCheckESIndexSettings(t *testing.T) {
+	if err := lib.CheckESIndexSettings(`{"number_of_shards":1}`); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if lib.CheckESIndexSettings(`[1]`) == nil || lib.CheckESIndexSettings(`{`) == nil {
+		t.Errorf("expected errors for invalid settings")
+	}
+}
@@ -0,0 +1,184 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// ESRawFingerprint - order independent fingerprint of documents generated for a single day of a raw ES document type
+// When a day's fingerprint differs from the stored one, some rows arrived late or were updated (enriched) after indexing
+type ESRawFingerprint struct {
+	Rows int
+	sum  uint64
+}
+
+// ESRawDay - stored fingerprint of a single day of a raw ES document type
+type ESRawDay struct {
+	DocType string
+	Day     time.Time
+	Rows    int
+	Digest  string
+}
+
+// ESRawDocTypes - returns raw ES document types, each has its own SQL in util_sql/es_raw_*.sql and its own checkpoint
+func ESRawDocTypes() []string {
+	return []string{"commits", "issues", "prs", "texts"}
+}
+
+// Add adds document to the fingerprint
+func (f *ESRawFingerprint) Add(doc interface{}) {
+	data, err := json.Marshal(doc)
+	FatalOnError(err)
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	f.sum += h.Sum64()
+	f.Rows++
+}
+
+// Digest returns fingerprint's digest string
+func (f *ESRawFingerprint) Digest() string {
+	return strconv.FormatUint(f.sum, 36)
+}
+
+// Same checks if fingerprint matches stored day
+func (f *ESRawFingerprint) Same(day ESRawDay) bool {
+	return f.Rows == day.Rows && f.Digest() == day.Digest
+}
+
+// ESRawStarts - returns day from which incremental raw ES indexing starts for each document type
+// Each type resumes from its own checkpoint going `lookback` days back, so late arriving rows of already indexed days
+// are detected, explicitly requested earlier `from` day always wins (for example when refilling a gap)
+func ESRawStarts(from time.Time, checkpoints map[string]time.Time, lookback int) map[string]time.Time {
+	starts := make(map[string]time.Time)
+	for _, docType := range ESRawDocTypes() {
+		start := DayStart(from)
+		if checkpoint, ok := checkpoints[docType]; ok {
+			if resume := DayStart(checkpoint).AddDate(0, 0, -lookback); resume.Before(start) {
+				start = resume
+			}
+		}
+		starts[docType] = start
+	}
+	return starts
+}
+
+// ESRawStart - returns earliest day from which any document type needs incremental raw ES indexing
+func ESRawStart(starts map[string]time.Time) time.Time {
+	var start time.Time
+	for _, docType := range ESRawDocTypes() {
+		dt, ok := starts[docType]
+		if ok && (start.IsZero() || dt.Before(start)) {
+			start = dt
+		}
+	}
+	return start
+}
+
+// ESRawIndexed - returns true when raw ES document type needs indexing on a given day (incremental mode)
+func ESRawIndexed(starts map[string]time.Time, docType string, day time.Time) bool {
+	start, ok := starts[docType]
+	return !ok || !day.Before(start)
+}
+
+// ESRawMaxes - merges max indexed row timestamps per raw ES document type into `maxes`
+func ESRawMaxes(maxes, other map[string]time.Time) {
+	for docType, dt := range other {
+		if prev, ok := maxes[docType]; !ok || dt.After(prev) {
+			maxes[docType] = dt
+		}
+	}
+}
+
+// esRawCheckpointsTableDef - last indexed timestamp per raw ES document type table definition
+func esRawCheckpointsTableDef() string {
+	return "gha_es_raw_checkpoints(" +
+		"doc_type text not null, " +
+		"dt {{ts}} not null, " +
+		"updated_at {{tsnow}} not null, " +
+		"primary key(doc_type)" +
+		")"
+}
+
+// esRawDaysTableDef - raw ES document type days fingerprints table definition
+func esRawDaysTableDef() string {
+	return "gha_es_raw_days(" +
+		"doc_type text not null, " +
+		"dt {{ts}} not null, " +
+		"rows int not null, " +
+		"digest text not null, " +
+		"indexed_at {{tsnow}} not null, " +
+		"primary key(doc_type, dt)" +
+		")"
+}
+
+// ReadESRawCheckpoints - returns max indexed row timestamps of all raw ES document types that have them
+func ReadESRawCheckpoints(con *sql.DB, ctx *Ctx) map[string]time.Time {
+	checkpoints := make(map[string]time.Time)
+	rows := QuerySQLWithErr(con, ctx, "select doc_type, dt from gha_es_raw_checkpoints")
+	defer func() { FatalOnError(rows.Close()) }()
+	var (
+		docType string
+		dt      time.Time
+	)
+	for rows.Next() {
+		FatalOnError(rows.Scan(&docType, &dt))
+		checkpoints[docType] = dt
+	}
+	FatalOnError(rows.Err())
+	return checkpoints
+}
+
+// SetESRawCheckpoints - sets max indexed row timestamps of raw ES document types that were indexed
+// Types without indexed rows keep their checkpoints, checkpoints never move back
+func SetESRawCheckpoints(con *sql.DB, ctx *Ctx, maxes map[string]time.Time) {
+	for _, docType := range ESRawDocTypes() {
+		dt, ok := maxes[docType]
+		if !ok {
+			continue
+		}
+		ExecSQLWithErr(
+			con,
+			ctx,
+			"insert into gha_es_raw_checkpoints(doc_type, dt) "+NValues(2)+
+				" on conflict(doc_type) do update set dt = greatest(gha_es_raw_checkpoints.dt, excluded.dt), updated_at = now()",
+			docType,
+			dt,
+		)
+	}
+}
+
+// ReadESRawDays - returns stored fingerprints of a given day, keyed by document type
+func ReadESRawDays(con *sql.DB, ctx *Ctx, day time.Time) map[string]ESRawDay {
+	days := make(map[string]ESRawDay)
+	rows := QuerySQLWithErr(con, ctx, "select doc_type, dt, rows, digest from gha_es_raw_days where dt = "+NValue(1), day)
+	defer func() { FatalOnError(rows.Close()) }()
+	var d ESRawDay
+	for rows.Next() {
+		FatalOnError(rows.Scan(&d.DocType, &d.Day, &d.Rows, &d.Digest))
+		days[d.DocType] = d
+	}
+	FatalOnError(rows.Err())
+	return days
+}
+
+// SaveESRawDay - stores fingerprint of a just indexed day
+func SaveESRawDay(con *sql.DB, ctx *Ctx, day ESRawDay) {
+	ExecSQLWithErr(
+		con,
+		ctx,
+		"insert into gha_es_raw_days(doc_type, dt, rows, digest) "+NValues(4)+
+			" on conflict(doc_type, dt) do update set rows = excluded.rows, digest = excluded.digest, indexed_at = now()",
+		day.DocType,
+		day.Day,
+		day.Rows,
+		day.Digest,
+	)
+}
+
+// ClearESRawDays - removes all stored fingerprints, used when raw ES index is regenerated from scratch
+func ClearESRawDays(con *sql.DB, ctx *Ctx) {
+	ExecSQLWithErr(con, ctx, "delete from gha_es_raw_days")
+}
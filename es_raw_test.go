@@ -0,0 +1,137 @@
+package devstatscode
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+	testlib "github.com/cncf/devstatscode/test"
+)
+
+func TestESRawFingerprint(t *testing.T) {
+	type doc struct {
+		Type string `json:"type"`
+		SHA  string `json:"sha"`
+	}
+	a := doc{Type: "commit", SHA: "a"}
+	b := doc{Type: "commit", SHA: "b"}
+	b2 := doc{Type: "commit", SHA: "b2"}
+
+	var f1, f2, f3, f4 lib.ESRawFingerprint
+	f1.Add(a)
+	f1.Add(b)
+	f2.Add(b)
+	f2.Add(a)
+	f3.Add(a)
+	f3.Add(b2)
+	f4.Add(a)
+
+	day := lib.ESRawDay{DocType: "commits", Rows: f1.Rows, Digest: f1.Digest()}
+	if !f2.Same(day) {
+		t.Errorf("expected fingerprint to not depend on documents order: %+v, %+v", f1, f2)
+	}
+	if f3.Same(day) {
+		t.Errorf("expected updated document to change fingerprint: %+v, %+v", f1, f3)
+	}
+	if f4.Same(day) {
+		t.Errorf("expected missing document to change fingerprint: %+v, %+v", f1, f4)
+	}
+	var empty lib.ESRawFingerprint
+	if !empty.Same(lib.ESRawDay{Digest: "0"}) {
+		t.Errorf("expected empty fingerprint to match empty day, got digest '%s'", empty.Digest())
+	}
+}
+
+func TestESRawStarts(t *testing.T) {
+	ft := testlib.YMDHMS
+	var testCases = []struct {
+		from        time.Time
+		checkpoints map[string]time.Time
+		lookback    int
+		expected    map[string]time.Time
+		start       time.Time
+	}{
+		{
+			from:        ft(2021, 3, 10, 12),
+			checkpoints: map[string]time.Time{},
+			lookback:    2,
+			expected:    map[string]time.Time{"commits": ft(2021, 3, 10), "issues": ft(2021, 3, 10), "prs": ft(2021, 3, 10), "texts": ft(2021, 3, 10)},
+			start:       ft(2021, 3, 10),
+		},
+		{
+			from:        ft(2021, 3, 10, 12),
+			checkpoints: map[string]time.Time{"commits": ft(2021, 3, 11, 13), "issues": ft(2021, 3, 10, 13)},
+			lookback:    0,
+			expected:    map[string]time.Time{"commits": ft(2021, 3, 10), "issues": ft(2021, 3, 10), "prs": ft(2021, 3, 10), "texts": ft(2021, 3, 10)},
+			start:       ft(2021, 3, 10),
+		},
+		{
+			from:        ft(2021, 3, 10, 12),
+			checkpoints: map[string]time.Time{"commits": ft(2021, 3, 10, 13), "issues": ft(2021, 3, 12), "prs": ft(2021, 3, 11), "texts": ft(2021, 3, 5, 3)},
+			lookback:    1,
+			expected:    map[string]time.Time{"commits": ft(2021, 3, 9), "issues": ft(2021, 3, 10), "prs": ft(2021, 3, 10), "texts": ft(2021, 3, 4)},
+			start:       ft(2021, 3, 4),
+		},
+		{
+			from:        ft(2021, 3, 10, 12),
+			checkpoints: map[string]time.Time{"unknown": ft(2020, 1, 1)},
+			lookback:    1,
+			expected:    map[string]time.Time{"commits": ft(2021, 3, 10), "issues": ft(2021, 3, 10), "prs": ft(2021, 3, 10), "texts": ft(2021, 3, 10)},
+			start:       ft(2021, 3, 10),
+		},
+		{
+			from:        ft(2021, 2, 1),
+			checkpoints: map[string]time.Time{"commits": ft(2021, 3, 10, 13), "issues": ft(2021, 3, 10, 13), "prs": ft(2021, 3, 10, 13), "texts": ft(2021, 1, 20, 3)},
+			lookback:    2,
+			expected:    map[string]time.Time{"commits": ft(2021, 2, 1), "issues": ft(2021, 2, 1), "prs": ft(2021, 2, 1), "texts": ft(2021, 1, 18)},
+			start:       ft(2021, 1, 18),
+		},
+	}
+	for index, test := range testCases {
+		got := lib.ESRawStarts(test.from, test.checkpoints, test.lookback)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test number %d, expected %v, got %v, test case: %+v", index+1, test.expected, got, test)
+		}
+		start := lib.ESRawStart(got)
+		if !start.Equal(test.start) {
+			t.Errorf("test number %d, expected start %v, got %v, test case: %+v", index+1, test.start, start, test)
+		}
+	}
+}
+
+func TestESRawIndexed(t *testing.T) {
+	ft := testlib.YMDHMS
+	starts := map[string]time.Time{"commits": ft(2021, 3, 9), "texts": ft(2021, 3, 11)}
+	var testCases = []struct {
+		docType  string
+		day      time.Time
+		expected bool
+	}{
+		{docType: "commits", day: ft(2021, 3, 8), expected: false},
+		{docType: "commits", day: ft(2021, 3, 9), expected: true},
+		{docType: "commits", day: ft(2021, 3, 10), expected: true},
+		{docType: "texts", day: ft(2021, 3, 10), expected: false},
+		{docType: "texts", day: ft(2021, 3, 11), expected: true},
+		{docType: "issues", day: ft(2021, 3, 1), expected: true},
+	}
+	for index, test := range testCases {
+		got := lib.ESRawIndexed(starts, test.docType, test.day)
+		if got != test.expected {
+			t.Errorf("test number %d, expected %v, got %v, test case: %+v", index+1, test.expected, got, test)
+		}
+	}
+}
+
+func TestESRawMaxes(t *testing.T) {
+	ft := testlib.YMDHMS
+	maxes := map[string]time.Time{}
+	lib.ESRawMaxes(maxes, map[string]time.Time{"commits": ft(2021, 3, 10, 5), "issues": ft(2021, 3, 10, 7)})
+	lib.ESRawMaxes(maxes, map[string]time.Time{"commits": ft(2021, 3, 9, 23), "prs": ft(2021, 3, 8)})
+	lib.ESRawMaxes(maxes, map[string]time.Time{"issues": ft(2021, 3, 11, 1)})
+	lib.ESRawMaxes(maxes, nil)
+	expected := map[string]time.Time{"commits": ft(2021, 3, 10, 5), "issues": ft(2021, 3, 11, 1), "prs": ft(2021, 3, 8)}
+	if !reflect.DeepEqual(maxes, expected) {
+		t.Errorf("expected %v, got %v", expected, maxes)
+	}
+}
@@ -0,0 +1,221 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExplainPlan - estimated query plan of a single metric SQL for a representative period
+type ExplainPlan struct {
+	Metric      string
+	SQL         string
+	Period      string
+	StartupCost float64
+	TotalCost   float64
+	PlanRows    float64
+	Plan        string
+	PrevCost    float64
+	Err         error
+}
+
+// Regression - checks if plan's estimated cost grew at least ratio times since the previous run
+// Plans with cost below minCost and plans without previous cost are never regressions
+func (p *ExplainPlan) Regression(ratio, minCost float64) bool {
+	if p.Err != nil || p.PrevCost <= 0.0 || p.TotalCost < minCost {
+		return false
+	}
+	return p.TotalCost >= ratio*p.PrevCost
+}
+
+// ParseExplainJSON - parses "explain (format json)" output, returns top plan node estimates
+func ParseExplainJSON(data []byte) (startupCost, totalCost, planRows float64, err error) {
+	var plans []struct {
+		Plan struct {
+			StartupCost float64 `json:"Startup Cost"`
+			TotalCost   float64 `json:"Total Cost"`
+			PlanRows    float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	err = json.Unmarshal(data, &plans)
+	if err != nil {
+		return
+	}
+	if len(plans) == 0 {
+		err = fmt.Errorf("empty plan")
+		return
+	}
+	plan := plans[len(plans)-1].Plan
+	return plan.StartupCost, plan.TotalCost, plan.PlanRows, nil
+}
+
+// ExplainPeriod - returns representative period (with aggregate suffix) used to explain metric SQL
+// This is the first not skipped period of the metric, annotations ranges metrics use the last year range
+func ExplainPeriod(metric *Metric) string {
+	if metric.AnnotationsRanges {
+		return "y"
+	}
+	periods, _ := metric.PeriodVariants(nil)
+	for _, period := range periods {
+		if period.Period != "" {
+			return period.Name
+		}
+	}
+	return "w"
+}
+
+// ExplainMetricSQL - replaces metric SQL placeholders with values calc_metric would use for the latest complete period
+func ExplainMetricSQL(sqlQuery, excludeBots, period, projectScale string, hist bool, now time.Time) string {
+	interval, nIntervals, intervalStart, nextIntervalStart, prevIntervalStart := GetIntervalFunctions(period, false)
+	sqlQuery = strings.Replace(sqlQuery, "{{n}}", strconv.Itoa(nIntervals)+".0", -1)
+	sqlQuery = strings.Replace(sqlQuery, "{{exclude_bots}}", excludeBots, -1)
+	sqlQuery = strings.Replace(sqlQuery, "{{project_scale}}", projectScale, -1)
+	sqlQuery = strings.Replace(sqlQuery, "{{rnd}}", randString(), -1)
+	if hist {
+		dbInterval := fmt.Sprintf("%d %s", nIntervals, interval)
+		if interval == Quarter {
+			dbInterval = fmt.Sprintf("%d month", nIntervals*3)
+		}
+		sHours := ""
+		sqlQuery, sHours = PrepareQuickRangeQuery(sqlQuery, dbInterval, "", "")
+		sqlQuery = strings.Replace(sqlQuery, "{{period}}", dbInterval, -1)
+		return strings.Replace(sqlQuery, "{{range}}", sHours, -1)
+	}
+	dt := prevIntervalStart(intervalStart(now))
+	from, to := dt, nextIntervalStart(dt)
+	if nIntervals > 1 {
+		from = AddNIntervals(dt, 1-nIntervals, nextIntervalStart, prevIntervalStart)
+	}
+	sqlQuery = strings.Replace(sqlQuery, "{{from}}", ToYMDHMSDate(from), -1)
+	sqlQuery = strings.Replace(sqlQuery, "{{to}}", ToYMDHMSDate(to), -1)
+	return strings.Replace(sqlQuery, "{{range}}", RangeHours(from, to), -1)
+}
+
+// explainPlansTableDef - explain plans history table definition
+func explainPlansTableDef() string {
+	return "gha_explain_plans(" +
+		"dt {{tsnow}} not null, " +
+		"metric text not null, " +
+		"sql text not null, " +
+		"period text not null, " +
+		"startup_cost double precision not null, " +
+		"total_cost double precision not null, " +
+		"plan_rows double precision not null, " +
+		"plan text not null, " +
+		"primary key(metric, sql, period, dt)" +
+		")"
+}
+
+// ensureExplainPlansTable - creates explain plans history table if needed
+func ensureExplainPlansTable(con *sql.DB, ctx *Ctx) {
+	if TableExists(con, ctx, "gha_explain_plans") {
+		return
+	}
+	ExecSQLWithErr(con, ctx, strings.Replace(CreateTable(explainPlansTableDef()), "create table ", "create table if not exists ", 1))
+}
+
+// explainQuery - runs "explain (format json)" for a given query, errors are returned (metric SQL can be not explainable)
+func explainQuery(con *sql.DB, ctx *Ctx, query string, plan *ExplainPlan) {
+	rows, err := QuerySQL(con, ctx, "explain (format json) "+query)
+	if err != nil {
+		plan.Err = err
+		return
+	}
+	defer func() { FatalOnError(rows.Close()) }()
+	data := []string{}
+	s := ""
+	for rows.Next() {
+		FatalOnError(rows.Scan(&s))
+		data = append(data, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		plan.Err = err
+		return
+	}
+	plan.Plan = strings.Join(data, "\n")
+	plan.StartupCost, plan.TotalCost, plan.PlanRows, plan.Err = ParseExplainJSON([]byte(plan.Plan))
+}
+
+// ExplainMetrics - explains SQLs of all project's metrics (or only metrics with given names) for their representative periods
+// Plans are stored in gha_explain_plans (not in dry-run mode), each plan has estimated cost from the previous run set
+func ExplainMetrics(con *sql.DB, ctx *Ctx, dataPrefix string, allMetrics *AllMetrics, names []string, now time.Time) (plans []ExplainPlan) {
+	bytes, err := ReadFile(ctx, dataPrefix+"util_sql/exclude_bots.sql")
+	FatalOnError(err)
+	excludeBots := string(bytes)
+	metricsDir := dataPrefix + Metrics
+	if ctx.Project != "" {
+		metricsDir += ctx.Project + "/"
+	}
+	filter := make(map[string]struct{})
+	for _, name := range names {
+		filter[name] = struct{}{}
+	}
+	if !ctx.DryRun {
+		ensureExplainPlansTable(con, ctx)
+	}
+	for i := range allMetrics.Metrics {
+		metric := &allMetrics.Metrics[i]
+		if metric.Disabled || ExcludedForProject(ctx.Project, metric.Project) {
+			continue
+		}
+		if _, ok := filter[metric.Name]; len(filter) > 0 && !ok {
+			continue
+		}
+		sqls := []string{metric.MetricSQL}
+		if metric.MetricSQLs != nil {
+			sqls = *metric.MetricSQLs
+		}
+		period := ExplainPeriod(metric)
+		cfg := ParseCalcMetricOptions(strings.Join(metric.CalcMetricOptions(ctx), ","))
+		for _, sqlFile := range sqls {
+			plan := ExplainPlan{Metric: metric.Name, SQL: sqlFile, Period: period}
+			bytes, err := ReadFile(ctx, metricsDir+sqlFile+".sql")
+			FatalOnError(err)
+			query := ExplainMetricSQL(string(bytes), excludeBots, period, cfg.ProjectScale, metric.Histogram, now)
+			explainQuery(con, ctx, query, &plan)
+			if plan.Err != nil {
+				Printf("Cannot explain metric %s SQL %s: %v\n", metric.Name, sqlFile, plan.Err)
+				plans = append(plans, plan)
+				continue
+			}
+			if TableExists(con, ctx, "gha_explain_plans") {
+				rows := QuerySQLWithErr(
+					con,
+					ctx,
+					"select total_cost from gha_explain_plans where metric = $1 and sql = $2 and period = $3 order by dt desc limit 1",
+					plan.Metric,
+					plan.SQL,
+					plan.Period,
+				)
+				for rows.Next() {
+					FatalOnError(rows.Scan(&plan.PrevCost))
+				}
+				FatalOnError(rows.Err())
+				FatalOnError(rows.Close())
+			}
+			if !ctx.DryRun {
+				ExecSQLWithErr(
+					con,
+					ctx,
+					"insert into gha_explain_plans(metric, sql, period, startup_cost, total_cost, plan_rows, plan) "+NValues(7),
+					plan.Metric,
+					plan.SQL,
+					plan.Period,
+					plan.StartupCost,
+					plan.TotalCost,
+					plan.PlanRows,
+					plan.Plan,
+				)
+			}
+			if ctx.Debug > 0 {
+				Printf("Explained metric %s SQL %s period %s: cost %.2f (previous %.2f), rows %.0f\n", plan.Metric, plan.SQL, plan.Period, plan.TotalCost, plan.PrevCost, plan.PlanRows)
+			}
+			plans = append(plans, plan)
+		}
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package devstatscode
+
+import (
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestParseExplainJSON(t *testing.T) {
+	var testCases = []struct {
+		data     string
+		total    float64
+		rows     float64
+		hasError bool
+	}{
+		{
+			data:  `[{"Plan": {"Node Type": "Aggregate", "Startup Cost": 10.5, "Total Cost": 1234.75, "Plan Rows": 3, "Plans": [{"Total Cost": 1000}]}}]`,
+			total: 1234.75,
+			rows:  3,
+		},
+		{data: `[]`, hasError: true},
+		{data: `not json`, hasError: true},
+	}
+	for index, test := range testCases {
+		_, total, rows, err := lib.ParseExplainJSON([]byte(test.data))
+		if (err != nil) != test.hasError || total != test.total || rows != test.rows {
+			t.Errorf("test number %d, expected cost %v, rows %v, error: %v, got %v, %v, %v", index+1, test.total, test.rows, test.hasError, total, rows, err)
+		}
+	}
+}
+
+func TestExplainPlanRegression(t *testing.T) {
+	var testCases = []struct {
+		prev, curr float64
+		expected   bool
+	}{
+		{prev: 0, curr: 50000, expected: false},
+		{prev: 10000, curr: 15000, expected: false},
+		{prev: 10000, curr: 20000, expected: true},
+		{prev: 100, curr: 900, expected: false},
+		{prev: 20000, curr: 10000, expected: false},
+	}
+	for index, test := range testCases {
+		plan := lib.ExplainPlan{PrevCost: test.prev, TotalCost: test.curr}
+		got := plan.Regression(2.0, 1000.0)
+		if got != test.expected {
+			t.Errorf("test number %d, expected %v, got %v", index+1, test.expected, got)
+		}
+	}
+}
+
+func TestExplainPeriod(t *testing.T) {
+	var testCases = []struct {
+		metric   lib.Metric
+		expected string
+	}{
+		{metric: lib.Metric{Periods: "d,w,m"}, expected: "d"},
+		{metric: lib.Metric{Periods: "d,w", Aggregate: "7,1", Skip: "w7"}, expected: "d7"},
+		{metric: lib.Metric{Periods: "w,m", Skip: "w"}, expected: "m"},
+		{metric: lib.Metric{AnnotationsRanges: true}, expected: "y"},
+		{metric: lib.Metric{}, expected: "w"},
+	}
+	for index, test := range testCases {
+		got := lib.ExplainPeriod(&test.metric)
+		if got != test.expected {
+			t.Errorf("test number %d, expected %s, got %s", index+1, test.expected, got)
+		}
+	}
+}
+
+func TestExplainMetricSQL(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)
+	var testCases = []struct {
+		sql      string
+		period   string
+		hist     bool
+		expected string
+	}{
+		{
+			sql:      "select count(*) * {{project_scale}} / {{n}} from t where dt >= '{{from}}' and dt < '{{to}}' {{exclude_bots}}",
+			period:   "d7",
+			expected: "select count(*) * 2.0 / 7.0 from t where dt >= '2026-10-11 00:00:00' and dt < '2026-10-18 00:00:00' and bot = false",
+		},
+		{
+			sql:      "select name, count(*) from t where dt >= now() - '{{period}}'::interval group by name",
+			period:   "q",
+			hist:     true,
+			expected: "select name, count(*) from t where dt >= now() - '3 month'::interval group by name",
+		},
+		{
+			sql:      "select name, count(*) from t where {{period:t.dt}} group by name",
+			period:   "w",
+			hist:     true,
+			expected: "select name, count(*) from t where  (t.dt >= now() - '1 week'::interval)  group by name",
+		},
+	}
+	for index, test := range testCases {
+		got := lib.ExplainMetricSQL(test.sql, "and bot = false", test.period, "2.0", test.hist, now)
+		if got != test.expected {
+			t.Errorf("test number %d, expected:\n%s\ngot:\n%s", index+1, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportManifestFile - name of the bulk TSDB export manifest file stored in export directory
+const ExportManifestFile = "manifest.json"
+
+// ExportManifest - describes all files written by TSDB export, it is also used by incremental export
+type ExportManifest struct {
+	Project    string                  `json:"project"`
+	Database   string                  `json:"database"`
+	Format     string                  `json:"format"`
+	ExportedAt time.Time               `json:"exported_at"`
+	Tables     map[string]*ExportTable `json:"tables"`
+}
+
+// ExportTable - single exported series table: columns, partitions and maximum exported time
+type ExportTable struct {
+	Columns    []ExportColumn              `json:"columns"`
+	MaxTime    *time.Time                  `json:"max_time,omitempty"`
+	Partitions map[string]*ExportPartition `json:"partitions"`
+}
+
+// ExportPartition - single exported file, partitions are months of the "time" column
+// Tables without "time" column are exported as a single "all" partition
+type ExportPartition struct {
+	File       string     `json:"file"`
+	Rows       int        `json:"rows"`
+	MinTime    *time.Time `json:"min_time,omitempty"`
+	MaxTime    *time.Time `json:"max_time,omitempty"`
+	ExportedAt time.Time  `json:"exported_at"`
+}
+
+// ExportPartitionKey - returns partition name for a given row time (nil means table has no time column)
+func ExportPartitionKey(dt *time.Time) string {
+	if dt == nil {
+		return "all"
+	}
+	return dt.Format("2006-01")
+}
+
+// ExportTimeRange - returns time range to export for a given table with given periods
+// Range is always extended to whole months, so partition files are never replaced with a part of month's rows
+// In incremental mode, export restarts at the beginning of each period's interval containing the last exported time:
+// points are dated at their interval start and the current interval (for example quarter or year) is recalculated in place
+// Periods without interval functions (quick ranges, histograms) are recalculated as a whole, so they are fully exported
+func ExportTimeRange(ctx *Ctx, prev *ExportTable, periods []string) (from, to time.Time) {
+	from, to = ctx.ExportFrom, ctx.ExportTo
+	if ctx.ExportIncremental && prev != nil && prev.MaxTime != nil {
+		since := MonthStart(*prev.MaxTime)
+		for _, period := range periods {
+			_, _, intervalStart, _, _ := GetIntervalFunctions(period, true)
+			if intervalStart == nil {
+				since = time.Time{}
+				break
+			}
+			if start := intervalStart(*prev.MaxTime); start.Before(since) {
+				since = start
+			}
+		}
+		if since.After(from) {
+			from = since
+		}
+	}
+	if !from.IsZero() {
+		from = MonthStart(from)
+	}
+	if !to.IsZero() && !to.Equal(MonthStart(to)) {
+		to = NextMonthStart(to)
+	}
+	return
+}
+
+// exportColumnType - maps Postgres data type to exported column type
+func exportColumnType(dataType string) string {
+	switch {
+	case strings.HasPrefix(dataType, "timestamp"), dataType == "date":
+		return "time"
+	case dataType == "double precision", dataType == "real", dataType == "numeric":
+		return "float"
+	case dataType == "integer", dataType == "bigint", dataType == "smallint":
+		return "int"
+	default:
+		return "string"
+	}
+}
+
+// exportValue - converts value scanned by the Postgres driver to exported column type
+func exportValue(typ string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch typ {
+	case "float":
+		switch v := value.(type) {
+		case float64:
+			return v
+		case []byte:
+			f, err := strconv.ParseFloat(string(v), 64)
+			FatalOnError(err)
+			return f
+		}
+	case "int":
+		switch v := value.(type) {
+		case int64:
+			return v
+		case []byte:
+			i, err := strconv.ParseInt(string(v), 10, 64)
+			FatalOnError(err)
+			return i
+		}
+	case "time":
+		if v, ok := value.(time.Time); ok {
+			return v.UTC()
+		}
+	}
+	if v, ok := value.([]byte); ok {
+		return string(v)
+	}
+	return value
+}
+
+// WriteExportCSV - writes rows as a CSV file with header
+func WriteExportCSV(fn string, columns []ExportColumn, rows [][]interface{}) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(f)
+	hdr := []string{}
+	for _, column := range columns {
+		hdr = append(hdr, column.Name)
+	}
+	err = writer.Write(hdr)
+	for _, row := range rows {
+		if err != nil {
+			break
+		}
+		record := make([]string, len(row))
+		for i, value := range row {
+			switch v := value.(type) {
+			case nil:
+			case time.Time:
+				record[i] = ToYMDHMSDate(v)
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprintf("%v", v)
+			}
+		}
+		err = writer.Write(record)
+	}
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeExportPartition - writes single partition file and returns its manifest entry
+func writeExportPartition(ctx *Ctx, table, key string, columns []ExportColumn, timeCol int, rows [][]interface{}) *ExportPartition {
+	dir := ctx.ExportDir + table + "/"
+	FatalOnError(os.MkdirAll(dir, 0755))
+	file := table + "/" + key + "." + ctx.ExportFormat
+	fn := ctx.ExportDir + file
+	if ctx.ExportFormat == "parquet" {
+		f, err := os.Create(fn)
+		FatalOnError(err)
+		FatalOnError(WriteParquet(f, columns, rows))
+		FatalOnError(f.Close())
+	} else {
+		FatalOnError(WriteExportCSV(fn, columns, rows))
+	}
+	part := &ExportPartition{File: file, Rows: len(rows), ExportedAt: time.Now()}
+	if timeCol >= 0 {
+		for _, row := range rows {
+			dt, ok := row[timeCol].(time.Time)
+			if !ok {
+				continue
+			}
+			if part.MinTime == nil || dt.Before(*part.MinTime) {
+				part.MinTime = &dt
+			}
+			if part.MaxTime == nil || dt.After(*part.MaxTime) {
+				part.MaxTime = &dt
+			}
+		}
+	}
+	if ctx.Debug > 0 {
+		Printf("Exported %s: %d rows\n", fn, len(rows))
+	}
+	return part
+}
+
+// exportPeriods - returns distinct periods of a series table, nil when table has no period column
+func exportPeriods(con *sql.DB, ctx *Ctx, table string, columns []ExportColumn) (periods []string) {
+	found := false
+	for _, column := range columns {
+		if column.Name == "period" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	rows := QuerySQLWithErr(con, ctx, "select distinct period from \""+escapeName(table)+"\"")
+	defer func() { FatalOnError(rows.Close()) }()
+	period := ""
+	for rows.Next() {
+		FatalOnError(rows.Scan(&period))
+		periods = append(periods, period)
+	}
+	FatalOnError(rows.Err())
+	return
+}
+
+// ExportSeriesTable - exports a single series table into partition files, returns its manifest entry
+// prev is the table's entry from the previous export's manifest (can be nil)
+func ExportSeriesTable(con *sql.DB, ctx *Ctx, table string, prev *ExportTable) *ExportTable {
+	rows := QuerySQLWithErr(
+		con,
+		ctx,
+		"select column_name, data_type from information_schema.columns "+
+			"where table_schema = 'public' and table_name = $1 order by ordinal_position",
+		table,
+	)
+	columns := []ExportColumn{}
+	timeCol := -1
+	name, dataType := "", ""
+	for rows.Next() {
+		FatalOnError(rows.Scan(&name, &dataType))
+		typ := exportColumnType(dataType)
+		if name == "time" && typ == "time" {
+			timeCol = len(columns)
+		}
+		columns = append(columns, ExportColumn{Name: name, Type: typ})
+	}
+	FatalOnError(rows.Err())
+	FatalOnError(rows.Close())
+	result := &ExportTable{Columns: columns, Partitions: make(map[string]*ExportPartition)}
+	if prev != nil {
+		for key, part := range prev.Partitions {
+			result.Partitions[key] = part
+		}
+		result.MaxTime = prev.MaxTime
+	}
+
+	// Select with optional time range, rows ordered by time so partitions are contiguous
+	quoted := []string{}
+	for _, column := range columns {
+		quoted = append(quoted, "\""+escapeName(column.Name)+"\"")
+	}
+	query := "select " + strings.Join(quoted, ", ") + " from \"" + escapeName(table) + "\""
+	args := []interface{}{}
+	if timeCol >= 0 {
+		periods := []string{}
+		if ctx.ExportIncremental && prev != nil {
+			periods = exportPeriods(con, ctx, table, columns)
+		}
+		from, to := ExportTimeRange(ctx, prev, periods)
+		conds := []string{}
+		if !from.IsZero() {
+			args = append(args, from)
+			conds = append(conds, fmt.Sprintf("time >= $%d", len(args)))
+		}
+		if !to.IsZero() {
+			args = append(args, to)
+			conds = append(conds, fmt.Sprintf("time < $%d", len(args)))
+		}
+		if len(conds) > 0 {
+			query += " where " + strings.Join(conds, " and ")
+		}
+		query += " order by time"
+	} else if ctx.ExportIncremental && prev != nil {
+		// Tables without time column are always fully re-exported
+		result.Partitions = make(map[string]*ExportPartition)
+	}
+	rows = QuerySQLWithErr(con, ctx, query, args...)
+	defer func() { FatalOnError(rows.Close()) }()
+	vals := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	key := ""
+	data := [][]interface{}{}
+	flush := func() {
+		if len(data) == 0 {
+			return
+		}
+		part := writeExportPartition(ctx, table, key, columns, timeCol, data)
+		result.Partitions[key] = part
+		if part.MaxTime != nil && (result.MaxTime == nil || part.MaxTime.After(*result.MaxTime)) {
+			result.MaxTime = part.MaxTime
+		}
+		data = [][]interface{}{}
+	}
+	for rows.Next() {
+		FatalOnError(rows.Scan(ptrs...))
+		row := make([]interface{}, len(columns))
+		for i, column := range columns {
+			row[i] = exportValue(column.Type, vals[i])
+		}
+		var dt *time.Time
+		if timeCol >= 0 {
+			if t, ok := row[timeCol].(time.Time); ok {
+				dt = &t
+			}
+		}
+		rowKey := ExportPartitionKey(dt)
+		if rowKey != key {
+			flush()
+			key = rowKey
+		}
+		data = append(data, row)
+	}
+	FatalOnError(rows.Err())
+	flush()
+	return result
+}
+
+// ReadExportManifest - reads previous export manifest from export directory, returns nil if there is none
+func ReadExportManifest(ctx *Ctx) *ExportManifest {
+	data, err := ioutil.ReadFile(ctx.ExportDir + ExportManifestFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		FatalOnError(err)
+	}
+	var manifest ExportManifest
+	FatalOnError(json.Unmarshal(data, &manifest))
+	return &manifest
+}
+
+// ExportSeries - exports all series tables matching ctx.ExportSeries into ctx.ExportDir and writes manifest
+func ExportSeries(con *sql.DB, ctx *Ctx) *ExportManifest {
+	var prev *ExportManifest
+	if ctx.ExportIncremental {
+		prev = ReadExportManifest(ctx)
+		if prev != nil && prev.Format != ctx.ExportFormat {
+			Fatalf("previous export uses '%s' format, cannot continue it using '%s'", prev.Format, ctx.ExportFormat)
+		}
+	}
+	manifest := &ExportManifest{
+		Project:    ctx.Project,
+		Database:   ctx.PgDB,
+		Format:     ctx.ExportFormat,
+		ExportedAt: time.Now(),
+		Tables:     make(map[string]*ExportTable),
+	}
+	if prev != nil {
+		for table, data := range prev.Tables {
+			manifest.Tables[table] = data
+		}
+	}
+	FatalOnError(os.MkdirAll(ctx.ExportDir, 0755))
+	for _, table := range GetSeriesTables(con, ctx) {
+		if !strings.HasPrefix(table, "s") {
+			continue
+		}
+		if ctx.ExportSeries != nil && !ctx.ExportSeries.MatchString(table) {
+			continue
+		}
+		manifest.Tables[table] = ExportSeriesTable(con, ctx, table, manifest.Tables[table])
+		if ctx.Debug > 0 {
+			Printf("Exported table %s: %d partitions\n", table, len(manifest.Tables[table].Partitions))
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	FatalOnError(err)
+	FatalOnError(ioutil.WriteFile(ctx.ExportDir+ExportManifestFile, data, 0644))
+	return manifest
+}
@@ -0,0 +1,84 @@
+package devstatscode
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestExportTimeRange(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	ft := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	last := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	var testCases = []struct {
+		from, to     time.Time
+		incremental  bool
+		prev         *lib.ExportTable
+		periods      []string
+		expectedFrom time.Time
+		expectedTo   time.Time
+	}{
+		{expectedFrom: time.Time{}, expectedTo: time.Time{}},
+		{from: ft(2020, 1, 1), to: ft(2021, 1, 1), expectedFrom: ft(2020, 1, 1), expectedTo: ft(2021, 1, 1)},
+		{from: ft(2020, 1, 15), to: ft(2020, 12, 2), expectedFrom: ft(2020, 1, 1), expectedTo: ft(2021, 1, 1)},
+		{from: ft(2020, 1, 1), prev: &lib.ExportTable{MaxTime: &last}, expectedFrom: ft(2020, 1, 1)},
+		{incremental: true, prev: &lib.ExportTable{MaxTime: &last}, expectedFrom: ft(2021, 3, 1)},
+		{incremental: true, prev: &lib.ExportTable{MaxTime: &last}, periods: []string{"h", "d7"}, expectedFrom: ft(2021, 3, 1)},
+		{incremental: true, prev: &lib.ExportTable{MaxTime: &last}, periods: []string{"d", "w", "m", "q"}, expectedFrom: ft(2021, 1, 1)},
+		{incremental: true, prev: &lib.ExportTable{MaxTime: &last}, periods: []string{"d", "y"}, expectedFrom: ft(2021, 1, 1)},
+		{incremental: true, prev: &lib.ExportTable{MaxTime: &last}, periods: []string{"w", "anno_0_1"}, expectedFrom: time.Time{}},
+		{incremental: true, prev: &lib.ExportTable{}, expectedFrom: time.Time{}},
+		{incremental: true, from: ft(2021, 4, 1), prev: &lib.ExportTable{MaxTime: &last}, expectedFrom: ft(2021, 4, 1)},
+		{incremental: true, from: ft(2020, 6, 10), prev: &lib.ExportTable{MaxTime: &last}, periods: []string{"y"}, expectedFrom: ft(2021, 1, 1)},
+	}
+	for index, test := range testCases {
+		ctx.ExportFrom = test.from
+		ctx.ExportTo = test.to
+		ctx.ExportIncremental = test.incremental
+		from, to := lib.ExportTimeRange(&ctx, test.prev, test.periods)
+		if !from.Equal(test.expectedFrom) || !to.Equal(test.expectedTo) {
+			t.Errorf(
+				"test number %d, expected %v - %v, got %v - %v",
+				index+1, test.expectedFrom, test.expectedTo, from, to,
+			)
+		}
+	}
+	if lib.ExportPartitionKey(&last) != "2021-03" || lib.ExportPartitionKey(nil) != "all" {
+		t.Errorf("unexpected partition keys")
+	}
+}
+
+func TestWriteExportCSV(t *testing.T) {
+	f, err := ioutil.TempFile("", "export*.csv")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fn := f.Name()
+	_ = f.Close()
+	defer func() { _ = os.Remove(fn) }()
+	err = lib.WriteExportCSV(
+		fn,
+		[]lib.ExportColumn{{Name: "time", Type: "time"}, {Name: "value", Type: "float"}, {Name: "descr", Type: "string"}},
+		[][]interface{}{
+			{time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), 0.25, "a,b"},
+			{time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC), nil, nil},
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "time,value,descr\n2021-03-01 00:00:00,0.25,\"a,b\"\n2021-03-02 00:00:00,,\n"
+	if string(data) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, string(data))
+	}
+}
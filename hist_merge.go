@@ -0,0 +1,258 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mergeable histograms
+// Histogram metric can opt into mergeable mode using `mergeable: sum|max|min` in metrics.yaml
+// Its SQL must return (name, value) rows for a range given via {{period:column}} or {{from}}/{{to}}
+// and values for a range must be the given aggregate of values for its sub-ranges (so no {{period}},
+// {{n}} or {{range}} and no "limit" that would cut per sub-range results)
+// Results for complete days are stored in gha_hist_partials (computed days are marked in gha_computed)
+// and a histogram for a long range is a merge of stored days plus incomplete head and tail days
+// computed directly, so each run only scans the newest data instead of the whole range
+// Day is complete when it ends before the last synced hour minus GHA2DB_HIST_PARTIALS_LAG hours,
+// newer days can still get data and are always computed directly
+
+// HistRow - single histogram row: name and value
+type HistRow struct {
+	Name  string
+	Value float64
+}
+
+// CheckMergeable - checks mergeable aggregate name
+func CheckMergeable(aggr string) error {
+	switch aggr {
+	case "sum", "max", "min":
+		return nil
+	}
+	return fmt.Errorf("unknown mergeable aggregate '%s', allowed: sum, max, min", aggr)
+}
+
+// MergeHistValue - merges value into histogram using given aggregate
+func MergeHistValue(hist map[string]float64, aggr, name string, value float64) {
+	curr, ok := hist[name]
+	if !ok {
+		hist[name] = value
+		return
+	}
+	switch aggr {
+	case "max":
+		if value > curr {
+			hist[name] = value
+		}
+	case "min":
+		if value < curr {
+			hist[name] = value
+		}
+	default:
+		hist[name] = curr + value
+	}
+}
+
+// SortedHistRows - returns histogram rows sorted by value descending and name ascending
+func SortedHistRows(hist map[string]float64) (rows []HistRow) {
+	for name, value := range hist {
+		rows = append(rows, HistRow{Name: name, Value: value})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Value != rows[j].Value {
+			return rows[i].Value > rows[j].Value
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return
+}
+
+// HistCompleteBefore - returns time before which all data is synced: start of the last synced hour minus lag hours
+func HistCompleteBefore(lastSynced time.Time, lagHours int) time.Time {
+	return HourStart(lastSynced).Add(-time.Duration(lagHours) * time.Hour)
+}
+
+// HistDayBuckets - splits [from, to) range into head, complete days and tail
+// Days ending after `complete` are not complete and belong to the tail
+// Returns days starts and head/tail ranges (head/tail are empty when from == headTo or tailFrom == to)
+func HistDayBuckets(from, to, complete time.Time) (days []time.Time, headTo, tailFrom time.Time) {
+	first := DayStart(from)
+	if first.Before(from) {
+		first = NextDayStart(from)
+	}
+	last := DayStart(to)
+	if DayStart(complete).Before(last) {
+		last = DayStart(complete)
+	}
+	if !first.Before(last) {
+		return nil, to, to
+	}
+	for dt := first; dt.Before(last); dt = NextDayStart(dt) {
+		days = append(days, dt)
+	}
+	return days, first, last
+}
+
+// histPartialsTableDef - mergeable histograms partial results table definition
+func histPartialsTableDef() string {
+	return "gha_hist_partials(" +
+		"key text not null, " +
+		"bucket {{ts}} not null, " +
+		"name text not null, " +
+		"value double precision not null, " +
+		"primary key(key, bucket, name)" +
+		")"
+}
+
+// ensureHistPartialsTable - creates mergeable histograms partial results table if needed
+func ensureHistPartialsTable(con *sql.DB, ctx *Ctx) {
+	if TableExists(con, ctx, "gha_hist_partials") {
+		return
+	}
+	ExecSQLWithErr(con, ctx, strings.Replace(CreateTable(histPartialsTableDef()), "create table ", "create table if not exists ", 1))
+}
+
+// histRangeQuery - returns histogram SQL for [from, to) range
+func histRangeQuery(sqlQuery, excludeBots string, cfg *CalcMetricData, from, to time.Time) string {
+	sqlQuery, sHours := PrepareQuickRangeQuery(sqlQuery, "", ToYMDHMSDate(from), ToYMDHMSDate(to))
+	sqlQuery = strings.Replace(sqlQuery, "{{exclude_bots}}", excludeBots, -1)
+	sqlQuery = strings.Replace(sqlQuery, "{{range}}", sHours, -1)
+	sqlQuery = strings.Replace(sqlQuery, "{{project_scale}}", cfg.ProjectScale, -1)
+	sqlQuery = strings.Replace(sqlQuery, "{{rnd}}", randString(), -1)
+	return sqlQuery
+}
+
+// histRange - runs histogram SQL for [from, to) range and merges its results into hist
+func histRange(con *sql.DB, ctx *Ctx, sqlQuery, excludeBots string, cfg *CalcMetricData, from, to time.Time, hist map[string]float64) (nRows int) {
+	rows := QuerySQLWithErr(con, ctx, histRangeQuery(sqlQuery, excludeBots, cfg, from, to))
+	defer func() { FatalOnError(rows.Close()) }()
+	columns, err := rows.Columns()
+	FatalOnError(err)
+	if len(columns) != 2 {
+		Fatalf("mergeable histogram query must return 2 columns (name, value), got %d: %v", len(columns), columns)
+	}
+	var (
+		name  string
+		value float64
+	)
+	for rows.Next() {
+		FatalOnError(rows.Scan(&name, &value))
+		MergeHistValue(hist, cfg.Mergeable, name, value)
+		nRows++
+	}
+	FatalOnError(rows.Err())
+	return
+}
+
+// histLastSynced - returns time of the last synced event, zero time when there are no events
+func histLastSynced(con *sql.DB, ctx *Ctx) time.Time {
+	var dt *time.Time
+	FatalOnError(QueryRowSQL(con, ctx, "select max(created_at) from gha_events").Scan(&dt))
+	if dt == nil {
+		return time.Time{}
+	}
+	return dt.UTC()
+}
+
+// histComputedDays - returns days already stored for a given mergeable histogram key
+func histComputedDays(con *sql.DB, ctx *Ctx, key string, from, to time.Time) map[time.Time]struct{} {
+	rows := QuerySQLWithErr(
+		con,
+		ctx,
+		"select dt from gha_computed where metric = $1 and dt >= $2 and dt < $3",
+		key,
+		from,
+		to,
+	)
+	defer func() { FatalOnError(rows.Close()) }()
+	computed := make(map[time.Time]struct{})
+	var dt time.Time
+	for rows.Next() {
+		FatalOnError(rows.Scan(&dt))
+		computed[dt.UTC()] = struct{}{}
+	}
+	FatalOnError(rows.Err())
+	return computed
+}
+
+// histStoreDay - computes and stores a single complete day partial result
+func histStoreDay(con *sql.DB, ctx *Ctx, key, sqlQuery, excludeBots string, cfg *CalcMetricData, day time.Time) (nRows int) {
+	hist := make(map[string]float64)
+	nRows = histRange(con, ctx, sqlQuery, excludeBots, cfg, day, NextDayStart(day), hist)
+	for name, value := range hist {
+		ExecSQLWithErr(
+			con,
+			ctx,
+			InsertIgnore("into gha_hist_partials(key, bucket, name, value) "+NValues(4)),
+			key,
+			day,
+			name,
+			value,
+		)
+	}
+	ExecSQLWithErr(con, ctx, InsertIgnore("into gha_computed(metric, dt) "+NValues(2)), key, day)
+	return
+}
+
+// MergeableHistogram - calculates histogram for [from, to) range using stored per day partial results
+// Missing complete days are computed and stored, head and tail days are computed directly
+// When TSDB writes are disabled (also in dry-run mode), nothing is stored and whole range is computed at once
+func MergeableHistogram(con *sql.DB, ctx *Ctx, sqlFile, sqlQuery, excludeBots string, cfg *CalcMetricData, from, to time.Time) (rows []HistRow, nRows int) {
+	for _, placeholder := range []string{"{{period}}", "{{n}}", "{{range}}"} {
+		if strings.Contains(sqlQuery, placeholder) {
+			Fatalf("mergeable histogram %s cannot use %s, use {{period:column}} or {{from}}/{{to}}", sqlFile, placeholder)
+		}
+	}
+	FatalOnError(CheckMergeable(cfg.Mergeable))
+	hist := make(map[string]float64)
+	if ctx.SkipTSDB {
+		nRows = histRange(con, ctx, sqlQuery, excludeBots, cfg, from, to, hist)
+		return SortedHistRows(hist), nRows
+	}
+	ensureHistPartialsTable(con, ctx)
+	key := "hist:" + cfg.Mergeable + ":" + cfg.ProjectScale + ":" + getPathIndependentKey(sqlFile)
+	complete := HistCompleteBefore(histLastSynced(con, ctx), ctx.HistPartialsLag)
+	days, headTo, tailFrom := HistDayBuckets(from, to, complete)
+	if len(days) > 0 {
+		computed := histComputedDays(con, ctx, key, days[0], tailFrom)
+		stored := 0
+		for _, day := range days {
+			if _, ok := computed[day]; ok {
+				continue
+			}
+			nRows += histStoreDay(con, ctx, key, sqlQuery, excludeBots, cfg, day)
+			stored++
+		}
+		if ctx.Debug > 0 {
+			Printf("Mergeable histogram %s: %d days, %d newly computed\n", sqlFile, len(days), stored)
+		}
+		prows := QuerySQLWithErr(
+			con,
+			ctx,
+			"select name, "+cfg.Mergeable+"(value) from gha_hist_partials "+
+				"where key = $1 and bucket >= $2 and bucket < $3 group by name",
+			key,
+			days[0],
+			tailFrom,
+		)
+		var (
+			name  string
+			value float64
+		)
+		for prows.Next() {
+			FatalOnError(prows.Scan(&name, &value))
+			MergeHistValue(hist, cfg.Mergeable, name, value)
+		}
+		FatalOnError(prows.Err())
+		FatalOnError(prows.Close())
+	}
+	if from.Before(headTo) {
+		nRows += histRange(con, ctx, sqlQuery, excludeBots, cfg, from, headTo, hist)
+	}
+	if tailFrom.Before(to) {
+		nRows += histRange(con, ctx, sqlQuery, excludeBots, cfg, tailFrom, to, hist)
+	}
+	return SortedHistRows(hist), nRows
+}
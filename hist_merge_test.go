@@ -0,0 +1,97 @@
+package devstatscode
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestMergeHistValue(t *testing.T) {
+	parts := []map[string]float64{
+		{"a": 1, "b": 5},
+		{"a": 3, "c": 2},
+		{"b": 1, "c": 2},
+	}
+	expected := map[string]map[string]float64{
+		"sum": {"a": 4, "b": 6, "c": 4},
+		"max": {"a": 3, "b": 5, "c": 2},
+		"min": {"a": 1, "b": 1, "c": 2},
+	}
+	for aggr, exp := range expected {
+		if err := lib.CheckMergeable(aggr); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		hist := make(map[string]float64)
+		for _, part := range parts {
+			for name, value := range part {
+				lib.MergeHistValue(hist, aggr, name, value)
+			}
+		}
+		if !reflect.DeepEqual(hist, exp) {
+			t.Errorf("%s: expected %v, got %v", aggr, exp, hist)
+		}
+	}
+	if lib.CheckMergeable("avg") == nil {
+		t.Errorf("expected error for 'avg' aggregate")
+	}
+	rows := lib.SortedHistRows(expected["sum"])
+	exp := []lib.HistRow{{Name: "b", Value: 6}, {Name: "a", Value: 4}, {Name: "c", Value: 4}}
+	if !reflect.DeepEqual(rows, exp) {
+		t.Errorf("expected %v, got %v", exp, rows)
+	}
+}
+
+func TestHistDayBuckets(t *testing.T) {
+	ft := func(d, h int) time.Time { return time.Date(2021, 3, d, h, 0, 0, 0, time.UTC) }
+	synced := ft(10, 0)
+	var testCases = []struct {
+		from, to         time.Time
+		complete         time.Time
+		days             int
+		headTo, tailFrom time.Time
+	}{
+		{from: ft(1, 0), to: ft(4, 0), complete: synced, days: 3, headTo: ft(1, 0), tailFrom: ft(4, 0)},
+		{from: ft(1, 5), to: ft(4, 7), complete: synced, days: 2, headTo: ft(2, 0), tailFrom: ft(4, 0)},
+		{from: ft(1, 5), to: ft(2, 7), complete: synced, days: 0, headTo: ft(2, 7), tailFrom: ft(2, 7)},
+		{from: ft(1, 5), to: ft(1, 7), complete: synced, days: 0, headTo: ft(1, 7), tailFrom: ft(1, 7)},
+		// Day 3 ends exactly when data is complete, day 4 is not complete yet
+		{from: ft(1, 0), to: ft(6, 0), complete: ft(4, 0), days: 3, headTo: ft(1, 0), tailFrom: ft(4, 0)},
+		// Day 3 is missing its last hour
+		{from: ft(1, 0), to: ft(6, 0), complete: ft(3, 23), days: 2, headTo: ft(1, 0), tailFrom: ft(3, 0)},
+		// Nothing synced yet
+		{from: ft(1, 0), to: ft(6, 0), complete: time.Time{}, days: 0, headTo: ft(6, 0), tailFrom: ft(6, 0)},
+	}
+	for index, test := range testCases {
+		days, headTo, tailFrom := lib.HistDayBuckets(test.from, test.to, test.complete)
+		if len(days) != test.days || !headTo.Equal(test.headTo) || !tailFrom.Equal(test.tailFrom) {
+			t.Errorf(
+				"test number %d, expected %d days, %v, %v, got %v, %v, %v",
+				index+1, test.days, test.headTo, test.tailFrom, days, headTo, tailFrom,
+			)
+		}
+		if len(days) > 0 && (!days[0].Equal(headTo) || !days[len(days)-1].Add(24*time.Hour).Equal(tailFrom)) {
+			t.Errorf("test number %d, days %v do not cover %v - %v", index+1, days, headTo, tailFrom)
+		}
+	}
+}
+
+func TestHistCompleteBefore(t *testing.T) {
+	ft := func(d, h, m int) time.Time { return time.Date(2021, 3, d, h, m, 0, 0, time.UTC) }
+	var testCases = []struct {
+		synced   time.Time
+		lag      int
+		expected time.Time
+	}{
+		{synced: ft(4, 1, 30), lag: 0, expected: ft(4, 1, 0)},
+		{synced: ft(4, 1, 30), lag: 2, expected: ft(3, 23, 0)},
+		{synced: ft(4, 2, 0), lag: 2, expected: ft(4, 0, 0)},
+	}
+	for index, test := range testCases {
+		got := lib.HistCompleteBefore(test.synced, test.lag)
+		if !got.Equal(test.expected) {
+			t.Errorf("test number %d, expected %v, got %v", index+1, test.expected, got)
+		}
+	}
+}
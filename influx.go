@@ -0,0 +1,130 @@
+package devstatscode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxLine - returns TS point in InfluxDB line protocol (without trailing new line)
+// Measurement is the series table name ("s" + name), or "s" + mergeSeries with series name in "series" tag
+// Period is stored in "period" tag, string fields are kept as string fields, time fields become unix seconds integers
+// Returns empty string when point has no fields (such point is not valid in line protocol)
+func InfluxLine(pt *TSPoint, mergeSeries string) string {
+	measurementEscaper := strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	keyEscaper := strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+	stringEscaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	measurement := "s" + pt.name
+	tags := make(map[string]string)
+	for k, v := range pt.tags {
+		tags[k] = v
+	}
+	if mergeSeries != "" {
+		measurement = "s" + mergeSeries
+		tags["series"] = pt.name
+	}
+	if pt.period != "" {
+		tags["period"] = pt.period
+	}
+	fields := []string{}
+	for k, v := range pt.fields {
+		key := keyEscaper.Replace(k)
+		switch value := v.(type) {
+		case float64:
+			fields = append(fields, key+"="+strconv.FormatFloat(value, 'g', -1, 64))
+		case time.Time:
+			fields = append(fields, key+"="+strconv.FormatInt(value.Unix(), 10)+"i")
+		case string:
+			fields = append(fields, key+"=\""+stringEscaper.Replace(value)+"\"")
+		default:
+			fields = append(fields, key+"=\""+stringEscaper.Replace(fmt.Sprintf("%v", value))+"\"")
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	sort.Strings(fields)
+	tagNames := []string{}
+	for k := range tags {
+		tagNames = append(tagNames, k)
+	}
+	sort.Strings(tagNames)
+	line := measurementEscaper.Replace(measurement)
+	for _, k := range tagNames {
+		// Empty tag values are not allowed in line protocol
+		if tags[k] == "" {
+			continue
+		}
+		line += "," + keyEscaper.Replace(k) + "=" + keyEscaper.Replace(tags[k])
+	}
+	return line + " " + strings.Join(fields, ",") + " " + strconv.FormatInt(pt.t.UnixNano(), 10)
+}
+
+// WriteInfluxLines - writes TS points in InfluxDB line protocol
+func WriteInfluxLines(w io.Writer, pts *TSPoints, mergeSeries string) (n int, err error) {
+	for i := range *pts {
+		line := InfluxLine(&(*pts)[i], mergeSeries)
+		if line == "" {
+			continue
+		}
+		_, err = fmt.Fprintf(w, "%s\n", line)
+		if err != nil {
+			return
+		}
+		n++
+	}
+	return
+}
+
+// InfluxHTTPWrite - sends line protocol data to InfluxDB write endpoint
+func InfluxHTTPWrite(ctx *Ctx, data []byte) error {
+	req, err := http.NewRequest("POST", ctx.InfluxURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	client := &http.Client{Timeout: time.Minute * time.Duration(ctx.HTTPTimeout)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("influx write to %s failed: %s: %s", ctx.InfluxURL, resp.Status, string(msg))
+	}
+	return nil
+}
+
+// WriteInfluxPoints - writes TS points to InfluxDB
+// It uses HTTP write endpoint when ctx.InfluxURL is set, otherwise writes line protocol file in ctx.InfluxDir
+func WriteInfluxPoints(ctx *Ctx, pts *TSPoints, mergeSeries string) {
+	var buf bytes.Buffer
+	n, err := WriteInfluxLines(&buf, pts, mergeSeries)
+	FatalOnError(err)
+	if ctx.Debug > 0 {
+		Printf("WriteInfluxPoints: writing %d lines from %d points\n", n, len(*pts))
+	}
+	if n == 0 {
+		return
+	}
+	if ctx.InfluxURL != "" {
+		FatalOnError(InfluxHTTPWrite(ctx, buf.Bytes()))
+		return
+	}
+	name := mergeSeries
+	if name == "" {
+		name = (*pts)[0].name
+	}
+	fn := fmt.Sprintf("%s%s_s%s_%d.lp", ctx.InfluxDir, ctx.Project, name, time.Now().UnixNano())
+	FatalOnError(ioutil.WriteFile(fn, buf.Bytes(), 0644))
+	if ctx.Debug > 0 {
+		Printf("WriteInfluxPoints: written %s\n", fn)
+	}
+}
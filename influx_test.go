@@ -0,0 +1,62 @@
+package devstatscode
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestWriteInfluxLines(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	dt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	pts := lib.TSPoints{}
+	lib.AddTSPoint(
+		&ctx,
+		&pts,
+		lib.NewTSPoint(
+			&ctx,
+			"prs_age",
+			"w",
+			map[string]string{"repo group": "a,b"},
+			map[string]interface{}{
+				"value": 1.5,
+				"descr": "say \"hi\"",
+				"dt":    time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC),
+			},
+			dt,
+			false,
+		),
+	)
+	lib.AddTSPoint(&ctx, &pts, lib.NewTSPoint(&ctx, "empty", "", nil, nil, dt, false))
+
+	var testCases = []struct {
+		mergeSeries string
+		expected    string
+	}{
+		{
+			mergeSeries: "",
+			expected: "sprs_age,period=w,repo\\ group=a\\,b " +
+				"descr=\"say \\\"hi\\\"\",dt=1614643200i,value=1.5 1614556800000000000\n",
+		},
+		{
+			mergeSeries: "all",
+			expected: "sall,period=w,repo\\ group=a\\,b,series=prs_age " +
+				"descr=\"say \\\"hi\\\"\",dt=1614643200i,value=1.5 1614556800000000000\n",
+		},
+	}
+	for index, test := range testCases {
+		var buf bytes.Buffer
+		n, err := lib.WriteInfluxLines(&buf, &pts, test.mergeSeries)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 1 || buf.String() != test.expected {
+			t.Errorf("test number %d, expected 1 line:\n%s\ngot %d:\n%s", index+1, test.expected, n, buf.String())
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package devstatscode
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// metricFuncs - series_name_or_func values that are not series names, but calc_metric row naming functions
+var metricFuncs = map[string]struct{}{
+	"single_row_multi_column": {},
+	"multi_row_single_column": {},
+	"multi_row_multi_column":  {},
+}
+
+// varTypes - allowed gha_vars value types (value_i, value_f, value_s, value_dt columns)
+var varTypes = map[string]struct{}{"i": {}, "f": {}, "s": {}, "dt": {}}
+
+// CheckPeriods - checks metrics.yaml periods syntax: comma separated list of h, d, w, m, q, y
+// optionally followed by a number, for example "d,w,m" or "d7,w"
+func CheckPeriods(periods string) error {
+	if strings.TrimSpace(periods) == "" {
+		return fmt.Errorf("empty periods")
+	}
+	for _, period := range strings.Split(periods, ",") {
+		if period == "" {
+			return fmt.Errorf("empty period in '%s'", periods)
+		}
+		if !strings.Contains("hdwmqy", period[0:1]) {
+			return fmt.Errorf("unknown period '%s' in '%s'", period, periods)
+		}
+		if len(period) > 1 {
+			n, err := strconv.Atoi(period[1:])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid period '%s' in '%s'", period, periods)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckAggregate - checks metrics.yaml aggregate syntax: comma separated list of positive integers
+// empty aggregate is allowed (it means "1")
+func CheckAggregate(aggregate string) error {
+	if aggregate == "" {
+		return nil
+	}
+	for _, aggr := range strings.Split(aggregate, ",") {
+		n, err := strconv.Atoi(aggr)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid aggregate '%s' in '%s'", aggr, aggregate)
+		}
+	}
+	return nil
+}
+
+// sqlFileExists - checks if SQL file exists in a given directory (using the same /shared/ fallback as ReadFile)
+func sqlFileExists(ctx *Ctx, dir, sqlFile string) bool {
+	_, err := ReadFile(ctx, dir+sqlFile+".sql")
+	return err == nil
+}
+
+// LintMetrics - validates metrics.yaml data, returns list of problems found
+// dir is the per project SQL directory, for example "metrics/kubernetes/"
+// When file includes other files, its metrics are only checked after resolving includes (see LintMetricsFile)
+func LintMetrics(ctx *Ctx, data []byte, dir string) (problems []string) {
+	var allMetrics AllMetrics
+	err := yaml.UnmarshalStrict(data, &allMetrics)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("strict decode: %v", err))
+		allMetrics = AllMetrics{}
+		err = yaml.Unmarshal(data, &allMetrics)
+		if err != nil {
+			return
+		}
+	}
+	err = CheckRetentionRules(allMetrics.Retention)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	names := make(map[string]struct{})
+	for _, metric := range allMetrics.Metrics {
+		names[metric.Name] = struct{}{}
+	}
+	for i, override := range allMetrics.Overrides {
+		prefix := fmt.Sprintf("override #%d '%s'", i+1, override.Name)
+		if override.Name == "" {
+			problems = append(problems, prefix+": missing name")
+			continue
+		}
+		// Overridden metric can be defined in included files
+		if _, ok := names[override.Name]; !ok && len(allMetrics.Include) == 0 {
+			problems = append(problems, prefix+": unknown metric")
+		}
+		if override.Periods != nil {
+			err = CheckPeriods(*override.Periods)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+			}
+		}
+	}
+	if len(allMetrics.Include) == 0 {
+		problems = append(problems, LintMetricsList(ctx, allMetrics.Metrics, dir)...)
+	}
+	return
+}
+
+// LintMetricsFile - validates metrics.yaml file, returns list of problems found
+// Includes are resolved and overrides applied, then all resulting metrics are checked (also the ones from included files)
+func LintMetricsFile(ctx *Ctx, path, dir string) (problems []string) {
+	data, err := ReadFile(ctx, path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	problems = LintMetrics(ctx, data, dir)
+	// Included files are strict decoded too, so typos in shared files are reported with their paths
+	problems = append(problems, lintMetricsIncludes(ctx, path, data, map[string]struct{}{path: {}})...)
+	// Includes must resolve and overrides must match merged metrics
+	allMetrics, err := ReadMetrics(ctx, path)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	err = CheckRetentionSeries(ctx, allMetrics)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	var own AllMetrics
+	if yaml.Unmarshal(data, &own) == nil && len(own.Include) > 0 {
+		for _, problem := range LintMetricsList(ctx, allMetrics.Metrics, dir) {
+			problems = append(problems, "resolved "+problem)
+		}
+	}
+	return
+}
+
+// lintMetricsIncludes - strict decodes all files included (recursively) by metrics file data read from path
+// Files that cannot be read and include cycles are reported by ReadMetrics
+func lintMetricsIncludes(ctx *Ctx, path string, data []byte, visited map[string]struct{}) (problems []string) {
+	var own AllMetrics
+	if yaml.Unmarshal(data, &own) != nil {
+		return
+	}
+	for _, inc := range own.Include {
+		inc = metricsIncludePath(path, inc)
+		if _, ok := visited[inc]; ok {
+			continue
+		}
+		visited[inc] = struct{}{}
+		incData, err := ReadFile(ctx, inc)
+		if err != nil {
+			continue
+		}
+		var included AllMetrics
+		err = yaml.UnmarshalStrict(incData, &included)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: strict decode: %v", inc, err))
+		}
+		problems = append(problems, lintMetricsIncludes(ctx, inc, incData, visited)...)
+	}
+	return
+}
+
+// LintMetricsList - validates metrics definitions, returns list of problems found
+func LintMetricsList(ctx *Ctx, metrics []Metric, dir string) (problems []string) {
+	names := make(map[string]struct{})
+	for _, metric := range metrics {
+		names[metric.Name] = struct{}{}
+	}
+	var err error
+	for i, metric := range metrics {
+		if ExcludedForProject(ctx.Project, metric.Project) {
+			continue
+		}
+		prefix := fmt.Sprintf("metric #%d '%s'", i+1, metric.Name)
+		if metric.Name == "" {
+			problems = append(problems, prefix+": missing name")
+		}
+		sqls := []string{}
+		if metric.MetricSQL != "" {
+			sqls = append(sqls, metric.MetricSQL)
+		}
+		if metric.MetricSQLs != nil {
+			if metric.MetricSQL != "" {
+				problems = append(problems, prefix+": you cannot use both 'sql' and 'sqls' fields")
+			}
+			sqls = append(sqls, *metric.MetricSQLs...)
+		}
+		if len(sqls) == 0 {
+			problems = append(problems, prefix+": missing 'sql' or 'sqls'")
+		}
+		for _, sqlFile := range sqls {
+			if !sqlFileExists(ctx, dir, sqlFile) {
+				problems = append(problems, fmt.Sprintf("%s: SQL file '%s%s.sql' not found", prefix, dir, sqlFile))
+			}
+		}
+		if metric.Histogram && metric.Drop != "" {
+			problems = append(problems, prefix+": you cannot use drop series property on histogram metrics")
+		}
+		if metric.Mergeable != "" {
+			if !metric.Histogram {
+				problems = append(problems, prefix+": 'mergeable' can only be used on histogram metrics")
+			}
+			err = CheckMergeable(metric.Mergeable)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+			}
+		}
+		if metric.Desc != "" {
+			err = CheckValueDescription(metric.Desc)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+			}
+		}
+		if metric.StartFrom != nil && metric.LastHours > 0 {
+			problems = append(problems, prefix+": you cannot use both 'start_from' and 'last_hours'")
+		}
+		periods := []string{}
+		if !metric.AnnotationsRanges {
+			err = CheckPeriods(metric.Periods)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+			} else {
+				periods = strings.Split(metric.Periods, ",")
+			}
+			err = CheckAggregate(metric.Aggregate)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", prefix, err))
+			}
+		}
+		if metric.SeriesNameOrFunc == "" {
+			problems = append(problems, prefix+": missing 'series_name_or_func'")
+		} else if _, ok := metricFuncs[metric.SeriesNameOrFunc]; !ok {
+			names := []string{metric.SeriesNameOrFunc}
+			if metric.AddPeriodToName {
+				aggregate := metric.Aggregate
+				if aggregate == "" || CheckAggregate(aggregate) != nil {
+					aggregate = "1"
+				}
+				names = []string{}
+				for _, aggr := range strings.Split(aggregate, ",") {
+					if aggr == "1" {
+						aggr = ""
+					}
+					for _, period := range periods {
+						names = append(names, metric.SeriesNameOrFunc+"_"+period+aggr)
+					}
+				}
+			}
+			for _, name := range names {
+				if !checkPsqlName("s" + name) {
+					problems = append(problems, fmt.Sprintf("%s: series name '%s' is not a valid postgres table name", prefix, name))
+				}
+			}
+		}
+		if metric.MergeSeries != "" && !checkPsqlName("s"+metric.MergeSeries) {
+			problems = append(problems, fmt.Sprintf("%s: merge series name '%s' is not a valid postgres table name", prefix, metric.MergeSeries))
+		}
+		for _, dep := range metric.DependsOn {
+			if dep == metric.Name {
+				problems = append(problems, prefix+": metric cannot depend on itself")
+			} else if _, ok := names[dep]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: depends on unknown metric '%s'", prefix, dep))
+			}
+		}
+		if metric.Weight < 0 {
+			problems = append(problems, fmt.Sprintf("%s: negative weight %d", prefix, metric.Weight))
+		}
+	}
+	return
+}
+
+// LintTags - validates tags.yaml data, returns list of problems found
+func LintTags(ctx *Ctx, data []byte, dir string) (problems []string) {
+	var allTags Tags
+	err := yaml.UnmarshalStrict(data, &allTags)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("strict decode: %v", err))
+		allTags = Tags{}
+		err = yaml.Unmarshal(data, &allTags)
+		if err != nil {
+			return
+		}
+	}
+	for i, tag := range allTags.Tags {
+		prefix := fmt.Sprintf("tag #%d '%s'", i+1, tag.Name)
+		if tag.Name == "" {
+			problems = append(problems, prefix+": missing name")
+		}
+		if tag.SQLFile == "" {
+			problems = append(problems, prefix+": missing 'sql'")
+		} else if !sqlFileExists(ctx, dir, tag.SQLFile) {
+			problems = append(problems, fmt.Sprintf("%s: SQL file '%s%s.sql' not found", prefix, dir, tag.SQLFile))
+		}
+		if tag.SeriesName == "" {
+			problems = append(problems, prefix+": missing 'series_name'")
+		} else if !checkPsqlName("t" + tag.SeriesName) {
+			problems = append(problems, fmt.Sprintf("%s: series name '%s' is not a valid postgres table name", prefix, tag.SeriesName))
+		}
+	}
+	return
+}
+
+// LintColumns - validates columns.yaml data, returns list of problems found
+func LintColumns(data []byte) (problems []string) {
+	var allColumns Columns
+	err := yaml.UnmarshalStrict(data, &allColumns)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("strict decode: %v", err))
+		allColumns = Columns{}
+		err = yaml.Unmarshal(data, &allColumns)
+		if err != nil {
+			return
+		}
+	}
+	for i, col := range allColumns.Columns {
+		prefix := fmt.Sprintf("column #%d '%s'", i+1, col.TableRegexp)
+		if col.TableRegexp == "" {
+			problems = append(problems, prefix+": missing 'table_regexp'")
+		} else if _, err := regexp.Compile(col.TableRegexp); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid 'table_regexp': %v", prefix, err))
+		}
+		if col.Tag == "" {
+			problems = append(problems, prefix+": missing 'tag'")
+		}
+		if col.Column == "" {
+			problems = append(problems, prefix+": missing 'column'")
+		}
+	}
+	return
+}
+
+// LintVars - validates vars.yaml data, returns list of problems found
+func LintVars(data []byte) (problems []string) {
+	var allVars Vars
+	err := yaml.UnmarshalStrict(data, &allVars)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("strict decode: %v", err))
+		allVars = Vars{}
+		err = yaml.Unmarshal(data, &allVars)
+		if err != nil {
+			return
+		}
+	}
+	for i, va := range allVars.Vars {
+		prefix := fmt.Sprintf("var #%d '%s'", i+1, va.Name)
+		if va.Name == "" {
+			problems = append(problems, prefix+": missing name")
+		} else if len(va.Name) > 100 {
+			problems = append(problems, prefix+": name too long")
+		}
+		if _, ok := varTypes[va.Type]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown type '%s'", prefix, va.Type))
+		}
+		if va.Value == "" && len(va.Command) == 0 {
+			problems = append(problems, prefix+": missing 'value' or 'command'")
+		}
+		for _, replace := range va.Replaces {
+			if len(replace) != 2 {
+				problems = append(problems, fmt.Sprintf("%s: replace %v must have exactly 2 items", prefix, replace))
+			}
+		}
+		for _, query := range va.Queries {
+			if len(query) < 3 {
+				problems = append(problems, fmt.Sprintf("%s: query %v must have name, SQL and at least one result column", prefix, query))
+			}
+		}
+		for _, loop := range va.Loops {
+			if len(loop) != 4 {
+				problems = append(problems, fmt.Sprintf("%s: loop %v must have exactly 4 items", prefix, loop))
+			} else if loop[3] <= 0 {
+				problems = append(problems, fmt.Sprintf("%s: loop %v must have a positive increment", prefix, loop))
+			}
+		}
+	}
+	return
+}
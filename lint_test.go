@@ -0,0 +1,191 @@
+package devstatscode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestCheckPeriods(t *testing.T) {
+	// Test cases
+	var testCases = []struct {
+		periods string
+		ok      bool
+	}{
+		{periods: "d", ok: true},
+		{periods: "h,d,w,m,q,y", ok: true},
+		{periods: "d7,w", ok: true},
+		{periods: "", ok: false},
+		{periods: "d,,w", ok: false},
+		{periods: "x", ok: false},
+		{periods: "d,week", ok: false},
+		{periods: "d0", ok: false},
+	}
+	// Execute test cases
+	for index, test := range testCases {
+		err := lib.CheckPeriods(test.periods)
+		if (err == nil) != test.ok {
+			t.Errorf(
+				"test number %d, periods '%s', expected ok: %v, got error: %v",
+				index+1, test.periods, test.ok, err,
+			)
+		}
+	}
+}
+
+func TestCheckAggregate(t *testing.T) {
+	// Test cases
+	var testCases = []struct {
+		aggregate string
+		ok        bool
+	}{
+		{aggregate: "", ok: true},
+		{aggregate: "1", ok: true},
+		{aggregate: "1,7,24", ok: true},
+		{aggregate: "0", ok: false},
+		{aggregate: "1,", ok: false},
+		{aggregate: "a", ok: false},
+		{aggregate: "-7", ok: false},
+	}
+	// Execute test cases
+	for index, test := range testCases {
+		err := lib.CheckAggregate(test.aggregate)
+		if (err == nil) != test.ok {
+			t.Errorf(
+				"test number %d, aggregate '%s', expected ok: %v, got error: %v",
+				index+1, test.aggregate, test.ok, err,
+			)
+		}
+	}
+}
+
+func TestLintMetrics(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	// Test cases
+	var testCases = []struct {
+		data     string
+		problems int
+	}{
+		{
+			data:     "metrics:\n- name: A\n  series_name_or_func: multi_row_single_column\n  periods: d,w\n  aggregate: 1,7\n",
+			problems: 1,
+		},
+		{
+			data:     "metrics:\n- name: A\n  sql: a\n  series_name_or_func: a\n  periods: d\n  sync_probabilty: 1\n",
+			problems: 2,
+		},
+		{
+			data:     "metrics:\n- name: A\n  sql: a\n  series_name_or_func: a\n  add_period_to_name: true\n  periods: d,x\n  aggregate: 1,0\n",
+			problems: 3,
+		},
+		{
+			data:     "metrics:\n- name: A\n  sql: a\n  sqls: [b]\n  series_name_or_func: a\n  annotations_ranges: true\n  histogram: true\n  drop: sa\n",
+			problems: 4,
+		},
+		{
+			data:     "metrics:\n- sql: a\n  project: other\n",
+			problems: 0,
+		},
+		{
+			data:     "metrics:\n- name: A\n  series_name_or_func: multi_row_single_column\n  periods: d\n  depends_on: [A, B, C]\n  weight: -1\n- name: B\n  project: other\n",
+			problems: 4,
+		},
+		{
+			data:     "metrics:\n- name: A\n  series_name_or_func: multi_row_single_column\n  periods: d\n  mergeable: avg\n",
+			problems: 3,
+		},
+		{
+			data:     "metrics:\n- sql: a\n  project: other\nretention:\n- period: h\n",
+			problems: 1,
+		},
+		{
+			data:     "metrics:\n- sql: a\n  name: A\n  project: other\noverrides:\n- name: A\n  periods: d\n- name: B\n- periods: x\n",
+			problems: 2,
+		},
+		{
+			data:     "include: [shared.yaml]\noverrides:\n- name: B\n  periods: x\n",
+			problems: 1,
+		},
+		{
+			data:     "metrics:\n- name: A\n  series_name_or_func: multi_row_single_column\n  periods: d\n  desc: time_diff_as_string\n- name: B\n  series_name_or_func: multi_row_single_column\n  periods: d\n  desc: unknown_func\n",
+			problems: 3,
+		},
+	}
+	// Execute test cases
+	ctx.Project = "test"
+	for index, test := range testCases {
+		problems := lib.LintMetrics(&ctx, []byte(test.data), "/nonexistent/test/")
+		if len(problems) != test.problems {
+			t.Errorf(
+				"test number %d, expected %d problems, got %d: %v",
+				index+1, test.problems, len(problems), problems,
+			)
+		}
+	}
+}
+
+func TestLintMetricsFile(t *testing.T) {
+	var ctx lib.Ctx
+	ctx.Init()
+	ctx.Project = "test"
+	shared := "metrics:\n" +
+		"- name: A\n  series_name_or_func: multi_row_single_column\n  periods: d,x\n  aggregate: 0\n" +
+		"- name: B\n  series_name_or_func: multi_row_single_column\n  periods: d\n  desc: unknown_func\n  mergeable: sum\n"
+	var testCases = []struct {
+		main     string
+		problems int
+	}{
+		// Each of 2 included metrics has a missing SQL, A has bad periods and aggregate, B has bad desc and mergeable
+		{main: "include: [shared.yaml]\n", problems: 6},
+		// Override fixes A periods, own metric C is checked once
+		{
+			main:     "include: [shared.yaml]\nmetrics:\n- name: C\n  series_name_or_func: multi_row_single_column\n  periods: y\noverrides:\n- name: A\n  periods: d\n",
+			problems: 6,
+		},
+		// Own metrics of a file without includes are not reported twice
+		{main: "metrics:\n- name: C\n  series_name_or_func: multi_row_single_column\n  periods: z\n", problems: 2},
+		{main: "include: [missing.yaml]\n", problems: 1},
+		// Unknown keys in (nested) included files are reported with their paths, D also has a missing SQL file
+		{main: "include: [typo.yaml]\n", problems: 8},
+		{main: "include: [nested.yaml]\n", problems: 8},
+	}
+	typo := shared + "- name: D\n  series_name_or_func: d\n  periods: d\n  sql: d\n  perods: w\n"
+	for index, test := range testCases {
+		dir := writeMetricsFiles(
+			t,
+			map[string]string{"shared.yaml": shared, "typo.yaml": typo, "nested.yaml": "include: [typo.yaml]\n", "main.yaml": test.main},
+		)
+		problems := lib.LintMetricsFile(&ctx, filepath.Join(dir, "main.yaml"), "/nonexistent/test/")
+		_ = os.RemoveAll(dir)
+		if len(problems) != test.problems {
+			t.Errorf("test number %d, expected %d problems, got %d: %v", index+1, test.problems, len(problems), problems)
+		}
+	}
+}
+
+func TestLintColumnsAndVars(t *testing.T) {
+	// Columns
+	problems := lib.LintColumns([]byte("columns:\n- table_regexp: '^s(a|b'\n  tag: t\n  column: c\n  tags: x\n"))
+	if len(problems) != 2 {
+		t.Errorf("expected 2 columns problems, got %d: %v", len(problems), problems)
+	}
+	problems = lib.LintColumns([]byte("columns:\n- table_regexp: '^s(a|b)$'\n  tag: t\n  column: c\n"))
+	if len(problems) != 0 {
+		t.Errorf("expected no columns problems, got %d: %v", len(problems), problems)
+	}
+
+	// Vars
+	problems = lib.LintVars([]byte("vars:\n- name: v\n  type: x\n  loops: [[1, 0, 10]]\n"))
+	if len(problems) != 3 {
+		t.Errorf("expected 3 vars problems, got %d: %v", len(problems), problems)
+	}
+	problems = lib.LintVars([]byte("vars:\n- name: v\n  type: s\n  command: [echo]\n  replaces: [[a, b]]\n"))
+	if len(problems) != 0 {
+		t.Errorf("expected no vars problems, got %d: %v", len(problems), problems)
+	}
+}
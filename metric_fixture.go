@@ -0,0 +1,181 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MetricFixture contains a single metric test: input gha_* rows and metric calculation parameters
+// It is stored in metrics/{project}/tests/name.yaml, expected output is in metrics/{project}/tests/name.golden.yaml
+// When Metric is set, SQL, series name and options are taken from metrics.yaml entry with this name
+type MetricFixture struct {
+	Metric           string                              `yaml:"metric"`
+	SeriesNameOrFunc string                              `yaml:"series_name_or_func"`
+	SQL              string                              `yaml:"sql"`
+	Options          string                              `yaml:"options"`
+	From             string                              `yaml:"from"`
+	To               string                              `yaml:"to"`
+	Period           string                              `yaml:"period"`
+	Tables           map[string][]map[string]interface{} `yaml:"tables"`
+}
+
+// SeriesRows - TSDB tables contents: table name -> rows, each row is column name -> value as text
+type SeriesRows map[string][]map[string]string
+
+// InsertFixtureRows - inserts fixture rows into given tables
+func InsertFixtureRows(con *sql.DB, ctx *Ctx, tables map[string][]map[string]interface{}) {
+	for table, rows := range tables {
+		for _, row := range rows {
+			cols := []string{}
+			for col := range row {
+				cols = append(cols, col)
+			}
+			sort.Strings(cols)
+			args := []interface{}{}
+			quoted := []string{}
+			for _, col := range cols {
+				args = append(args, row[col])
+				quoted = append(quoted, "\""+escapeName(col)+"\"")
+			}
+			ExecSQLWithErr(
+				con,
+				ctx,
+				"insert into \""+escapeName(table)+"\"("+strings.Join(quoted, ", ")+") "+NValues(len(cols)),
+				args...,
+			)
+		}
+	}
+}
+
+// GetSeriesTables - returns names of all TSDB tables (s* series and t* tags tables, without tseries_catalog)
+func GetSeriesTables(con *sql.DB, ctx *Ctx) []string {
+	tables, err := seriesTables(con, ctx)
+	FatalOnError(err)
+	return tables
+}
+
+// seriesTables - returns all TSDB tables, returns error instead of failing
+func seriesTables(con *sql.DB, ctx *Ctx) (tables []string, err error) {
+	rows, err := QuerySQL(
+		con,
+		ctx,
+		"select tablename from pg_tables where schemaname = 'public' and "+
+			"(tablename like 's%' or tablename like 't%') and tablename <> 'tseries_catalog' order by tablename",
+	)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	table := ""
+	for rows.Next() {
+		err = rows.Scan(&table)
+		if err != nil {
+			return
+		}
+		tables = append(tables, table)
+	}
+	err = rows.Err()
+	return
+}
+
+// DumpSeriesRows - returns contents of given TSDB tables, rows are sorted so the result is deterministic
+func DumpSeriesRows(con *sql.DB, ctx *Ctx, tables []string) SeriesRows {
+	result := make(SeriesRows)
+	for _, table := range tables {
+		rows := QuerySQLWithErr(con, ctx, "select * from \""+escapeName(table)+"\"")
+		columns, err := rows.Columns()
+		FatalOnError(err)
+		vals := make([]interface{}, len(columns))
+		for i := range columns {
+			vals[i] = new(sql.RawBytes)
+		}
+		data := []map[string]string{}
+		for rows.Next() {
+			FatalOnError(rows.Scan(vals...))
+			row := make(map[string]string)
+			for i, col := range columns {
+				raw := *vals[i].(*sql.RawBytes)
+				if raw == nil {
+					row[col] = Nil
+				} else {
+					row[col] = string(raw)
+				}
+			}
+			data = append(data, row)
+		}
+		FatalOnError(rows.Err())
+		FatalOnError(rows.Close())
+		sort.Slice(data, func(i, j int) bool { return seriesRowKey(data[i]) < seriesRowKey(data[j]) })
+		result[table] = data
+	}
+	return result
+}
+
+// seriesRowKey - returns row's string representation (columns sorted by name)
+func seriesRowKey(row map[string]string) string {
+	cols := []string{}
+	for col := range row {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	ary := []string{}
+	for _, col := range cols {
+		ary = append(ary, col+"="+row[col])
+	}
+	return strings.Join(ary, ", ")
+}
+
+// DiffSeriesRows - compares expected and actual TSDB tables contents, returns list of differences
+func DiffSeriesRows(expected, got SeriesRows) (diffs []string) {
+	tables := make(map[string]struct{})
+	for table := range expected {
+		tables[table] = struct{}{}
+	}
+	for table := range got {
+		tables[table] = struct{}{}
+	}
+	sortedTables := []string{}
+	for table := range tables {
+		sortedTables = append(sortedTables, table)
+	}
+	sort.Strings(sortedTables)
+	for _, table := range sortedTables {
+		expRows, expOK := expected[table]
+		gotRows, gotOK := got[table]
+		if !expOK {
+			diffs = append(diffs, fmt.Sprintf("unexpected table %s (%d rows)", table, len(gotRows)))
+			continue
+		}
+		if !gotOK {
+			diffs = append(diffs, fmt.Sprintf("missing table %s (%d rows)", table, len(expRows)))
+			continue
+		}
+		expKeys := make(map[string]int)
+		for _, row := range expRows {
+			expKeys[seriesRowKey(row)]++
+		}
+		gotKeys := make(map[string]int)
+		for _, row := range gotRows {
+			gotKeys[seriesRowKey(row)]++
+		}
+		for _, row := range expRows {
+			key := seriesRowKey(row)
+			if gotKeys[key] > 0 {
+				gotKeys[key]--
+				continue
+			}
+			diffs = append(diffs, fmt.Sprintf("%s: - %s", table, key))
+		}
+		for _, row := range gotRows {
+			key := seriesRowKey(row)
+			if expKeys[key] > 0 {
+				expKeys[key]--
+				continue
+			}
+			diffs = append(diffs, fmt.Sprintf("%s: + %s", table, key))
+		}
+	}
+	return
+}
@@ -0,0 +1,46 @@
+package devstatscode
+
+import (
+	"reflect"
+	"testing"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestDiffSeriesRows(t *testing.T) {
+	// Test cases
+	var testCases = []struct {
+		expected lib.SeriesRows
+		got      lib.SeriesRows
+		diffs    []string
+	}{
+		{
+			expected: lib.SeriesRows{},
+			got:      lib.SeriesRows{},
+		},
+		{
+			expected: lib.SeriesRows{"sa": {{"time": "2020-01-01", "value": "1"}}},
+			got:      lib.SeriesRows{"sa": {{"value": "1", "time": "2020-01-01"}}},
+		},
+		{
+			expected: lib.SeriesRows{"sa": {{"period": "d", "value": "1"}, {"period": "d", "value": "1"}}},
+			got:      lib.SeriesRows{"sa": {{"period": "d", "value": "1"}, {"period": "w", "value": "2"}}},
+			diffs:    []string{"sa: - period=d, value=1", "sa: + period=w, value=2"},
+		},
+		{
+			expected: lib.SeriesRows{"sa": {}, "tb": {{"x": "1"}}},
+			got:      lib.SeriesRows{"sa": {}, "sc": {{"x": "1"}, {"x": "2"}}},
+			diffs:    []string{"unexpected table sc (2 rows)", "missing table tb (1 rows)"},
+		},
+	}
+	// Execute test cases
+	for index, test := range testCases {
+		diffs := lib.DiffSeriesRows(test.expected, test.got)
+		if !reflect.DeepEqual(diffs, test.diffs) {
+			t.Errorf(
+				"test number %d, expected %v, got %v",
+				index+1, test.diffs, diffs,
+			)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package devstatscode
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AllMetrics contain list of metrics to evaluate and TSDB retention rules
+// Include lists shared metrics definition files (paths relative to the including file),
+// Overrides change selected properties of metrics (see ReadMetrics for merge rules)
+type AllMetrics struct {
+	Metrics   []Metric         `yaml:"metrics"`
+	Retention []RetentionRule  `yaml:"retention"`
+	Include   []string         `yaml:"include"`
+	Overrides []MetricOverride `yaml:"overrides"`
+}
+
+// MetricOverride - overrides properties of all metrics with a given name
+// Project uses the same syntax as metric's project (XYZ or !XYZ), so shared files can define per project overrides
+type MetricOverride struct {
+	Name     string            `yaml:"name"`
+	Project  string            `yaml:"project"`
+	Periods  *string           `yaml:"periods"`
+	Skip     *string           `yaml:"skip"`
+	Env      map[string]string `yaml:"env"`
+	Disabled *bool             `yaml:"disabled"`
+}
+
+// Metric contain each metric data
+// some metrics can be allowed to fail
+// DependsOn lists names of metrics that must be calculated first (they write series this metric reads)
+// Weight is the part of gha2db_sync concurrency budget used by this metric, default: entire budget for
+// non-histogram metrics (calc_metric uses all CPUs) and 1 for histograms (single query)
+type Metric struct {
+	Name              string            `yaml:"name"`
+	Periods           string            `yaml:"periods"`
+	SeriesNameOrFunc  string            `yaml:"series_name_or_func"`
+	MetricSQL         string            `yaml:"sql"`
+	MetricSQLs        *[]string         `yaml:"sqls"`
+	AddPeriodToName   bool              `yaml:"add_period_to_name"`
+	Histogram         bool              `yaml:"histogram"`
+	Aggregate         string            `yaml:"aggregate"`
+	Skip              string            `yaml:"skip"`
+	Desc              string            `yaml:"desc"`
+	MultiValue        bool              `yaml:"multi_value"`
+	EscapeValueName   bool              `yaml:"escape_value_name"`
+	AnnotationsRanges bool              `yaml:"annotations_ranges"`
+	MergeSeries       string            `yaml:"merge_series"`
+	CustomData        bool              `yaml:"custom_data"`
+	StartFrom         *time.Time        `yaml:"start_from"`
+	LastHours         int               `yaml:"last_hours"`
+	SeriesNameMap     map[string]string `yaml:"series_name_map"`
+	EnvMap            map[string]string `yaml:"env"`
+	Disabled          bool              `yaml:"disabled"`
+	Drop              string            `yaml:"drop"`
+	Project           string            `yaml:"project"`
+	AllowFail         bool              `yaml:"allow_fail"`
+	DependsOn         []string          `yaml:"depends_on"`
+	Weight            int               `yaml:"weight"`
+	Mergeable         string            `yaml:"mergeable"`
+}
+
+// CalcMetricOptions - returns calc_metric options needed to calculate a given metric
+// Options depending on sync state (skip_past, drop) are not included
+func (m *Metric) CalcMetricOptions(ctx *Ctx) (opts []string) {
+	if ctx.ProjectScale != 1.0 {
+		opts = append(opts, fmt.Sprintf("project_scale:%f", ctx.ProjectScale))
+	}
+	if m.Histogram {
+		opts = append(opts, "hist")
+	}
+	if m.MultiValue {
+		opts = append(opts, "multivalue")
+	}
+	if m.EscapeValueName {
+		opts = append(opts, "escape_value_name")
+	}
+	if m.Desc != "" {
+		opts = append(opts, "desc:"+m.Desc)
+	}
+	if m.MergeSeries != "" {
+		opts = append(opts, "merge_series:"+m.MergeSeries)
+	}
+	if m.CustomData {
+		opts = append(opts, "custom_data")
+	}
+	if m.SeriesNameMap != nil {
+		opts = append(opts, "series_name_map:"+fmt.Sprintf("%v", m.SeriesNameMap))
+	}
+	if m.AnnotationsRanges {
+		opts = append(opts, "annotations_ranges")
+	}
+	if m.Mergeable != "" {
+		opts = append(opts, "mergeable:"+m.Mergeable)
+	}
+	// Metric name is only used for run statistics, it is escaped because it can contain ',' and ':'
+	if m.Name != "" {
+		opts = append(opts, "metric:"+url.QueryEscape(m.Name))
+	}
+	return
+}
+
+// MetricPeriod - single period variant of a metric
+// Period is a base period (or quick range suffix for annotations ranges metrics), Name is the period with aggregate suffix (like d7)
+type MetricPeriod struct {
+	Period string
+	Name   string
+}
+
+// SQLVariants - returns metric split into one metric per SQL file when it uses `sqls`, only the first one keeps `drop`
+func (m *Metric) SQLVariants() (variants []Metric, err error) {
+	if m.MetricSQLs == nil {
+		return []Metric{*m}, nil
+	}
+	if m.MetricSQL != "" {
+		return nil, fmt.Errorf("metric '%s': you cannot use both 'sql' and 'sqls' fields", m.Name)
+	}
+	for i, sql := range *m.MetricSQLs {
+		variant := *m
+		variant.MetricSQLs = nil
+		variant.MetricSQL = sql
+		if i > 0 {
+			variant.Drop = ""
+		}
+		variants = append(variants, variant)
+	}
+	return
+}
+
+// PeriodVariants - returns all metric's periods combined with all its aggregates, without skipped ones
+// Annotations ranges metrics use quick ranges suffixes as periods
+func (m *Metric) PeriodVariants(quickRanges []string) (periods []MetricPeriod, err error) {
+	bases := strings.Split(m.Periods, ",")
+	aggregate := m.Aggregate
+	if aggregate == "" {
+		aggregate = "1"
+	}
+	if m.AnnotationsRanges {
+		bases = quickRanges
+		aggregate = "1"
+	}
+	skipMap := make(map[string]struct{})
+	for _, skip := range strings.Split(m.Skip, ",") {
+		skipMap[skip] = struct{}{}
+	}
+	for _, aggr := range strings.Split(aggregate, ",") {
+		_, err = strconv.Atoi(aggr)
+		if err != nil {
+			return nil, fmt.Errorf("metric '%s': invalid aggregate '%s': %v", m.Name, aggr, err)
+		}
+		if aggr == "1" {
+			aggr = ""
+		}
+		for _, period := range bases {
+			if _, skip := skipMap[period+aggr]; skip {
+				continue
+			}
+			periods = append(periods, MetricPeriod{Period: period, Name: period + aggr})
+		}
+	}
+	return
+}
+
+// SeriesName - returns series name (or series function) used when calculating a given period (with aggregate suffix)
+func (m *Metric) SeriesName(period string) string {
+	if m.AddPeriodToName {
+		return m.SeriesNameOrFunc + "_" + period
+	}
+	return m.SeriesNameOrFunc
+}
+
+// MetricEnvMap - returns metric's environment (metrics.yaml `env:`) for a given period
+// If env variable ends with ? then only set this value when it's not yet set or is empty
+// If env variable ends with ?? then only set this value when not already defined,
+// so if env variable is defined but empty, it will not set its value while version with a single ? will
+// If key contains @ (for example key-name@period-name) that means a key key-name should only be set for period=period-name
+// If key contains ! (for example key-name!period-name) that means a key key-name should only be set for period!=period-name
+// Most complex example GHA2DB_NCPUS?@d7=4 - set GHA2DB_NCPUS=4 only when calculating period d7
+// and that variable was not already set to non-empty value
+func MetricEnvMap(in map[string]string, period string) (outMap map[string]string) {
+	inMap := make(map[string]string)
+	for k, v := range in {
+		if strings.Contains(k, "@") {
+			ary := strings.Split(k, "@")
+			if ary[1] == period && ary[0] != "" {
+				inMap[ary[0]] = v
+			}
+			continue
+		}
+		if strings.Contains(k, "!") {
+			ary := strings.Split(k, "!")
+			if ary[1] != period && ary[0] != "" {
+				inMap[ary[0]] = v
+			}
+			continue
+		}
+		inMap[k] = v
+	}
+	conditional := false
+	for k := range inMap {
+		if strings.HasSuffix(k, "?") {
+			conditional = true
+			break
+		}
+	}
+	if !conditional {
+		outMap = inMap
+		return
+	}
+	outMap = make(map[string]string)
+	for k, v := range inMap {
+		if strings.HasSuffix(k, "??") {
+			k2 := k[0 : len(k)-2]
+			_, ok := os.LookupEnv(k2)
+			if !ok {
+				outMap[k2] = v
+			}
+			continue
+		}
+		if strings.HasSuffix(k, "?") {
+			k2 := k[0 : len(k)-1]
+			val := os.Getenv(k2)
+			if val == "" {
+				outMap[k2] = v
+			}
+			continue
+		}
+		outMap[k] = v
+	}
+	return
+}
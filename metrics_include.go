@@ -0,0 +1,144 @@
+package devstatscode
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// mergeMetrics - merges metrics defined later (add) into base list
+// Metrics from add replace all base metrics with the same name (at the position of the first one),
+// other metrics from add are appended in their order
+func mergeMetrics(base, add []Metric) []Metric {
+	byName := make(map[string][]Metric)
+	names := []string{}
+	for _, metric := range add {
+		if _, ok := byName[metric.Name]; !ok {
+			names = append(names, metric.Name)
+		}
+		byName[metric.Name] = append(byName[metric.Name], metric)
+	}
+	result := []Metric{}
+	used := make(map[string]struct{})
+	for _, metric := range base {
+		metrics, ok := byName[metric.Name]
+		if !ok {
+			result = append(result, metric)
+			continue
+		}
+		if _, done := used[metric.Name]; !done {
+			result = append(result, metrics...)
+			used[metric.Name] = struct{}{}
+		}
+	}
+	for _, name := range names {
+		if _, done := used[name]; !done {
+			result = append(result, byName[name]...)
+		}
+	}
+	return result
+}
+
+// metricsIncludePath - returns path of included file, relative includes are relative to the including file
+func metricsIncludePath(path, inc string) string {
+	if !filepath.IsAbs(inc) {
+		return filepath.Join(filepath.Dir(path), inc)
+	}
+	return inc
+}
+
+// resolveMetrics - reads metrics definition file and recursively merges all included files
+func resolveMetrics(ctx *Ctx, path string, stack []string) (*AllMetrics, error) {
+	for _, p := range stack {
+		if p == path {
+			return nil, fmt.Errorf("metrics include cycle: %s -> %s", strings.Join(stack, " -> "), path)
+		}
+	}
+	stack = append(stack, path)
+	data, err := ReadFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var own AllMetrics
+	err = yaml.Unmarshal(data, &own)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	result := &AllMetrics{}
+	retention := []RetentionRule{}
+	for _, inc := range own.Include {
+		included, err := resolveMetrics(ctx, metricsIncludePath(path, inc), stack)
+		if err != nil {
+			return nil, err
+		}
+		result.Metrics = mergeMetrics(result.Metrics, included.Metrics)
+		retention = append(retention, included.Retention...)
+		result.Overrides = append(result.Overrides, included.Overrides...)
+	}
+	result.Metrics = mergeMetrics(result.Metrics, own.Metrics)
+	result.Retention = append(own.Retention, retention...)
+	result.Overrides = append(result.Overrides, own.Overrides...)
+	return result, nil
+}
+
+// ApplyMetricOverrides - applies overrides to metrics, overrides excluded for the current project are ignored
+// Overrides are applied in order so the later ones win, env maps are merged (override keys win)
+func ApplyMetricOverrides(ctx *Ctx, allMetrics *AllMetrics) error {
+	for _, override := range allMetrics.Overrides {
+		if ExcludedForProject(ctx.Project, override.Project) {
+			continue
+		}
+		found := false
+		for i := range allMetrics.Metrics {
+			metric := &allMetrics.Metrics[i]
+			if metric.Name != override.Name {
+				continue
+			}
+			found = true
+			if override.Periods != nil {
+				metric.Periods = *override.Periods
+			}
+			if override.Skip != nil {
+				metric.Skip = *override.Skip
+			}
+			if override.Disabled != nil {
+				metric.Disabled = *override.Disabled
+			}
+			if len(override.Env) > 0 {
+				env := make(map[string]string)
+				for k, v := range metric.EnvMap {
+					env[k] = v
+				}
+				for k, v := range override.Env {
+					env[k] = v
+				}
+				metric.EnvMap = env
+			}
+		}
+		if !found {
+			return fmt.Errorf("override of unknown metric '%s'", override.Name)
+		}
+	}
+	allMetrics.Overrides = nil
+	return nil
+}
+
+// ReadMetrics - reads metrics definition file, resolves its includes and applies overrides
+// Included files are processed in order (recursively), then the file's own metrics are merged.
+// Metrics merged later replace all earlier metrics with the same name at the position of the first one,
+// so project's metrics.yaml can redefine shared metrics while other metrics keep their order.
+// Retention rules of the including file come first (first matching rule wins), then included ones.
+// Overrides (from included files first) are applied to the merged list in order.
+func ReadMetrics(ctx *Ctx, path string) (*AllMetrics, error) {
+	allMetrics, err := resolveMetrics(ctx, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = ApplyMetricOverrides(ctx, allMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return allMetrics, nil
+}
@@ -0,0 +1,123 @@
+package devstatscode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func writeMetricsFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "metrics_include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadMetrics(t *testing.T) {
+	shared := "metrics:\n" +
+		"- name: A\n  sql: a\n  periods: d,w\n" +
+		"- name: B\n  sql: b\n  periods: d\n  env:\n    X: '1'\n    Y: '2'\n" +
+		"- name: C\n  sql: c\n  periods: m\n" +
+		"retention:\n- period: h\n  keep_days: 30\n"
+	var testCases = []struct {
+		project  string
+		main     string
+		expected string
+		err      string
+	}{
+		{
+			main:     "include: [shared/metrics.yaml]\n",
+			expected: "A:a:d,w:false:,B:b:d:false:X=1;Y=2,C:c:m:false:",
+		},
+		{
+			main:     "include: [shared/metrics.yaml]\nmetrics:\n- name: B\n  sql: b2\n  periods: w\n- name: D\n  sql: d\n  periods: q\n",
+			expected: "A:a:d,w:false:,B:b2:w:false:,C:c:m:false:,D:d:q:false:",
+		},
+		{
+			main: "include: [shared/metrics.yaml]\noverrides:\n" +
+				"- name: A\n  periods: d\n" +
+				"- name: B\n  env:\n    Y: '3'\n    Z: '4'\n" +
+				"- name: C\n  disabled: true\n",
+			expected: "A:a:d:false:,B:b:d:false:X=1;Y=3;Z=4,C:c:m:true:",
+		},
+		{
+			project:  "kubernetes",
+			main:     "include: [shared/metrics.yaml]\noverrides:\n- name: A\n  project: '!kubernetes'\n  periods: d\n- name: C\n  project: kubernetes\n  periods: y\n",
+			expected: "A:a:d,w:false:,B:b:d:false:X=1;Y=2,C:c:y:false:",
+		},
+		{
+			main: "include: [shared/metrics.yaml]\noverrides:\n- name: E\n  periods: d\n",
+			err:  "unknown metric 'E'",
+		},
+		{
+			main: "include: [other.yaml]\n",
+			err:  "include cycle",
+		},
+		{
+			main: "include: [missing.yaml]\n",
+			err:  "missing.yaml",
+		},
+	}
+	for index, test := range testCases {
+		dir := writeMetricsFiles(
+			t,
+			map[string]string{
+				"shared/metrics.yaml": shared,
+				"main.yaml":           test.main,
+				"other.yaml":          "include: [main.yaml]\n",
+			},
+		)
+		ctx := lib.Ctx{Project: test.project}
+		allMetrics, err := lib.ReadMetrics(&ctx, filepath.Join(dir, "main.yaml"))
+		os.RemoveAll(dir)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("test number %d, expected error containing '%s', got: %v", index+1, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test number %d, unexpected error: %v", index+1, err)
+			continue
+		}
+		got := []string{}
+		for _, metric := range allMetrics.Metrics {
+			env := []string{}
+			for _, key := range []string{"X", "Y", "Z"} {
+				if value, ok := metric.EnvMap[key]; ok {
+					env = append(env, key+"="+value)
+				}
+			}
+			got = append(
+				got,
+				strings.Join(
+					[]string{metric.Name, metric.MetricSQL, metric.Periods, fmt.Sprintf("%v", metric.Disabled), strings.Join(env, ";")},
+					":",
+				),
+			)
+		}
+		if strings.Join(got, ",") != test.expected {
+			t.Errorf("test number %d, expected metrics:\n%s\ngot:\n%s", index+1, test.expected, strings.Join(got, ","))
+		}
+		if len(allMetrics.Retention) != 1 || len(allMetrics.Overrides) != 0 {
+			t.Errorf("test number %d, expected 1 retention rule and no overrides, got %d/%d", index+1, len(allMetrics.Retention), len(allMetrics.Overrides))
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaMigration - single numbered database schema change with statements to apply and to revert it
+// Versions start from 1 and have no gaps, applied versions are recorded in gha_schema_migrations table
+// Migration with Database set only runs its statements in that database, other databases only record its version
+type SchemaMigration struct {
+	Version  int
+	Name     string
+	Database string
+	Up       []string
+	Down     []string
+}
+
+// SchemaMigrationStep - migration to apply (or revert when Down is set)
+type SchemaMigrationStep struct {
+	Migration SchemaMigration
+	Down      bool
+}
+
+// createIfNotExists - returns create table statement for a table definition which doesn't fail when table exists
+func createIfNotExists(tdef string) string {
+	return strings.Replace(CreateTable(tdef), "create table ", "create table if not exists ", 1)
+}
+
+// SchemaMigrations - returns all schema migrations, ordered by version
+// Version 1 is the schema created by `structure` before migrations were introduced
+// `structure` always creates the latest schema, so every new migration must also be reflected in Structure
+func SchemaMigrations() []SchemaMigration {
+	return []SchemaMigration{
+		{Version: 1, Name: "baseline"},
+		{
+			Version: 2,
+			Name:    "metrics_runs",
+			// Table is only used in `devstats` database, `structure migrate` also ensures it there
+			Database: Devstats,
+			Up:       metricsRunsSQL(),
+			Down:     []string{"drop table if exists gha_metrics_runs"},
+		},
+		{
+			Version: 3,
+			Name:    "hist_partials",
+			Up:      []string{createIfNotExists(histPartialsTableDef())},
+			Down:    []string{"drop table if exists gha_hist_partials"},
+		},
+		{
+			Version: 4,
+			Name:    "anomalies",
+			Up: []string{
+				createIfNotExists(anomaliesTableDef()),
+				"create index if not exists anomalies_dt_idx on gha_anomalies(dt)",
+				"create index if not exists anomalies_time_idx on gha_anomalies(time)",
+			},
+			Down: []string{"drop table if exists gha_anomalies"},
+		},
+		{
+			Version: 5,
+			Name:    "explain_plans",
+			Up: []string{
+				createIfNotExists(explainPlansTableDef()),
+				"create index if not exists explain_plans_dt_idx on gha_explain_plans(dt)",
+			},
+			Down: []string{"drop table if exists gha_explain_plans"},
+		},
+		{
+			Version: 6,
+			Name:    "series_catalog",
+			Up: []string{
+				createIfNotExists(seriesCatalogTableDef()),
+				"create index if not exists tseries_catalog_kind_idx on tseries_catalog(kind)",
+			},
+			Down: []string{"drop table if exists tseries_catalog"},
+		},
+		{
+			Version: 7,
+			Name:    "es_raw_checkpoints",
+			Up: []string{
+				createIfNotExists(esRawCheckpointsTableDef()),
+				createIfNotExists(esRawDaysTableDef()),
+			},
+			Down: []string{"drop table if exists gha_es_raw_days", "drop table if exists gha_es_raw_checkpoints"},
+		},
+	}
+}
+
+// LatestSchemaVersion - returns version of the latest schema migration
+func LatestSchemaVersion() int {
+	migrations := SchemaMigrations()
+	return migrations[len(migrations)-1].Version
+}
+
+// CheckSchemaMigrations - checks if migrations versions start from 1 and have no gaps
+func CheckSchemaMigrations(migrations []SchemaMigration) error {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return fmt.Errorf("migration '%s' has version %d, expected %d", migration.Name, migration.Version, i+1)
+		}
+		if migration.Name == "" {
+			return fmt.Errorf("migration version %d has no name", migration.Version)
+		}
+	}
+	return nil
+}
+
+// SchemaMigrationPlan - returns steps needed to migrate from current to target version
+// Migrations are applied in ascending order when upgrading and reverted in descending order when downgrading
+func SchemaMigrationPlan(migrations []SchemaMigration, current, target int) (steps []SchemaMigrationStep, err error) {
+	if target < 0 || target > len(migrations) {
+		return nil, fmt.Errorf("unknown schema version %d, latest is %d", target, len(migrations))
+	}
+	if current > len(migrations) {
+		return nil, fmt.Errorf("database schema version %d is newer than the latest known version %d", current, len(migrations))
+	}
+	for v := current + 1; v <= target; v++ {
+		steps = append(steps, SchemaMigrationStep{Migration: migrations[v-1]})
+	}
+	for v := current; v > target; v-- {
+		steps = append(steps, SchemaMigrationStep{Migration: migrations[v-1], Down: true})
+	}
+	return
+}
+
+// ensureSchemaMigrationsTable - creates schema migrations table if needed
+func ensureSchemaMigrationsTable(con *sql.DB, ctx *Ctx) {
+	if TableExists(con, ctx, "gha_schema_migrations") {
+		return
+	}
+	ExecSQLWithErr(con, ctx, createIfNotExists(schemaMigrationsTableDef()))
+}
+
+// schemaMigrationsTableDef - applied schema migrations table definition
+func schemaMigrationsTableDef() string {
+	return "gha_schema_migrations(" +
+		"version int not null, " +
+		"name text not null, " +
+		"applied_at {{tsnow}} not null, " +
+		"primary key(version)" +
+		")"
+}
+
+// SchemaVersion - returns the highest applied schema migration version, 0 when no migrations were applied
+func SchemaVersion(con *sql.DB, ctx *Ctx) (version int) {
+	if !TableExists(con, ctx, "gha_schema_migrations") {
+		return
+	}
+	FatalOnError(QueryRowSQL(con, ctx, "select coalesce(max(version), 0) from gha_schema_migrations").Scan(&version))
+	return
+}
+
+// MarkSchemaMigrated - records all migrations as applied, used after `structure` creates the latest schema
+func MarkSchemaMigrated(con *sql.DB, ctx *Ctx) {
+	ensureSchemaMigrationsTable(con, ctx)
+	for _, migration := range SchemaMigrations() {
+		ExecSQLWithErr(
+			con,
+			ctx,
+			InsertIgnore("into gha_schema_migrations(version, name) "+NValues(2)),
+			migration.Version,
+			migration.Name,
+		)
+	}
+}
+
+// MigrateSchema - migrates database schema to target version (up or down), each migration runs in its own transaction
+// In dry-run mode only prints statements that would be executed
+func MigrateSchema(con *sql.DB, ctx *Ctx, target int) {
+	migrations := SchemaMigrations()
+	FatalOnError(CheckSchemaMigrations(migrations))
+	current := SchemaVersion(con, ctx)
+	steps, err := SchemaMigrationPlan(migrations, current, target)
+	FatalOnError(err)
+	if len(steps) == 0 {
+		Printf("Database %s schema is at version %d, nothing to migrate\n", ctx.PgDB, current)
+		return
+	}
+	Printf("Migrating database %s schema from version %d to %d (%d steps)\n", ctx.PgDB, current, target, len(steps))
+	if !ctx.DryRun {
+		ensureSchemaMigrationsTable(con, ctx)
+	}
+	for _, step := range steps {
+		stmts, dir := step.Migration.Up, "up"
+		if step.Down {
+			stmts, dir = step.Migration.Down, "down"
+		}
+		if step.Migration.Database != "" && step.Migration.Database != ctx.PgDB {
+			stmts = nil
+		}
+		if ctx.DryRun {
+			Printf("Would migrate %s %d %s:\n%s\n", dir, step.Migration.Version, step.Migration.Name, strings.Join(stmts, ";\n"))
+			continue
+		}
+		tx, err := con.Begin()
+		FatalOnError(err)
+		for _, stmt := range stmts {
+			ExecSQLTxWithErr(tx, ctx, stmt)
+		}
+		if step.Down {
+			ExecSQLTxWithErr(tx, ctx, "delete from gha_schema_migrations where version = $1", step.Migration.Version)
+		} else {
+			ExecSQLTxWithErr(
+				tx,
+				ctx,
+				"insert into gha_schema_migrations(version, name) "+NValues(2),
+				step.Migration.Version,
+				step.Migration.Name,
+			)
+		}
+		FatalOnError(tx.Commit())
+		Printf("Migrated %s %d %s\n", dir, step.Migration.Version, step.Migration.Name)
+	}
+}
+
+// CheckSchema - refuses to continue when database schema is not at the latest version
+// Can be skipped with GHA2DB_SKIP_SCHEMA_CHECK
+func CheckSchema(con *sql.DB, ctx *Ctx) {
+	if ctx.SkipSchemaCheck {
+		return
+	}
+	version := SchemaVersion(con, ctx)
+	latest := LatestSchemaVersion()
+	if version < latest {
+		Fatalf("database %s schema is at version %d, but %d is required, please run 'structure migrate'", ctx.PgDB, version, latest)
+	}
+	if version > latest {
+		Fatalf("database %s schema is at version %d, newer than %d supported by this binary, please update devstats", ctx.PgDB, version, latest)
+	}
+}
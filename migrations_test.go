@@ -0,0 +1,70 @@
+package devstatscode
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestSchemaMigrations(t *testing.T) {
+	migrations := lib.SchemaMigrations()
+	if err := lib.CheckSchemaMigrations(migrations); err != nil {
+		t.Fatalf("invalid schema migrations: %v", err)
+	}
+	if lib.LatestSchemaVersion() != len(migrations) {
+		t.Errorf("expected latest version %d, got %d", len(migrations), lib.LatestSchemaVersion())
+	}
+	for _, migration := range migrations[1:] {
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			t.Errorf("migration %d %s must have both up and down statements", migration.Version, migration.Name)
+		}
+	}
+	// Metrics runs statistics are only stored in devstats database
+	if migrations[1].Name != "metrics_runs" || migrations[1].Database != lib.Devstats {
+		t.Errorf("expected metrics_runs migration to only run in %s database, got %+v", lib.Devstats, migrations[1])
+	}
+	bad := []lib.SchemaMigration{{Version: 1, Name: "a"}, {Version: 3, Name: "b"}}
+	if lib.CheckSchemaMigrations(bad) == nil {
+		t.Errorf("expected error for migrations with version gap")
+	}
+}
+
+func TestSchemaMigrationPlan(t *testing.T) {
+	migrations := []lib.SchemaMigration{{Version: 1, Name: "a"}, {Version: 2, Name: "b"}, {Version: 3, Name: "c"}}
+	var testCases = []struct {
+		current  int
+		target   int
+		expected []string
+		hasError bool
+	}{
+		{current: 0, target: 3, expected: []string{"up 1", "up 2", "up 3"}},
+		{current: 1, target: 2, expected: []string{"up 2"}},
+		{current: 3, target: 1, expected: []string{"down 3", "down 2"}},
+		{current: 2, target: 2, expected: []string{}},
+		{current: 0, target: 4, hasError: true},
+		{current: 5, target: 3, hasError: true},
+	}
+	for index, test := range testCases {
+		steps, err := lib.SchemaMigrationPlan(migrations, test.current, test.target)
+		if (err != nil) != test.hasError {
+			t.Errorf("test number %d, expected error: %v, got: %v", index+1, test.hasError, err)
+			continue
+		}
+		if test.hasError {
+			continue
+		}
+		got := []string{}
+		for _, step := range steps {
+			dir := "up"
+			if step.Down {
+				dir = "down"
+			}
+			got = append(got, fmt.Sprintf("%s %d", dir, step.Migration.Version))
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test number %d, expected %v, got %v", index+1, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package devstatscode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Minimal Parquet writer used by TSDB export
+// It writes a single row group, one uncompressed PLAIN encoded data page per column
+// All columns are OPTIONAL, so NULL values are supported via definition levels
+// Metadata is encoded using Thrift compact protocol as described in parquet-format's parquet.thrift
+
+// Parquet physical types, converted types, encodings and other enums used
+const (
+	parquetInt64           = 2
+	parquetDouble          = 5
+	parquetByteArray       = 6
+	parquetOptional        = 1
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+	parquetPlain           = 0
+	parquetRLE             = 3
+	parquetUncompressed    = 0
+	parquetDataPage        = 0
+)
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// ExportColumn - exported column name and its type: "time", "float", "int" or "string"
+type ExportColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// thriftWriter - Thrift compact protocol encoder (only parts needed by Parquet metadata)
+type thriftWriter struct {
+	buf   bytes.Buffer
+	last  []int16
+	field int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	t.buf.Write(b[:n])
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	delta := id - t.field
+	if delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	t.field = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) str(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.varint(uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+// listHeader - writes list field header, list elements must be written directly after it
+func (t *thriftWriter) listHeader(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		t.varint(uint64(size))
+	}
+}
+
+// beginStruct - starts nested struct, id < 0 means struct is a list element (no field header)
+func (t *thriftWriter) beginStruct(id int16) {
+	if id >= 0 {
+		t.fieldHeader(id, thriftStruct)
+	}
+	t.last = append(t.last, t.field)
+	t.field = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf.WriteByte(0)
+	t.field = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+// appendUint32LE - appends little endian encoded value (binary.LittleEndian.AppendUint32 needs Go 1.19)
+func appendUint32LE(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// parquetDefLevels - returns RLE encoded definition levels (bit width 1) prefixed with 4 bytes length
+func parquetDefLevels(values []interface{}) []byte {
+	var enc []byte
+	for i := 0; i < len(values); {
+		defined := values[i] != nil
+		j := i
+		for j < len(values) && (values[j] != nil) == defined {
+			j++
+		}
+		enc = appendUvarint(enc, uint64(j-i)<<1)
+		if defined {
+			enc = append(enc, 1)
+		} else {
+			enc = append(enc, 0)
+		}
+		i = j
+	}
+	out := appendUint32LE(nil, uint32(len(enc)))
+	return append(out, enc...)
+}
+
+// parquetType - returns Parquet physical and converted type for given export column type (converted type -1 means none)
+func parquetType(typ string) (int32, int32) {
+	switch typ {
+	case "time":
+		return parquetInt64, parquetTimestampMicros
+	case "int":
+		return parquetInt64, -1
+	case "float":
+		return parquetDouble, -1
+	default:
+		return parquetByteArray, parquetUTF8
+	}
+}
+
+// parquetValues - returns PLAIN encoded non-null values of given column
+func parquetValues(typ string, values []interface{}) ([]byte, error) {
+	var out []byte
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		switch typ {
+		case "time":
+			t, ok := value.(time.Time)
+			if !ok {
+				return nil, fmt.Errorf("expected time value, got %T: %v", value, value)
+			}
+			out = appendUint64LE(out, uint64(t.UnixNano()/int64(time.Microsecond)))
+		case "int":
+			i, ok := value.(int64)
+			if !ok {
+				return nil, fmt.Errorf("expected int64 value, got %T: %v", value, value)
+			}
+			out = appendUint64LE(out, uint64(i))
+		case "float":
+			f, ok := value.(float64)
+			if !ok {
+				return nil, fmt.Errorf("expected float64 value, got %T: %v", value, value)
+			}
+			out = appendUint64LE(out, math.Float64bits(f))
+		default:
+			s := fmt.Sprintf("%v", value)
+			out = appendUint32LE(out, uint32(len(s)))
+			out = append(out, s...)
+		}
+	}
+	return out, nil
+}
+
+// WriteParquet - writes rows (each row has one value per column, nil means NULL) as a Parquet file
+func WriteParquet(w io.Writer, columns []ExportColumn, rows [][]interface{}) error {
+	var (
+		file    bytes.Buffer
+		offsets []int64
+		sizes   []int64
+	)
+	file.WriteString("PAR1")
+	for c, column := range columns {
+		values := make([]interface{}, len(rows))
+		for r, row := range rows {
+			values[r] = row[c]
+		}
+		data, err := parquetValues(column.Type, values)
+		if err != nil {
+			return fmt.Errorf("column %s: %v", column.Name, err)
+		}
+		page := append(parquetDefLevels(values), data...)
+		var hdr thriftWriter
+		hdr.i32(1, parquetDataPage)
+		hdr.i32(2, int32(len(page)))
+		hdr.i32(3, int32(len(page)))
+		hdr.beginStruct(5)
+		hdr.i32(1, int32(len(rows)))
+		hdr.i32(2, parquetPlain)
+		hdr.i32(3, parquetRLE)
+		hdr.i32(4, parquetRLE)
+		hdr.endStruct()
+		hdr.buf.WriteByte(0)
+		offsets = append(offsets, int64(file.Len()))
+		sizes = append(sizes, int64(hdr.buf.Len()+len(page)))
+		file.Write(hdr.buf.Bytes())
+		file.Write(page)
+	}
+	totalSize := int64(0)
+	for _, size := range sizes {
+		totalSize += size
+	}
+
+	// FileMetaData
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.listHeader(2, thriftStruct, len(columns)+1)
+	meta.beginStruct(-1)
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endStruct()
+	for _, column := range columns {
+		typ, conv := parquetType(column.Type)
+		meta.beginStruct(-1)
+		meta.i32(1, typ)
+		meta.i32(3, parquetOptional)
+		meta.str(4, column.Name)
+		if conv >= 0 {
+			meta.i32(6, conv)
+		}
+		meta.endStruct()
+	}
+	meta.i64(3, int64(len(rows)))
+	meta.listHeader(4, thriftStruct, 1)
+	meta.beginStruct(-1)
+	meta.listHeader(1, thriftStruct, len(columns))
+	for c, column := range columns {
+		typ, _ := parquetType(column.Type)
+		meta.beginStruct(-1)
+		meta.i64(2, offsets[c])
+		meta.beginStruct(3)
+		meta.i32(1, typ)
+		meta.listHeader(2, thriftI32, 2)
+		meta.zigzag(parquetPlain)
+		meta.zigzag(parquetRLE)
+		meta.listHeader(3, thriftBinary, 1)
+		meta.varint(uint64(len(column.Name)))
+		meta.buf.WriteString(column.Name)
+		meta.i32(4, parquetUncompressed)
+		meta.i64(5, int64(len(rows)))
+		meta.i64(6, sizes[c])
+		meta.i64(7, sizes[c])
+		meta.i64(9, offsets[c])
+		meta.endStruct()
+		meta.endStruct()
+	}
+	meta.i64(2, totalSize)
+	meta.i64(3, int64(len(rows)))
+	meta.endStruct()
+	meta.str(6, "devstats")
+	meta.buf.WriteByte(0)
+
+	file.Write(meta.buf.Bytes())
+	var footerLen [4]byte
+	binary.LittleEndian.PutUint32(footerLen[:], uint32(meta.buf.Len()))
+	file.Write(footerLen[:])
+	file.WriteString("PAR1")
+	_, err := w.Write(file.Bytes())
+	return err
+}
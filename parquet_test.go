@@ -0,0 +1,147 @@
+package devstatscode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+// thriftReader - minimal Thrift compact protocol decoder, structs are decoded into field id -> value maps
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case 5, 6:
+		return r.zigzag()
+	case 8:
+		n := int(r.varint())
+		s := string(r.data[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9:
+		hdr := r.data[r.pos]
+		r.pos++
+		size := int(hdr >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+		list := []interface{}{}
+		for i := 0; i < size; i++ {
+			list = append(list, r.value(hdr&0x0f))
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	return nil
+}
+
+func (r *thriftReader) structure() map[int64]interface{} {
+	result := make(map[int64]interface{})
+	field := int64(0)
+	for {
+		hdr := r.data[r.pos]
+		r.pos++
+		if hdr == 0 {
+			return result
+		}
+		if hdr>>4 == 0 {
+			field = r.zigzag()
+		} else {
+			field += int64(hdr >> 4)
+		}
+		result[field] = r.value(hdr & 0x0f)
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	dt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	columns := []lib.ExportColumn{
+		{Name: "time", Type: "time"},
+		{Name: "value", Type: "float"},
+		{Name: "name", Type: "string"},
+	}
+	rows := [][]interface{}{
+		{dt, 1.5, "a"},
+		{dt, nil, nil},
+		{dt, 2.5, "bc"},
+	}
+	var buf bytes.Buffer
+	err := lib.WriteParquet(&buf, columns, rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := buf.Bytes()
+	if string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("missing PAR1 magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := thriftReader{data: data[len(data)-8-footerLen : len(data)-8]}
+	meta := footer.structure()
+	if footer.pos != footerLen {
+		t.Errorf("footer length %d, decoded %d bytes", footerLen, footer.pos)
+	}
+	if meta[3].(int64) != 3 {
+		t.Errorf("expected 3 rows, got %v", meta[3])
+	}
+	schema := meta[2].([]interface{})
+	if len(schema) != 4 || schema[0].(map[int64]interface{})[5].(int64) != 3 {
+		t.Fatalf("unexpected schema: %+v", schema)
+	}
+	for i, column := range columns {
+		elem := schema[i+1].(map[int64]interface{})
+		if elem[4].(string) != column.Name {
+			t.Errorf("expected column %s, got %+v", column.Name, elem)
+		}
+	}
+	chunks := meta[4].([]interface{})[0].(map[int64]interface{})[1].([]interface{})
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 column chunks, got %d", len(chunks))
+	}
+
+	// Check "value" column page: definition levels and PLAIN doubles
+	cmeta := chunks[1].(map[int64]interface{})[3].(map[int64]interface{})
+	page := thriftReader{data: data, pos: int(cmeta[9].(int64))}
+	hdr := page.structure()
+	if hdr[5].(map[int64]interface{})[1].(int64) != 3 {
+		t.Errorf("expected 3 values in page, got %+v", hdr)
+	}
+	body := data[page.pos : page.pos+int(hdr[3].(int64))]
+	if page.pos+len(body)-int(cmeta[9].(int64)) != int(cmeta[7].(int64)) {
+		t.Errorf("column chunk size mismatch: %+v", cmeta)
+	}
+	defLen := int(binary.LittleEndian.Uint32(body))
+	if !bytes.Equal(body[4:4+defLen], []byte{0x02, 1, 0x02, 0, 0x02, 1}) {
+		t.Errorf("unexpected definition levels: %v", body[4:4+defLen])
+	}
+	values := body[4+defLen:]
+	if len(values) != 16 ||
+		math.Float64frombits(binary.LittleEndian.Uint64(values)) != 1.5 ||
+		math.Float64frombits(binary.LittleEndian.Uint64(values[8:])) != 2.5 {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	// Wrong value type must be reported
+	err = lib.WriteParquet(&buf, columns, [][]interface{}{{"x", 1.0, "a"}})
+	if err == nil {
+		t.Errorf("expected error for invalid time value")
+	}
+}
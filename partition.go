@@ -0,0 +1,208 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PartitionTable - table that can be created as a time range partitioned table
+// Column is the partition key column, it must be a part of the table's primary key when partitioned
+type PartitionTable struct {
+	Name   string
+	Column string
+}
+
+// PartitionRange - single partition of a time range partitioned table, covering [From, To)
+type PartitionRange struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// PartitionIndex - index of a table being migrated to a partitioned table
+type PartitionIndex struct {
+	Name string
+	Def  string
+}
+
+// PartitionTables - returns tables that can be time range partitioned
+func PartitionTables() []PartitionTable {
+	return []PartitionTable{
+		{Name: "gha_events", Column: "created_at"},
+		{Name: "gha_payloads", Column: "dup_created_at"},
+		{Name: "gha_commits", Column: "dup_created_at"},
+		{Name: "gha_texts", Column: "created_at"},
+	}
+}
+
+// partitionColumn - returns partition key column of a given table, or "" if table cannot be partitioned
+func partitionColumn(table string) string {
+	for _, pt := range PartitionTables() {
+		if pt.Name == table {
+			return pt.Column
+		}
+	}
+	return ""
+}
+
+// CheckPartitionPeriod - checks if partition interval is supported (m, q or y)
+func CheckPartitionPeriod(period string) error {
+	if period != "m" && period != "q" && period != "y" {
+		return fmt.Errorf("unknown partition interval '%s', allowed: m, q, y", period)
+	}
+	return nil
+}
+
+// PartitionName - returns name of a given table's partition containing date dt
+// Names are: gha_events_p2021_03 (m), gha_events_p2021_q1 (q) and gha_events_p2021 (y)
+func PartitionName(table, period string, dt time.Time) string {
+	switch period {
+	case "m":
+		return fmt.Sprintf("%s_p%04d_%02d", table, dt.Year(), int(dt.Month()))
+	case "q":
+		return fmt.Sprintf("%s_p%04d_q%d", table, dt.Year(), (int(dt.Month())+2)/3)
+	}
+	return fmt.Sprintf("%s_p%04d", table, dt.Year())
+}
+
+// PartitionRanges - returns partitions of a given table needed to store data from `from` to `to` (both inclusive)
+func PartitionRanges(table, period string, from, to time.Time) (ranges []PartitionRange) {
+	_, _, intervalStart, nextIntervalStart, _ := GetIntervalFunctions(period, false)
+	for dt := intervalStart(from); !dt.After(to); dt = nextIntervalStart(dt) {
+		ranges = append(ranges, PartitionRange{Name: PartitionName(table, period, dt), From: dt, To: nextIntervalStart(dt)})
+	}
+	return
+}
+
+// PartitionPrimaryKey - returns primary key clause for a table, partition column is added when table is partitioned
+func PartitionPrimaryKey(period, table string, columns ...string) string {
+	column := partitionColumn(table)
+	if period != "" && column != "" {
+		found := false
+		for _, col := range columns {
+			if col == column {
+				found = true
+				break
+			}
+		}
+		if !found {
+			columns = append(columns, column)
+		}
+	}
+	return "primary key(" + strings.Join(columns, ", ") + ")"
+}
+
+// PartitionBy - returns partitioning clause to be appended to table's create statement, "" if table is not partitioned
+func PartitionBy(period, table string) string {
+	column := partitionColumn(table)
+	if period == "" || column == "" {
+		return ""
+	}
+	return " partition by range(" + column + ")"
+}
+
+// partitionComment - table comment used to store partition interval of a partitioned table
+func partitionComment(period string) string {
+	return "partition:" + period
+}
+
+// PartitionsSQL - returns statements creating missing partitions of a table (and its default partition)
+// Default partition gets rows outside of created ranges, they must be created before data for new periods arrives
+func PartitionsSQL(table, period string, from, to time.Time) (stmts []string) {
+	for _, rng := range PartitionRanges(table, period, from, to) {
+		stmts = append(
+			stmts,
+			fmt.Sprintf(
+				"create table if not exists %s partition of %s for values from ('%s') to ('%s')",
+				rng.Name,
+				table,
+				ToYMDHMSDate(rng.From),
+				ToYMDHMSDate(rng.To),
+			),
+		)
+	}
+	stmts = append(stmts, fmt.Sprintf("create table if not exists %s_default partition of %s default", table, table))
+	return
+}
+
+// PartitionMigrationSQL - returns statements converting existing table into a time range partitioned table
+// Existing indexes are dropped and recreated after data is copied, primary key gets partition column added
+// Data range [from, to] is a range of partition column values existing in the table
+func PartitionMigrationSQL(table, period string, pk []string, indexes []PartitionIndex, from, to time.Time) (stmts []string) {
+	old := table + "_unpartitioned"
+	pkey := table + "_pkey"
+	for _, index := range indexes {
+		if index.Name == pkey {
+			continue
+		}
+		stmts = append(stmts, "drop index "+index.Name)
+	}
+	stmts = append(stmts, "alter table "+table+" rename to "+old)
+	if len(pk) > 0 {
+		stmts = append(stmts, "alter table "+old+" rename constraint "+pkey+" to "+old+"_pkey")
+	}
+	stmts = append(stmts, "create table "+table+" (like "+old+" including defaults)"+PartitionBy(period, table))
+	if len(pk) > 0 {
+		stmts = append(stmts, "alter table "+table+" add "+PartitionPrimaryKey(period, table, pk...))
+	}
+	stmts = append(stmts, "comment on table "+table+" is '"+partitionComment(period)+"'")
+	stmts = append(stmts, PartitionsSQL(table, period, from, to)...)
+	stmts = append(stmts, "insert into "+table+" select * from "+old)
+	for _, index := range indexes {
+		if index.Name == pkey {
+			continue
+		}
+		stmts = append(stmts, index.Def)
+	}
+	stmts = append(stmts, "drop table "+old)
+	return
+}
+
+// TablePartitionPeriod - returns partition interval of a partitioned table, "" if table is not partitioned
+func TablePartitionPeriod(con *sql.DB, ctx *Ctx, table string) (period string) {
+	rows := QuerySQLWithErr(
+		con,
+		ctx,
+		"select coalesce(obj_description(c.oid, 'pg_class'), '') from pg_partitioned_table p, pg_class c "+
+			"where c.oid = p.partrelid and c.relname = $1",
+		table,
+	)
+	defer func() { FatalOnError(rows.Close()) }()
+	comment := ""
+	for rows.Next() {
+		FatalOnError(rows.Scan(&comment))
+		period = strings.TrimPrefix(comment, partitionComment(""))
+		if comment == period || CheckPartitionPeriod(period) != nil {
+			Fatalf("table %s is partitioned but has no valid partition interval comment: '%s'", table, comment)
+		}
+	}
+	FatalOnError(rows.Err())
+	return
+}
+
+// CreatePartitions - creates partitions of a just created partitioned table and stores its partition interval
+// Partitions are created up to one interval after `to`, the same as EnsurePartitions does
+func CreatePartitions(con *sql.DB, ctx *Ctx, table, period string, from, to time.Time) {
+	_, _, _, nextIntervalStart, _ := GetIntervalFunctions(period, false)
+	ExecSQLWithErr(con, ctx, "comment on table "+table+" is '"+partitionComment(period)+"'")
+	for _, stmt := range PartitionsSQL(table, period, from, nextIntervalStart(to)) {
+		ExecSQLWithErr(con, ctx, stmt)
+	}
+}
+
+// EnsurePartitions - creates missing partitions of all partitioned tables for data from `from` to `to`
+// One more interval after `to` is created, so data arriving at interval boundary doesn't go to the default partition
+func EnsurePartitions(con *sql.DB, ctx *Ctx, from, to time.Time) {
+	for _, pt := range PartitionTables() {
+		period := TablePartitionPeriod(con, ctx, pt.Name)
+		if period == "" {
+			continue
+		}
+		_, _, _, nextIntervalStart, _ := GetIntervalFunctions(period, false)
+		for _, stmt := range PartitionsSQL(pt.Name, period, from, nextIntervalStart(to)) {
+			ExecSQLWithErr(con, ctx, stmt)
+		}
+	}
+}
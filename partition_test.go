@@ -0,0 +1,72 @@
+package devstatscode
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestPartitionRanges(t *testing.T) {
+	from := time.Date(2020, 11, 15, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	var testCases = []struct {
+		period   string
+		expected []string
+	}{
+		{period: "m", expected: []string{"gha_events_p2020_11", "gha_events_p2020_12", "gha_events_p2021_01", "gha_events_p2021_02"}},
+		{period: "q", expected: []string{"gha_events_p2020_q4", "gha_events_p2021_q1"}},
+		{period: "y", expected: []string{"gha_events_p2020", "gha_events_p2021"}},
+	}
+	for index, test := range testCases {
+		got := []string{}
+		ranges := lib.PartitionRanges("gha_events", test.period, from, to)
+		for i, rng := range ranges {
+			got = append(got, rng.Name)
+			if i > 0 && !rng.From.Equal(ranges[i-1].To) {
+				t.Errorf("test number %d, partitions %s and %s are not adjacent", index+1, ranges[i-1].Name, rng.Name)
+			}
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test number %d, expected %v, got %v", index+1, test.expected, got)
+		}
+	}
+}
+
+func TestPartitionDDL(t *testing.T) {
+	if got := lib.PartitionPrimaryKey("", "gha_events", "id"); got != "primary key(id)" {
+		t.Errorf("unexpected not partitioned primary key: %s", got)
+	}
+	if got := lib.PartitionPrimaryKey("m", "gha_commits", "sha", "event_id"); got != "primary key(sha, event_id, dup_created_at)" {
+		t.Errorf("unexpected partitioned primary key: %s", got)
+	}
+	if got := lib.PartitionBy("m", "gha_actors"); got != "" {
+		t.Errorf("unexpected partitioning of not partitionable table: %s", got)
+	}
+	if lib.CheckPartitionPeriod("w") == nil || lib.CheckPartitionPeriod("q") != nil {
+		t.Errorf("unexpected partition interval validation")
+	}
+	dt := time.Date(2021, 5, 10, 0, 0, 0, 0, time.UTC)
+	expected := []string{
+		"drop index events_type_idx",
+		"alter table gha_events rename to gha_events_unpartitioned",
+		"alter table gha_events_unpartitioned rename constraint gha_events_pkey to gha_events_unpartitioned_pkey",
+		"create table gha_events (like gha_events_unpartitioned including defaults) partition by range(created_at)",
+		"alter table gha_events add primary key(id, created_at)",
+		"comment on table gha_events is 'partition:y'",
+		"create table if not exists gha_events_p2021 partition of gha_events for values from ('2021-01-01 00:00:00') to ('2022-01-01 00:00:00')",
+		"create table if not exists gha_events_default partition of gha_events default",
+		"insert into gha_events select * from gha_events_unpartitioned",
+		"create index events_type_idx on gha_events(type)",
+		"drop table gha_events_unpartitioned",
+	}
+	indexes := []lib.PartitionIndex{
+		{Name: "gha_events_pkey", Def: "create unique index gha_events_pkey on gha_events(id)"},
+		{Name: "events_type_idx", Def: "create index events_type_idx on gha_events(type)"},
+	}
+	got := lib.PartitionMigrationSQL("gha_events", "y", []string{"id"}, indexes, dt, dt)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, got)
+	}
+}
@@ -18,6 +18,7 @@ import (
 // use non-null mut when you are using this function from multiple threads that write to the same series name at the same time
 //   use non-null mut only then.
 // No more giant lock approach here, but it is up to user to spcify call context, especially 2 last parameters!
+// Use WriteTSPointsE to validate points first and skip invalid series instead of failing.
 func WriteTSPoints(ctx *Ctx, con *sql.DB, pts *TSPoints, mergeSeries string, mut *sync.Mutex) {
 	npts := len(*pts)
 	if ctx.Debug > 0 {
@@ -79,15 +80,8 @@ func WriteTSPoints(ctx *Ctx, con *sql.DB, pts *TSPoints, mergeSeries string, mut
 				if !ok {
 					t = -1
 				}
-				ty := -1
-				switch fieldValue.(type) {
-				case float64:
-					ty = 0
-				case time.Time:
-					ty = 1
-				case string:
-					ty = 2
-				default:
+				ty := TSFieldType(fieldValue)
+				if ty < 0 {
 					Fatalf("usupported metric value type: %+v,%T (field %s)", fieldValue, fieldValue, fieldName)
 				}
 				if t >= 0 && t != ty {
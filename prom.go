@@ -0,0 +1,283 @@
+package devstatscode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PromSample - single Prometheus sample generated from TS point numeric field
+type PromSample struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64 // Milliseconds since epoch
+}
+
+// PromName - returns valid Prometheus metric/label name, invalid characters are replaced with "_"
+func PromName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || (c >= '0' && c <= '9' && i > 0)) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// PromSamples - converts TS points into Prometheus samples
+// Each numeric field (float or time) becomes a "devstats_{series}_{field}" gauge
+// Tags and string fields (except value descriptions) become labels, period and project are labels too
+// When mergeSeries is set, "devstats_{mergeSeries}_{field}" is used with series name in "series" label
+func PromSamples(ctx *Ctx, pts *TSPoints, mergeSeries string) (samples []PromSample) {
+	for _, p := range *pts {
+		labels := make(map[string]string)
+		if p.period != "" {
+			labels["period"] = p.period
+		}
+		if ctx.Project != "" {
+			labels["project"] = ctx.Project
+		}
+		for tagName, tagValue := range p.tags {
+			labels[PromName(tagName)] = tagValue
+		}
+		series := "s" + p.name
+		if mergeSeries != "" {
+			series = "s" + mergeSeries
+			labels["series"] = p.name
+		}
+		values := make(map[string]float64)
+		for fieldName, fieldValue := range p.fields {
+			switch value := fieldValue.(type) {
+			case float64:
+				values[fieldName] = value
+			case time.Time:
+				values[fieldName] = float64(value.Unix())
+			case string:
+				if fieldName != "descr" {
+					labels[PromName(fieldName)] = value
+				}
+			}
+		}
+		for fieldName, value := range values {
+			sampleLabels := make(map[string]string)
+			for k, v := range labels {
+				sampleLabels[k] = v
+			}
+			samples = append(
+				samples,
+				PromSample{
+					Name:      "devstats_" + PromName(series+"_"+fieldName),
+					Labels:    sampleLabels,
+					Value:     value,
+					Timestamp: p.t.UnixNano() / int64(time.Millisecond),
+				},
+			)
+		}
+	}
+	return
+}
+
+// promLabelsString - returns sorted {name="value",...} labels string (empty when no labels)
+func promLabelsString(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := []string{}
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ary := []string{}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for _, name := range names {
+		ary = append(ary, name+`="`+replacer.Replace(labels[name])+`"`)
+	}
+	return "{" + strings.Join(ary, ",") + "}"
+}
+
+// sortPromSamples - sorts samples by name, labels and time, so each metric family and series is contiguous
+func sortPromSamples(samples []PromSample) {
+	keys := make([]string, len(samples))
+	for i := range samples {
+		keys[i] = samples[i].Name + promLabelsString(samples[i].Labels)
+	}
+	idx := make([]int, len(samples))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := idx[i], idx[j]
+		if keys[a] != keys[b] {
+			return keys[a] < keys[b]
+		}
+		return samples[a].Timestamp < samples[b].Timestamp
+	})
+	sorted := make([]PromSample, len(samples))
+	for i, j := range idx {
+		sorted[i] = samples[j]
+	}
+	copy(samples, sorted)
+}
+
+// WriteOpenMetrics - writes samples in OpenMetrics text format
+func WriteOpenMetrics(w io.Writer, samples []PromSample) (err error) {
+	sortPromSamples(samples)
+	lastName := ""
+	for _, s := range samples {
+		if s.Name != lastName {
+			_, err = fmt.Fprintf(w, "# TYPE %s gauge\n", s.Name)
+			if err != nil {
+				return
+			}
+			lastName = s.Name
+		}
+		_, err = fmt.Fprintf(w, "%s%s %v %.3f\n", s.Name, promLabelsString(s.Labels), s.Value, float64(s.Timestamp)/1000.0)
+		if err != nil {
+			return
+		}
+	}
+	_, err = fmt.Fprintf(w, "# EOF\n")
+	return
+}
+
+// appendUvarint - appends varint encoded value (binary.AppendUvarint needs Go 1.19)
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+// appendUint64LE - appends little endian encoded value (binary.LittleEndian.AppendUint64 needs Go 1.19)
+func appendUint64LE(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// protoKey - appends protobuf field key
+func protoKey(buf []byte, field, wireType int) []byte {
+	return appendUvarint(buf, uint64(field<<3|wireType))
+}
+
+// protoBytes - appends length delimited protobuf field
+func protoBytes(buf []byte, field int, data []byte) []byte {
+	buf = protoKey(buf, field, 2)
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// PromWriteRequest - returns samples encoded as Prometheus remote-write WriteRequest protobuf message
+func PromWriteRequest(samples []PromSample) []byte {
+	sortPromSamples(samples)
+	req := []byte{}
+	var ts []byte
+	lastKey := ""
+	for i, s := range samples {
+		key := s.Name + promLabelsString(s.Labels)
+		if i == 0 || key != lastKey {
+			if i > 0 {
+				req = protoBytes(req, 1, ts)
+			}
+			ts = []byte{}
+			labels := map[string]string{"__name__": s.Name}
+			for k, v := range s.Labels {
+				labels[k] = v
+			}
+			names := []string{}
+			for name := range labels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				label := protoBytes([]byte{}, 1, []byte(name))
+				label = protoBytes(label, 2, []byte(labels[name]))
+				ts = protoBytes(ts, 1, label)
+			}
+			lastKey = key
+		}
+		sample := protoKey([]byte{}, 1, 1)
+		sample = appendUint64LE(sample, math.Float64bits(s.Value))
+		sample = protoKey(sample, 2, 0)
+		sample = appendUvarint(sample, uint64(s.Timestamp))
+		ts = protoBytes(ts, 2, sample)
+	}
+	if len(samples) > 0 {
+		req = protoBytes(req, 1, ts)
+	}
+	return req
+}
+
+// SnappyLiteral - returns data encoded as a valid snappy block using only literal chunks (no compression)
+func SnappyLiteral(data []byte) []byte {
+	out := appendUvarint([]byte{}, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > 65536 {
+			n = 65536
+		}
+		if n <= 60 {
+			out = append(out, byte((n-1)<<2))
+		} else {
+			out = append(out, 61<<2, byte((n-1)&0xff), byte((n-1)>>8))
+		}
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+// PromRemoteWrite - sends samples to Prometheus remote-write endpoint
+func PromRemoteWrite(ctx *Ctx, samples []PromSample) error {
+	body := SnappyLiteral(PromWriteRequest(samples))
+	req, err := http.NewRequest("POST", ctx.PromURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	client := &http.Client{Timeout: time.Minute * time.Duration(ctx.HTTPTimeout)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("remote write to %s failed: %s: %s", ctx.PromURL, resp.Status, string(msg))
+	}
+	return nil
+}
+
+// WritePromPoints - writes TS points to Prometheus
+// It uses remote-write when ctx.PromURL is set, otherwise writes OpenMetrics text file in ctx.PromDir
+func WritePromPoints(ctx *Ctx, pts *TSPoints, mergeSeries string) {
+	samples := PromSamples(ctx, pts, mergeSeries)
+	if ctx.Debug > 0 {
+		Printf("WritePromPoints: writing %d samples from %d points\n", len(samples), len(*pts))
+	}
+	if len(samples) == 0 {
+		return
+	}
+	if ctx.PromURL != "" {
+		FatalOnError(PromRemoteWrite(ctx, samples))
+		return
+	}
+	fn := fmt.Sprintf("%s%s_%d.om", ctx.PromDir, PromName(ctx.Project+"_"+samples[0].Name), time.Now().UnixNano())
+	f, err := os.Create(fn)
+	FatalOnError(err)
+	FatalOnError(WriteOpenMetrics(f, samples))
+	FatalOnError(f.Close())
+	if ctx.Debug > 0 {
+		Printf("WritePromPoints: written %s\n", fn)
+	}
+}
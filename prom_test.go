@@ -0,0 +1,103 @@
+package devstatscode
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestPromName(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		expected string
+	}{
+		{name: "", expected: ""},
+		{name: "devstats_sprs_age_value", expected: "devstats_sprs_age_value"},
+		{name: "a-b.c d", expected: "a_b_c_d"},
+		{name: "1abc", expected: "_abc"},
+		{name: "a1:ó", expected: "a1___"},
+	}
+	for index, test := range testCases {
+		got := lib.PromName(test.name)
+		if got != test.expected {
+			t.Errorf("test number %d, expected '%v', got '%v', test case: %+v", index+1, test.expected, got, test)
+		}
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+	ctx.Project = "kubernetes"
+
+	dt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	pts := lib.TSPoints{}
+	lib.AddTSPoint(
+		&ctx,
+		&pts,
+		lib.NewTSPoint(
+			&ctx,
+			"prs_age",
+			"d",
+			map[string]string{"repo": "a\"b"},
+			map[string]interface{}{"value": 1.5, "name": "x", "descr": "1 day"},
+			dt,
+			false,
+		),
+	)
+	samples := lib.PromSamples(&ctx, &pts, "")
+	var buf bytes.Buffer
+	err := lib.WriteOpenMetrics(&buf, samples)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "# TYPE devstats_sprs_age_value gauge\n" +
+		"devstats_sprs_age_value{name=\"x\",period=\"d\",project=\"kubernetes\",repo=\"a\\\"b\"} 1.5 1614556800.000\n" +
+		"# EOF\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	// Merged series
+	samples = lib.PromSamples(&ctx, &pts, "all")
+	if len(samples) != 1 || samples[0].Name != "devstats_sall_value" || samples[0].Labels["series"] != "prs_age" {
+		t.Errorf("unexpected merged series samples: %+v", samples)
+	}
+}
+
+func TestPromWriteRequest(t *testing.T) {
+	samples := []lib.PromSample{
+		{Name: "m", Value: 1.0, Timestamp: 1},
+	}
+	got := lib.PromWriteRequest(samples)
+	expected := []byte{
+		// WriteRequest.timeseries
+		0x0a, 0x1c,
+		// TimeSeries.labels: __name__="m"
+		0x0a, 0x0d, 0x0a, 0x08, '_', '_', 'n', 'a', 'm', 'e', '_', '_', 0x12, 0x01, 'm',
+		// TimeSeries.samples: value=1.0, timestamp=1
+		0x12, 0x0b, 0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, 0x10, 0x01,
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	var testCases = []struct {
+		n      int
+		header []byte
+	}{
+		{n: 0, header: []byte{0x00}},
+		{n: 3, header: []byte{0x03, 0x08}},
+		{n: 100, header: []byte{0x64, 0xf4, 0x63, 0x00}},
+	}
+	for index, test := range testCases {
+		data := bytes.Repeat([]byte{'x'}, test.n)
+		got := lib.SnappyLiteral(data)
+		if !bytes.Equal(got[:len(test.header)], test.header) || !bytes.Equal(got[len(test.header):], data) {
+			t.Errorf("test number %d, expected header %v, got %v", index+1, test.header, got)
+		}
+	}
+}
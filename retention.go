@@ -0,0 +1,330 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionRule - TSDB retention rule defined in metrics.yaml `retention:` section
+// Points of series tables matching Series regexp (series name without "s" prefix, empty: all series) and Period (h, d, w, m, q, y; aggregated
+// periods like h24 or d7 match their base period) older than KeepDays/KeepMonths are removed.
+// If RollUp period is set, expired points are first rolled up into this (coarser) period using Aggregate
+// (avg by default), rolled up points never replace already existing points.
+// Rules are checked in order and the first matching rule wins.
+// Histogram series are never affected, they use fake times (2012-07-01) and are fully recalculated on each run.
+// Series regexp is matched against series names known from metrics.yaml (series name or merge_series), series generated
+// by functions (for example multi_row_single_column) are only covered by rules without series regexp, because sync
+// only knows function name when skipping expired periods. Rules not matching any known series are rejected.
+type RetentionRule struct {
+	Series     string `yaml:"series"`
+	Period     string `yaml:"period"`
+	KeepDays   int    `yaml:"keep_days"`
+	KeepMonths int    `yaml:"keep_months"`
+	RollUp     string `yaml:"roll_up"`
+	Aggregate  string `yaml:"aggregate"`
+}
+
+// RetentionStats - retention result for a single series table and period
+type RetentionStats struct {
+	Table    string
+	Period   string
+	Cutoff   time.Time
+	RolledUp int64
+	Deleted  int64
+}
+
+// RetentionHistogram - checks if series period is a histogram: all its points use fake times before 2012-07-02
+func RetentionHistogram(maxTime time.Time) bool {
+	return !maxTime.After(TimeParseAny("2012-07-02"))
+}
+
+// retentionTrunc - maps roll up period to Postgres date_trunc unit, returns "" for unknown periods
+func retentionTrunc(period string) string {
+	switch period {
+	case "d":
+		return "day"
+	case "w":
+		return "week"
+	case "m":
+		return "month"
+	case "q":
+		return "quarter"
+	case "y":
+		return "year"
+	}
+	return ""
+}
+
+// retentionOrder - returns period order from the finest (h) to the coarsest (y), -1 for unknown periods
+func retentionOrder(period string) int {
+	if len(period) != 1 {
+		return -1
+	}
+	return strings.Index("hdwmqy", period)
+}
+
+// CheckRetentionRules - checks retention rules, returns error describing the first invalid rule
+func CheckRetentionRules(rules []RetentionRule) error {
+	for i, rule := range rules {
+		prefix := fmt.Sprintf("retention rule #%d", i+1)
+		if rule.Series != "" {
+			_, err := regexp.Compile(rule.Series)
+			if err != nil {
+				return fmt.Errorf("%s: invalid series regexp '%s': %v", prefix, rule.Series, err)
+			}
+		}
+		order := retentionOrder(rule.Period)
+		if order < 0 {
+			return fmt.Errorf("%s: unknown period '%s', allowed: h, d, w, m, q, y", prefix, rule.Period)
+		}
+		if rule.KeepDays < 0 || rule.KeepMonths < 0 || rule.KeepDays+rule.KeepMonths == 0 {
+			return fmt.Errorf("%s: you need to specify positive 'keep_days' and/or 'keep_months'", prefix)
+		}
+		if rule.RollUp != "" {
+			if retentionTrunc(rule.RollUp) == "" {
+				return fmt.Errorf("%s: unknown roll up period '%s', allowed: d, w, m, q, y", prefix, rule.RollUp)
+			}
+			if retentionOrder(rule.RollUp) <= order {
+				return fmt.Errorf("%s: roll up period '%s' must be coarser than '%s'", prefix, rule.RollUp, rule.Period)
+			}
+		}
+		switch rule.Aggregate {
+		case "", "avg", "sum", "max", "min":
+		default:
+			return fmt.Errorf("%s: unknown aggregate '%s', allowed: avg, sum, max, min", prefix, rule.Aggregate)
+		}
+	}
+	return nil
+}
+
+// CheckRetentionSeries - checks that every retention rule with series regexp matches at least one series name
+// known from metrics.yaml, otherwise it could only match tables generated by series functions: sync would recalculate
+// their expired periods and retention would remove them again on every run
+func CheckRetentionSeries(ctx *Ctx, allMetrics *AllMetrics) error {
+	known := []string{}
+	for i := range allMetrics.Metrics {
+		metric := &allMetrics.Metrics[i]
+		if metric.Disabled || metric.Histogram || ExcludedForProject(ctx.Project, metric.Project) {
+			continue
+		}
+		if metric.MergeSeries != "" {
+			known = append(known, metric.MergeSeries)
+			continue
+		}
+		if _, ok := metricFuncs[metric.SeriesNameOrFunc]; ok {
+			continue
+		}
+		periods, err := metric.PeriodVariants(nil)
+		if err != nil {
+			continue
+		}
+		for _, period := range periods {
+			known = append(known, metric.SeriesName(period.Name))
+		}
+	}
+	problems := []string{}
+	for i, rule := range allMetrics.Retention {
+		if rule.Series == "" {
+			continue
+		}
+		re, err := regexp.Compile(rule.Series)
+		if err != nil {
+			continue
+		}
+		found := false
+		for _, series := range known {
+			if re.MatchString(series) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(
+				problems,
+				fmt.Sprintf(
+					"retention rule #%d: series regexp '%s' doesn't match any series name or merge_series, "+
+						"series generated by functions can only be covered by rules without 'series'",
+					i+1, rule.Series,
+				),
+			)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// RetentionPeriodMatches - checks if series period (for example h, h24, d7) has a given base period
+func RetentionPeriodMatches(rulePeriod, period string) bool {
+	if !strings.HasPrefix(period, rulePeriod) {
+		return false
+	}
+	rest := period[len(rulePeriod):]
+	if rest == "" {
+		return true
+	}
+	_, err := strconv.Atoi(rest)
+	return err == nil
+}
+
+// FindRetentionRule - returns the first rule matching series name and period, nil if there is none
+func FindRetentionRule(rules []RetentionRule, series, period string) *RetentionRule {
+	for i, rule := range rules {
+		if !RetentionPeriodMatches(rule.Period, period) {
+			continue
+		}
+		if rule.Series != "" {
+			re, err := regexp.Compile(rule.Series)
+			if err != nil || !re.MatchString(series) {
+				continue
+			}
+		}
+		return &rules[i]
+	}
+	return nil
+}
+
+// Cutoff - returns time before which points covered by the rule are expired
+func (r *RetentionRule) Cutoff(now time.Time) time.Time {
+	return HourStart(now).AddDate(0, -r.KeepMonths, -r.KeepDays)
+}
+
+// RetentionExpireBefore - returns calc_metric option skipping expired periods of a given series, or "" if nothing is expired
+// series is the metric's series name (or merge series name), period is a period with aggregate suffix
+func RetentionExpireBefore(rules []RetentionRule, series, period string, now time.Time) string {
+	rule := FindRetentionRule(rules, series, period)
+	if rule == nil {
+		return ""
+	}
+	return fmt.Sprintf("expire_before:%d", rule.Cutoff(now).Unix())
+}
+
+// retentionRollUp - rolls up expired points into a coarser period, existing points are not replaced
+func retentionRollUp(con *sql.DB, ctx *Ctx, table, period string, rule *RetentionRule, cutoff time.Time) int64 {
+	rows := QuerySQLWithErr(
+		con,
+		ctx,
+		"select column_name, data_type from information_schema.columns "+
+			"where table_schema = 'public' and table_name = $1 order by ordinal_position",
+		table,
+	)
+	aggr := rule.Aggregate
+	if aggr == "" {
+		aggr = "avg"
+	}
+	keys := []string{}
+	cols := []string{}
+	exprs := []string{}
+	name, dataType := "", ""
+	for rows.Next() {
+		FatalOnError(rows.Scan(&name, &dataType))
+		switch name {
+		case "time", "period":
+			continue
+		case "series":
+			keys = append(keys, "series")
+			cols = append(cols, "series")
+			exprs = append(exprs, "series")
+			continue
+		}
+		col := "\"" + escapeName(name) + "\""
+		cols = append(cols, col)
+		if exportColumnType(dataType) == "float" {
+			exprs = append(exprs, aggr+"("+col+")")
+		} else {
+			exprs = append(exprs, "max("+col+")")
+		}
+	}
+	FatalOnError(rows.Err())
+	FatalOnError(rows.Close())
+	bucket := "date_trunc('" + retentionTrunc(rule.RollUp) + "', time)"
+	group := append([]string{bucket}, keys...)
+	query := fmt.Sprintf(
+		"insert into \"%s\"(time, period, %s) select %s, $1, %s from \"%s\" "+
+			"where period = $2 and time < $3 and time > $4 group by %s on conflict do nothing",
+		table,
+		strings.Join(cols, ", "),
+		bucket,
+		strings.Join(exprs, ", "),
+		table,
+		strings.Join(group, ", "),
+	)
+	res := ExecSQLWithErr(con, ctx, query, rule.RollUp, period, cutoff, TimeParseAny("2012-07-02"))
+	n, err := res.RowsAffected()
+	FatalOnError(err)
+	return n
+}
+
+// ApplyRetention - applies retention rules to all series tables, returns per table and period statistics
+// In dry-run mode only counts points that would be removed
+// Histogram periods are skipped and histogram (fake time) points are never removed, even from mixed tables
+func ApplyRetention(con *sql.DB, ctx *Ctx, rules []RetentionRule, now time.Time) (stats []RetentionStats) {
+	histBefore := TimeParseAny("2012-07-02")
+	for _, table := range GetSeriesTables(con, ctx) {
+		if !strings.HasPrefix(table, "s") || !TableColumnExists(con, ctx, table, "period") {
+			continue
+		}
+		rows := QuerySQLWithErr(con, ctx, "select period, max(time) from \""+table+"\" group by period")
+		periods := []string{}
+		var (
+			period  string
+			maxTime time.Time
+		)
+		for rows.Next() {
+			FatalOnError(rows.Scan(&period, &maxTime))
+			if RetentionHistogram(maxTime) {
+				if ctx.Debug > 0 {
+					Printf("Retention %s period %s: histogram, skipped\n", table, period)
+				}
+				continue
+			}
+			periods = append(periods, period)
+		}
+		FatalOnError(rows.Err())
+		FatalOnError(rows.Close())
+		for _, period := range periods {
+			rule := FindRetentionRule(rules, table[1:], period)
+			if rule == nil {
+				continue
+			}
+			st := RetentionStats{Table: table, Period: period, Cutoff: rule.Cutoff(now)}
+			if ctx.DryRun {
+				FatalOnError(
+					QueryRowSQL(
+						con,
+						ctx,
+						"select count(*) from \""+table+"\" where period = $1 and time < $2 and time > $3",
+						period,
+						st.Cutoff,
+						histBefore,
+					).Scan(&st.Deleted),
+				)
+			} else {
+				if rule.RollUp != "" {
+					st.RolledUp = retentionRollUp(con, ctx, table, period, rule, st.Cutoff)
+				}
+				res := ExecSQLWithErr(
+					con,
+					ctx,
+					"delete from \""+table+"\" where period = $1 and time < $2 and time > $3",
+					period,
+					st.Cutoff,
+					histBefore,
+				)
+				n, err := res.RowsAffected()
+				FatalOnError(err)
+				st.Deleted = n
+			}
+			if ctx.Debug > 0 || st.Deleted > 0 {
+				Printf("Retention %s period %s before %s: rolled up %d, removed %d\n", table, period, ToYMDHDate(st.Cutoff), st.RolledUp, st.Deleted)
+			}
+			stats = append(stats, st)
+		}
+	}
+	return
+}
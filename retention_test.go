@@ -0,0 +1,121 @@
+package devstatscode
+
+import (
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestCheckRetentionRules(t *testing.T) {
+	var testCases = []struct {
+		rules []lib.RetentionRule
+		valid bool
+	}{
+		{rules: nil, valid: true},
+		{rules: []lib.RetentionRule{{Period: "h", KeepDays: 30, RollUp: "d"}, {Series: "^prs_", Period: "d", KeepMonths: 6, RollUp: "m", Aggregate: "sum"}}, valid: true},
+		{rules: []lib.RetentionRule{{Period: "x", KeepDays: 30}}, valid: false},
+		{rules: []lib.RetentionRule{{Period: "h"}}, valid: false},
+		{rules: []lib.RetentionRule{{Period: "d", KeepDays: 1, RollUp: "h"}}, valid: false},
+		{rules: []lib.RetentionRule{{Period: "d", KeepDays: 1, Aggregate: "median"}}, valid: false},
+		{rules: []lib.RetentionRule{{Series: "(", Period: "d", KeepDays: 1}}, valid: false},
+	}
+	for index, test := range testCases {
+		err := lib.CheckRetentionRules(test.rules)
+		if (err == nil) != test.valid {
+			t.Errorf("test number %d, expected valid: %v, got error: %v", index+1, test.valid, err)
+		}
+	}
+}
+
+func TestFindRetentionRule(t *testing.T) {
+	rules := []lib.RetentionRule{
+		{Series: "^prs_", Period: "h", KeepDays: 7},
+		{Period: "h", KeepDays: 30},
+		{Period: "d", KeepMonths: 6},
+	}
+	var testCases = []struct {
+		series, period string
+		expected       int
+	}{
+		{series: "prs_age", period: "h", expected: 0},
+		{series: "prs_age", period: "h24", expected: 0},
+		{series: "issues", period: "h", expected: 1},
+		{series: "issues", period: "d7", expected: 2},
+		{series: "issues", period: "w", expected: -1},
+		{series: "issues", period: "hx", expected: -1},
+	}
+	for index, test := range testCases {
+		rule := lib.FindRetentionRule(rules, test.series, test.period)
+		got := -1
+		for i := range rules {
+			if rule == &rules[i] {
+				got = i
+			}
+		}
+		if got != test.expected {
+			t.Errorf("test number %d, expected rule %d, got %d", index+1, test.expected, got)
+		}
+	}
+	now := time.Date(2021, 3, 17, 12, 30, 0, 0, time.UTC)
+	if got := rules[2].Cutoff(now); !got.Equal(time.Date(2020, 9, 17, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected cutoff: %v", got)
+	}
+	expected := "expire_before:1615377600"
+	if got := lib.RetentionExpireBefore(rules, "prs_age", "h", now); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if got := lib.RetentionExpireBefore(rules, "prs_age", "m", now); got != "" {
+		t.Errorf("expected no option, got %s", got)
+	}
+}
+
+func TestRetentionHistogram(t *testing.T) {
+	var testCases = []struct {
+		maxTime  time.Time
+		expected bool
+	}{
+		{maxTime: time.Date(2012, 7, 1, 0, 0, 0, 0, time.UTC), expected: true},
+		{maxTime: time.Date(2012, 7, 2, 0, 0, 0, 0, time.UTC), expected: true},
+		{maxTime: time.Date(2012, 7, 2, 1, 0, 0, 0, time.UTC), expected: false},
+		{maxTime: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), expected: false},
+	}
+	for index, test := range testCases {
+		got := lib.RetentionHistogram(test.maxTime)
+		if got != test.expected {
+			t.Errorf("test number %d, expected %v, got %v, test case: %+v", index+1, test.expected, got, test)
+		}
+	}
+}
+
+func TestCheckRetentionSeries(t *testing.T) {
+	var ctx lib.Ctx
+	ctx.Project = "kubernetes"
+	metrics := []lib.Metric{
+		{Name: "prs", SeriesNameOrFunc: "multi_row_single_column", MergeSeries: "prs_merged", Periods: "d"},
+		{Name: "age", SeriesNameOrFunc: "age", AddPeriodToName: true, Periods: "d,w", Aggregate: "1,7"},
+		{Name: "top", SeriesNameOrFunc: "multi_row_multi_column", Periods: "d"},
+		{Name: "hist", SeriesNameOrFunc: "hist_series", Histogram: true},
+		{Name: "other", SeriesNameOrFunc: "other", Periods: "d", Project: "prometheus"},
+	}
+	var testCases = []struct {
+		rules []lib.RetentionRule
+		valid bool
+	}{
+		{rules: nil, valid: true},
+		{rules: []lib.RetentionRule{{Period: "h", KeepDays: 30}}, valid: true},
+		{rules: []lib.RetentionRule{{Series: "^prs_", Period: "d", KeepDays: 30}}, valid: true},
+		{rules: []lib.RetentionRule{{Series: "^age_d7$", Period: "d", KeepDays: 30}}, valid: true},
+		{rules: []lib.RetentionRule{{Series: "^top_", Period: "d", KeepDays: 30}}, valid: false},
+		{rules: []lib.RetentionRule{{Series: "^multi_row", Period: "d", KeepDays: 30}}, valid: false},
+		{rules: []lib.RetentionRule{{Series: "^hist_", Period: "d", KeepDays: 30}}, valid: false},
+		{rules: []lib.RetentionRule{{Series: "^other$", Period: "d", KeepDays: 30}}, valid: false},
+		{rules: []lib.RetentionRule{{Series: "^age_", Period: "d", KeepDays: 30}, {Series: "^issues_", Period: "d", KeepDays: 30}}, valid: false},
+	}
+	for index, test := range testCases {
+		err := lib.CheckRetentionSeries(&ctx, &lib.AllMetrics{Metrics: metrics, Retention: test.rules})
+		if (err == nil) != test.valid {
+			t.Errorf("test number %d, expected valid: %v, got error: %v", index+1, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package devstatscode
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MetricJob - single metric calculation scheduled by RunMetricJobs
+// Jobs are identified by metric name, and can depend on other metric names
+type MetricJob struct {
+	Name      string       // Metric name (from metrics.yaml), other jobs refer to this name in DependsOn
+	Info      string       // Job description used in logs (for example series, period and SQL file)
+	DependsOn []string     // Metric names that must be fully calculated before this job starts
+	After     []*MetricJob // Jobs that must finish before this job starts
+	Weight    int          // Resource weight: part of the concurrency budget this job uses while running
+	Histogram bool         // Histogram jobs are additionally limited by ctx.MaxHistograms
+	Last      bool         // Job must start after all other non-last jobs are finished
+	Run       func()       // Job's code
+	deps      []int
+	start     time.Time
+	end       time.Time
+}
+
+// NewMetricJob - returns job calculating a metric with its metrics.yaml dependencies and weight, Run must be set by caller
+// It is used by gha2db_sync and backfill_metric, so both schedule metrics the same way
+// Default weight is the entire budget for non-histogram metrics and 1 for histograms
+func NewMetricJob(ctx *Ctx, metric *Metric, info string, budget int) *MetricJob {
+	weight := metric.Weight
+	if weight <= 0 {
+		if metric.Histogram {
+			weight = 1
+		} else {
+			weight = budget
+		}
+	}
+	return &MetricJob{
+		Name:      metric.Name,
+		Info:      info,
+		DependsOn: metric.DependsOn,
+		Weight:    weight,
+		Histogram: metric.Histogram,
+		Last:      metric.SeriesNameOrFunc == ctx.LastSeries,
+	}
+}
+
+// Duration - time spent running a given job
+func (j *MetricJob) Duration() time.Duration {
+	return j.end.Sub(j.start)
+}
+
+// resolveMetricJobs - returns dependencies as indices into jobs array and jobs topological order
+// returns error if dependencies contain a cycle
+func resolveMetricJobs(jobs []*MetricJob) ([]int, error) {
+	byName := make(map[string][]int)
+	byJob := make(map[*MetricJob]int)
+	for i, job := range jobs {
+		byName[job.Name] = append(byName[job.Name], i)
+		byJob[job] = i
+	}
+	for i, job := range jobs {
+		job.deps = []int{}
+		seen := make(map[int]struct{})
+		add := func(idx int) {
+			if idx == i {
+				return
+			}
+			if _, ok := seen[idx]; !ok {
+				seen[idx] = struct{}{}
+				job.deps = append(job.deps, idx)
+			}
+		}
+		for _, name := range job.DependsOn {
+			// Dependencies that are not scheduled in this run are already calculated
+			for _, idx := range byName[name] {
+				add(idx)
+			}
+		}
+		for _, after := range job.After {
+			if idx, ok := byJob[after]; ok {
+				add(idx)
+			}
+		}
+		if job.Last {
+			for idx, other := range jobs {
+				if !other.Last {
+					add(idx)
+				}
+			}
+		}
+	}
+	// Kahn's algorithm
+	nDeps := make([]int, len(jobs))
+	dependents := make([][]int, len(jobs))
+	queue := []int{}
+	for i, job := range jobs {
+		nDeps[i] = len(job.deps)
+		for _, dep := range job.deps {
+			dependents[dep] = append(dependents[dep], i)
+		}
+		if nDeps[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	order := []int{}
+	for len(queue) > 0 {
+		idx := queue[0]
+		queue = queue[1:]
+		order = append(order, idx)
+		for _, dep := range dependents[idx] {
+			nDeps[dep]--
+			if nDeps[dep] == 0 {
+				queue = append(queue, dep)
+			}
+		}
+	}
+	if len(order) != len(jobs) {
+		cycle := []string{}
+		for i, n := range nDeps {
+			if n > 0 {
+				cycle = append(cycle, jobs[i].Name)
+			}
+		}
+		return nil, fmt.Errorf("metrics dependency cycle between: %s", strings.Join(cycle, ", "))
+	}
+	return order, nil
+}
+
+// RunMetricJobs - runs metric jobs respecting their dependencies
+// Sum of weights of running jobs never exceeds budget, jobs are started in the order given when possible
+// Returns critical path: the longest (by time) chain of dependent jobs
+func RunMetricJobs(ctx *Ctx, jobs []*MetricJob, budget int) (critical []*MetricJob, err error) {
+	order, err := resolveMetricJobs(jobs)
+	if err != nil {
+		return
+	}
+	if budget < 1 {
+		budget = 1
+	}
+	weights := make([]int, len(jobs))
+	nDeps := make([]int, len(jobs))
+	dependents := make([][]int, len(jobs))
+	ready := []int{}
+	for i, job := range jobs {
+		weights[i] = job.Weight
+		if weights[i] < 1 {
+			weights[i] = 1
+		}
+		if weights[i] > budget {
+			weights[i] = budget
+		}
+		nDeps[i] = len(job.deps)
+		for _, dep := range job.deps {
+			dependents[dep] = append(dependents[dep], i)
+		}
+		if nDeps[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	ch := make(chan int)
+	used, nHist, nRunning, nFinished := 0, 0, 0, 0
+	for nFinished < len(jobs) {
+		// Start ready jobs in order while they fit in the budget
+		// Histograms over ctx.MaxHistograms limit are skipped, other jobs wait for budget
+		for i := 0; i < len(ready); {
+			idx := ready[i]
+			job := jobs[idx]
+			if job.Histogram && ctx.MaxHistograms > 0 && nHist >= ctx.MaxHistograms {
+				i++
+				continue
+			}
+			if used+weights[idx] > budget {
+				break
+			}
+			ready = append(ready[:i], ready[i+1:]...)
+			used += weights[idx]
+			if job.Histogram {
+				nHist++
+			}
+			nRunning++
+			if ctx.Debug > 0 {
+				Printf("Starting %s %s (weight %d, budget used %d/%d)\n", job.Name, job.Info, weights[idx], used, budget)
+			}
+			go func(idx int, job *MetricJob) {
+				job.start = time.Now()
+				job.Run()
+				job.end = time.Now()
+				ch <- idx
+			}(idx, job)
+		}
+		if nRunning == 0 {
+			// Cannot happen when dependencies have no cycles
+			return nil, fmt.Errorf("metrics scheduler stalled with %d jobs left", len(jobs)-nFinished)
+		}
+		idx := <-ch
+		nRunning--
+		nFinished++
+		used -= weights[idx]
+		if jobs[idx].Histogram {
+			nHist--
+		}
+		for _, dep := range dependents[idx] {
+			nDeps[dep]--
+			if nDeps[dep] == 0 {
+				ready = append(ready, dep)
+			}
+		}
+	}
+	critical = criticalPath(jobs, order)
+	return
+}
+
+// criticalPath - returns the longest (by time) chain of dependent jobs, jobs must be already finished
+func criticalPath(jobs []*MetricJob, order []int) (path []*MetricJob) {
+	if len(jobs) == 0 {
+		return
+	}
+	total := make([]time.Duration, len(jobs))
+	prev := make([]int, len(jobs))
+	best := -1
+	for _, idx := range order {
+		prev[idx] = -1
+		for _, dep := range jobs[idx].deps {
+			if prev[idx] < 0 || total[dep] > total[prev[idx]] {
+				prev[idx] = dep
+			}
+		}
+		total[idx] = jobs[idx].Duration()
+		if prev[idx] >= 0 {
+			total[idx] += total[prev[idx]]
+		}
+		if best < 0 || total[idx] > total[best] {
+			best = idx
+		}
+	}
+	for idx := best; idx >= 0; idx = prev[idx] {
+		path = append([]*MetricJob{jobs[idx]}, path...)
+	}
+	return
+}
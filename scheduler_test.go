@@ -0,0 +1,131 @@
+package devstatscode
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestRunMetricJobs(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+	ctx.MaxHistograms = 1
+
+	var (
+		mtx      sync.Mutex
+		used     int
+		maxUsed  int
+		nHist    int
+		maxHist  int
+		finished = make(map[string]time.Time)
+		started  = make(map[string]time.Time)
+	)
+	makeJob := func(name string, weight int, hist bool, sleep time.Duration, deps ...string) *lib.MetricJob {
+		job := &lib.MetricJob{Name: name, Weight: weight, Histogram: hist, DependsOn: deps}
+		job.Run = func() {
+			mtx.Lock()
+			started[name] = time.Now()
+			used += weight
+			if used > maxUsed {
+				maxUsed = used
+			}
+			if hist {
+				nHist++
+				if nHist > maxHist {
+					maxHist = nHist
+				}
+			}
+			mtx.Unlock()
+			time.Sleep(sleep)
+			mtx.Lock()
+			used -= weight
+			if hist {
+				nHist--
+			}
+			finished[name] = time.Now()
+			mtx.Unlock()
+		}
+		return job
+	}
+	ms := time.Millisecond
+	jobs := []*lib.MetricJob{
+		makeJob("c", 1, false, 10*ms, "b"),
+		makeJob("b", 2, false, 30*ms, "a"),
+		makeJob("a", 1, false, 10*ms),
+		makeJob("d", 1, false, 5*ms),
+		makeJob("h1", 1, true, 5*ms),
+		makeJob("h2", 1, true, 5*ms),
+		makeJob("e", 1, false, 5*ms, "not_scheduled"),
+	}
+	last := makeJob("last", 1, false, ms)
+	last.Last = true
+	jobs = append(jobs, last)
+
+	critical, err := lib.RunMetricJobs(&ctx, jobs, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(finished) != len(jobs) {
+		t.Errorf("expected %d jobs to finish, got %d", len(jobs), len(finished))
+	}
+	if maxUsed > 3 {
+		t.Errorf("budget 3 exceeded: %d", maxUsed)
+	}
+	if maxHist > 1 {
+		t.Errorf("max histograms 1 exceeded: %d", maxHist)
+	}
+	for _, dep := range [][2]string{{"a", "b"}, {"b", "c"}} {
+		if started[dep[1]].Before(finished[dep[0]]) {
+			t.Errorf("'%s' started before its dependency '%s' finished", dep[1], dep[0])
+		}
+	}
+	for name, dt := range finished {
+		if name != "last" && started["last"].Before(dt) {
+			t.Errorf("'last' started before '%s' finished", name)
+		}
+	}
+	names := []string{}
+	for _, job := range critical {
+		names = append(names, job.Name)
+	}
+	if len(names) != 4 || names[0] != "a" || names[1] != "b" || names[2] != "c" || names[3] != "last" {
+		t.Errorf("expected critical path [a b c last], got %v", names)
+	}
+
+	// Cycles must be reported before running anything
+	ran := false
+	cyc := []*lib.MetricJob{
+		{Name: "x", DependsOn: []string{"y"}, Run: func() { ran = true }},
+		{Name: "y", DependsOn: []string{"x"}, Run: func() { ran = true }},
+	}
+	_, err = lib.RunMetricJobs(&ctx, cyc, 2)
+	if err == nil || ran {
+		t.Errorf("expected dependency cycle error without running jobs, got error: %v, ran: %v", err, ran)
+	}
+}
+
+func TestNewMetricJob(t *testing.T) {
+	var ctx lib.Ctx
+	ctx.LastSeries = "events_h"
+	var testCases = []struct {
+		metric    lib.Metric
+		weight    int
+		dependsOn int
+		last      bool
+	}{
+		{metric: lib.Metric{Name: "a", SeriesNameOrFunc: "a"}, weight: 4},
+		{metric: lib.Metric{Name: "b", SeriesNameOrFunc: "b", Histogram: true}, weight: 1},
+		{metric: lib.Metric{Name: "c", SeriesNameOrFunc: "c", Weight: 2, DependsOn: []string{"a", "b"}}, weight: 2, dependsOn: 2},
+		{metric: lib.Metric{Name: "d", SeriesNameOrFunc: "events_h"}, weight: 4, last: true},
+	}
+	for index, test := range testCases {
+		job := lib.NewMetricJob(&ctx, &test.metric, "info", 4)
+		if job.Name != test.metric.Name || job.Info != "info" || job.Weight != test.weight ||
+			len(job.DependsOn) != test.dependsOn || job.Last != test.last || job.Histogram != test.metric.Histogram {
+			t.Errorf("test number %d, unexpected job: %+v, test case: %+v", index+1, *job, test)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SeriesCatalogEntry - description of a single TSDB series table (or variable) kept in tseries_catalog table
+// Kind is "series" (s* tables), "tags" (t* tables) or "var" (gha_vars), Sources are metric SQL files, tags or vars
+// definitions writing the series, Columns maps value/tag column names to their Postgres types
+// Catalog entries are merged on update: periods, sources and columns are added, time range is extended
+// LastComputed is only set when reading the catalog
+type SeriesCatalogEntry struct {
+	Series       string
+	Kind         string
+	Sources      []string
+	Periods      []string
+	Columns      map[string]string
+	FirstTime    time.Time
+	LastTime     time.Time
+	LastComputed time.Time
+}
+
+// seriesCatalogTableDef - series catalog table definition
+func seriesCatalogTableDef() string {
+	return "tseries_catalog(" +
+		"series text not null, " +
+		"kind text not null, " +
+		"sources text[] not null, " +
+		"periods text[] not null, " +
+		"columns jsonb not null, " +
+		"first_time {{ts}}, " +
+		"last_time {{ts}}, " +
+		"last_computed {{tsnow}} not null, " +
+		"primary key(series)" +
+		")"
+}
+
+// ensureSeriesCatalogTable - creates series catalog table if needed
+func ensureSeriesCatalogTable(con *sql.DB, ctx *Ctx) {
+	if TableExists(con, ctx, "tseries_catalog") {
+		return
+	}
+	ExecSQLWithErr(con, ctx, strings.Replace(CreateTable(seriesCatalogTableDef()), "create table ", "create table if not exists ", 1))
+}
+
+// SeriesCatalogEntries - returns catalog entries describing tables written by a given batch of points
+// writeErr is the error returned by WriteTSPointsE, series skipped because of validation errors are not included
+func SeriesCatalogEntries(pts *TSPoints, mergeSeries, source string, writeErr error) (entries []SeriesCatalogEntry) {
+	skip := make(map[string]struct{})
+	if errs, ok := writeErr.(TSPointErrors); ok {
+		skip = errs.Series()
+	}
+	byTable := make(map[string]*SeriesCatalogEntry)
+	periods := make(map[string]map[string]struct{})
+	add := func(table, kind string, p *TSPoint) *SeriesCatalogEntry {
+		entry, ok := byTable[table]
+		if !ok {
+			entry = &SeriesCatalogEntry{Series: table, Kind: kind, Sources: []string{source}, Columns: make(map[string]string), FirstTime: p.t, LastTime: p.t}
+			byTable[table] = entry
+			periods[table] = make(map[string]struct{})
+		}
+		if p.t.Before(entry.FirstTime) {
+			entry.FirstTime = p.t
+		}
+		if p.t.After(entry.LastTime) {
+			entry.LastTime = p.t
+		}
+		if p.period != "" {
+			periods[table][p.period] = struct{}{}
+		}
+		return entry
+	}
+	for i := range *pts {
+		p := &(*pts)[i]
+		if _, ok := skip[p.name]; ok {
+			continue
+		}
+		if p.tags != nil {
+			table := "t" + p.name
+			if mergeSeries != "" {
+				table = p.name
+			}
+			entry := add(table, "tags", p)
+			for tagName := range p.tags {
+				entry.Columns[tagName] = "text"
+			}
+		}
+		if p.fields != nil {
+			entry := add(tsFieldsTable(p, mergeSeries), "series", p)
+			for fieldName, fieldValue := range p.fields {
+				entry.Columns[fieldName] = tsFieldTypeName(TSFieldType(fieldValue))
+			}
+		}
+	}
+	for table, entry := range byTable {
+		// Tags points use artificial times
+		if entry.Kind == "tags" {
+			entry.FirstTime, entry.LastTime = time.Time{}, time.Time{}
+		}
+		for period := range periods[table] {
+			entry.Periods = append(entry.Periods, period)
+		}
+		sort.Strings(entry.Periods)
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Series < entries[j].Series })
+	return
+}
+
+// UpdateSeriesCatalog - merges entries into tseries_catalog table and sets their last computation time
+func UpdateSeriesCatalog(con *sql.DB, ctx *Ctx, entries []SeriesCatalogEntry) {
+	if len(entries) == 0 || ctx.SkipTSDB {
+		return
+	}
+	ensureSeriesCatalogTable(con, ctx)
+	for _, entry := range entries {
+		columns, err := json.Marshal(entry.Columns)
+		FatalOnError(err)
+		var firstTime, lastTime interface{}
+		if !entry.FirstTime.IsZero() {
+			firstTime, lastTime = entry.FirstTime, entry.LastTime
+		}
+		ExecSQLWithErr(
+			con,
+			ctx,
+			"insert into tseries_catalog(series, kind, sources, periods, columns, first_time, last_time) "+
+				"values($1, $2, string_to_array($3, ','), string_to_array($4, ','), $5::jsonb, $6, $7) "+
+				"on conflict(series) do update set kind = excluded.kind, "+
+				"sources = array(select distinct unnest(tseries_catalog.sources || excluded.sources) order by 1), "+
+				"periods = array(select distinct unnest(tseries_catalog.periods || excluded.periods) order by 1), "+
+				"columns = tseries_catalog.columns || excluded.columns, "+
+				"first_time = least(tseries_catalog.first_time, excluded.first_time), "+
+				"last_time = greatest(tseries_catalog.last_time, excluded.last_time), "+
+				"last_computed = now()",
+			entry.Series,
+			entry.Kind,
+			strings.Join(entry.Sources, ","),
+			strings.Join(entry.Periods, ","),
+			string(columns),
+			firstTime,
+			lastTime,
+		)
+	}
+}
+
+// ReadSeriesCatalog - returns catalog entries, optionally only of a given kind and with series names matching a regexp
+// Errors are returned instead of being fatal, because this is used by the API server
+func ReadSeriesCatalog(con *sql.DB, ctx *Ctx, kind, seriesRegexp string) (entries []SeriesCatalogEntry, err error) {
+	query := "select series, kind, array_to_string(sources, ','), array_to_string(periods, ','), columns::text, " +
+		"first_time, last_time, last_computed from tseries_catalog where ($1 = '' or kind = $1) and ($2 = '' or series ~ $2) order by series"
+	rows, err := QuerySQLLogErr(con, ctx, query, kind, seriesRegexp)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	var (
+		sources, periods, columns string
+		firstTime, lastTime       *time.Time
+	)
+	for rows.Next() {
+		entry := SeriesCatalogEntry{}
+		err = rows.Scan(&entry.Series, &entry.Kind, &sources, &periods, &columns, &firstTime, &lastTime, &entry.LastComputed)
+		if err != nil {
+			return
+		}
+		if sources != "" {
+			entry.Sources = strings.Split(sources, ",")
+		}
+		if periods != "" {
+			entry.Periods = strings.Split(periods, ",")
+		}
+		err = json.Unmarshal([]byte(columns), &entry.Columns)
+		if err != nil {
+			return
+		}
+		if firstTime != nil && lastTime != nil {
+			entry.FirstTime, entry.LastTime = *firstTime, *lastTime
+		}
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,55 @@
+package devstatscode
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestSeriesCatalogEntries(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	t1 := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	var pts lib.TSPoints
+	pts = append(pts, lib.NewTSPoint(&ctx, "prs", "w", nil, map[string]interface{}{"value": 1.0}, t2, false))
+	pts = append(pts, lib.NewTSPoint(&ctx, "prs", "d", nil, map[string]interface{}{"value": 2.0, "name": "x"}, t1, false))
+	pts = append(pts, lib.NewTSPoint(&ctx, "top", "", map[string]string{"top_name": "a"}, nil, t2, false))
+	pts = append(pts, lib.NewTSPoint(&ctx, "bad", "d", nil, map[string]interface{}{"value": 1}, t1, false))
+	writeErr := lib.TSPointErrors{&lib.TSPointError{Kind: lib.TSErrUnsupportedType, Series: "bad", Table: "sbad", Column: "value"}}
+	expected := []lib.SeriesCatalogEntry{
+		{
+			Series:    "sprs",
+			Kind:      "series",
+			Sources:   []string{"prs"},
+			Periods:   []string{"d", "w"},
+			Columns:   map[string]string{"value": "double precision", "name": "text"},
+			FirstTime: t1,
+			LastTime:  t2,
+		},
+		{
+			Series:  "ttop",
+			Kind:    "tags",
+			Sources: []string{"prs"},
+			Columns: map[string]string{"top_name": "text"},
+		},
+	}
+	got := lib.SeriesCatalogEntries(&pts, "", "prs", writeErr)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", expected, got)
+	}
+
+	// Merged series
+	pts = lib.TSPoints{
+		lib.NewTSPoint(&ctx, "prs_a", "d", nil, map[string]interface{}{"value": 1.0}, t1, false),
+		lib.NewTSPoint(&ctx, "prs_b", "d", nil, map[string]interface{}{"value": 1.0, "dt": t1}, t1, false),
+	}
+	got = lib.SeriesCatalogEntries(&pts, "prs", "prs", nil)
+	if len(got) != 1 || got[0].Series != "sprs" || len(got[0].Columns) != 2 || got[0].Columns["dt"] != "timestamp without time zone" {
+		t.Errorf("unexpected merged series entries: %+v", got)
+	}
+}
@@ -0,0 +1,347 @@
+package devstatscode
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiffSource - series tables source: Postgres database or TSDB export snapshot (CSV export_tsdb output)
+type DiffSource struct {
+	Name     string
+	con      *sql.DB
+	ctx      *Ctx
+	manifest *ExportManifest
+	dir      string
+}
+
+// DiffRow - single series table row: column name -> value (float64 for numeric columns, string otherwise)
+type DiffRow map[string]interface{}
+
+// SeriesDiff - comparison result of a single series (table, series name for merged tables and period)
+type SeriesDiff struct {
+	Table      string
+	Series     string
+	Period     string
+	PointsA    int
+	PointsB    int
+	Added      int
+	Removed    int
+	Changed    int
+	MaxAbsDiff float64
+	MaxRelDiff float64
+	SumA       float64
+	SumB       float64
+}
+
+// Name - series identifier used in reports
+func (d *SeriesDiff) Name() string {
+	name := d.Table
+	if d.Series != "" {
+		name += "/" + d.Series
+	}
+	return name + " (" + d.Period + ")"
+}
+
+// Status - "added" (only in B), "removed" (only in A), "changed" or "" for equal series
+func (d *SeriesDiff) Status() string {
+	switch {
+	case d.PointsA == 0:
+		return "added"
+	case d.PointsB == 0:
+		return "removed"
+	case d.Added+d.Removed+d.Changed > 0:
+		return "changed"
+	}
+	return ""
+}
+
+// NewDBDiffSource - returns diff source reading series tables from a given database
+func NewDBDiffSource(ctx *Ctx, dbName string) *DiffSource {
+	return &DiffSource{Name: dbName, con: PgConnDB(ctx, dbName), ctx: ctx}
+}
+
+// NewSnapshotDiffSource - returns diff source reading series tables from export_tsdb output directory
+func NewSnapshotDiffSource(ctx *Ctx, dir string) *DiffSource {
+	if dir[len(dir)-1:] != "/" {
+		dir += "/"
+	}
+	ectx := *ctx
+	ectx.ExportDir = dir
+	manifest := ReadExportManifest(&ectx)
+	if manifest == nil {
+		Fatalf("no %s found in %s", ExportManifestFile, dir)
+	}
+	if manifest.Format != "csv" {
+		Fatalf("snapshot %s uses '%s' format, only 'csv' snapshots can be compared", dir, manifest.Format)
+	}
+	return &DiffSource{Name: dir, manifest: manifest, dir: dir}
+}
+
+// Close - closes database connection (if any)
+func (s *DiffSource) Close() {
+	if s.con != nil {
+		FatalOnError(s.con.Close())
+	}
+}
+
+// Tables - returns sorted series tables names
+func (s *DiffSource) Tables() (tables []string) {
+	if s.manifest != nil {
+		for table := range s.manifest.Tables {
+			tables = append(tables, table)
+		}
+	} else {
+		for _, table := range GetSeriesTables(s.con, s.ctx) {
+			if strings.HasPrefix(table, "s") {
+				tables = append(tables, table)
+			}
+		}
+	}
+	sort.Strings(tables)
+	return
+}
+
+// diffRowKey - returns series key (series name and period) and point key (time) of a given row
+func diffRowKey(row DiffRow) (string, string) {
+	series, _ := row["series"].(string)
+	period, _ := row["period"].(string)
+	tm, _ := row["time"].(string)
+	return series + "\x00" + period, tm
+}
+
+// diffValue - converts exported column value to a comparable value
+// NULLs are exported as empty CSV cells, so empty strings are compared as NULLs
+func diffValue(typ string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return ToYMDHMSDate(v)
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		if v == "" {
+			return nil
+		}
+		if typ == "float" || typ == "int" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err == nil {
+				return f
+			}
+		}
+		if typ == "time" {
+			return ToYMDHMSDate(TimeParseAny(v))
+		}
+		return v
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// Rows - reads all rows of a given series table
+func (s *DiffSource) Rows(table string) (result []DiffRow) {
+	if s.manifest != nil {
+		data, ok := s.manifest.Tables[table]
+		if !ok {
+			return
+		}
+		types := make(map[string]string)
+		for _, column := range data.Columns {
+			types[column.Name] = column.Type
+		}
+		keys := []string{}
+		for key := range data.Partitions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f, err := os.Open(s.dir + data.Partitions[key].File)
+			FatalOnError(err)
+			reader := csv.NewReader(f)
+			hdr, err := reader.Read()
+			FatalOnError(err)
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				FatalOnError(err)
+				row := make(DiffRow)
+				for i, column := range hdr {
+					row[column] = diffValue(types[column], record[i])
+				}
+				result = append(result, row)
+			}
+			FatalOnError(f.Close())
+		}
+		return
+	}
+	rows := QuerySQLWithErr(
+		s.con,
+		s.ctx,
+		"select column_name, data_type from information_schema.columns "+
+			"where table_schema = 'public' and table_name = $1 order by ordinal_position",
+		table,
+	)
+	columns := []ExportColumn{}
+	name, dataType := "", ""
+	for rows.Next() {
+		FatalOnError(rows.Scan(&name, &dataType))
+		columns = append(columns, ExportColumn{Name: name, Type: exportColumnType(dataType)})
+	}
+	FatalOnError(rows.Err())
+	FatalOnError(rows.Close())
+	if len(columns) == 0 {
+		return
+	}
+	quoted := []string{}
+	for _, column := range columns {
+		quoted = append(quoted, "\""+escapeName(column.Name)+"\"")
+	}
+	rows = QuerySQLWithErr(s.con, s.ctx, "select "+strings.Join(quoted, ", ")+" from \""+escapeName(table)+"\"")
+	defer func() { FatalOnError(rows.Close()) }()
+	vals := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		FatalOnError(rows.Scan(ptrs...))
+		row := make(DiffRow)
+		for i, column := range columns {
+			row[column.Name] = diffValue(column.Type, exportValue(column.Type, vals[i]))
+		}
+		result = append(result, row)
+	}
+	FatalOnError(rows.Err())
+	return
+}
+
+// DiffValuesEqual - compares two values, floats are equal when |a - b| <= tolerance * max(1, |a|, |b|)
+// (so tolerance is absolute for values below 1 and relative above), other values must be identical
+func DiffValuesEqual(a, b interface{}, tolerance float64) bool {
+	fa, okA := a.(float64)
+	fb, okB := b.(float64)
+	if okA && okB {
+		return math.Abs(fa-fb) <= tolerance*math.Max(1.0, math.Max(math.Abs(fa), math.Abs(fb)))
+	}
+	return a == b
+}
+
+// DiffSeriesTable - compares rows of a single series table from sources A and B, returns per series results
+// Series are identified by period (and series column for merged tables), points by time
+func DiffSeriesTable(table string, rowsA, rowsB []DiffRow, tolerance float64) (diffs []*SeriesDiff) {
+	type points map[string]DiffRow
+	group := func(rows []DiffRow) map[string]points {
+		result := make(map[string]points)
+		for _, row := range rows {
+			skey, pkey := diffRowKey(row)
+			if result[skey] == nil {
+				result[skey] = make(points)
+			}
+			result[skey][pkey] = row
+		}
+		return result
+	}
+	sum := func(row DiffRow) (s float64) {
+		for _, v := range row {
+			if f, ok := v.(float64); ok {
+				s += f
+			}
+		}
+		return
+	}
+	seriesA, seriesB := group(rowsA), group(rowsB)
+	keys := make(map[string]struct{})
+	for key := range seriesA {
+		keys[key] = struct{}{}
+	}
+	for key := range seriesB {
+		keys[key] = struct{}{}
+	}
+	for key := range keys {
+		ary := strings.Split(key, "\x00")
+		diff := &SeriesDiff{Table: table, Series: ary[0], Period: ary[1]}
+		pa, pb := seriesA[key], seriesB[key]
+		diff.PointsA, diff.PointsB = len(pa), len(pb)
+		for tm, rowA := range pa {
+			diff.SumA += sum(rowA)
+			rowB, ok := pb[tm]
+			if !ok {
+				diff.Removed++
+				continue
+			}
+			// Columns missing in one of the rows are compared as NULLs
+			columns := make(map[string]struct{})
+			for column := range rowA {
+				columns[column] = struct{}{}
+			}
+			for column := range rowB {
+				columns[column] = struct{}{}
+			}
+			changed := false
+			for column := range columns {
+				va, vb := rowA[column], rowB[column]
+				if DiffValuesEqual(va, vb, tolerance) {
+					continue
+				}
+				changed = true
+				fa, okA := va.(float64)
+				fb, okB := vb.(float64)
+				if okA && okB {
+					d := math.Abs(fa - fb)
+					diff.MaxAbsDiff = math.Max(diff.MaxAbsDiff, d)
+					diff.MaxRelDiff = math.Max(diff.MaxRelDiff, d/math.Max(math.Abs(fa), math.Abs(fb)))
+				}
+			}
+			if changed {
+				diff.Changed++
+			}
+		}
+		for tm, rowB := range pb {
+			diff.SumB += sum(rowB)
+			if _, ok := pa[tm]; !ok {
+				diff.Added++
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name() < diffs[j].Name() })
+	return
+}
+
+// DiffSeries - compares all series tables (optionally only those matching ctx.DiffSeries) from two sources
+func DiffSeries(ctx *Ctx, a, b *DiffSource) (diffs []*SeriesDiff) {
+	tables := make(map[string]struct{})
+	for _, table := range a.Tables() {
+		tables[table] = struct{}{}
+	}
+	for _, table := range b.Tables() {
+		tables[table] = struct{}{}
+	}
+	names := []string{}
+	for table := range tables {
+		if ctx.DiffSeries != nil && !ctx.DiffSeries.MatchString(table) {
+			continue
+		}
+		names = append(names, table)
+	}
+	sort.Strings(names)
+	for _, table := range names {
+		tableDiffs := DiffSeriesTable(table, a.Rows(table), b.Rows(table), ctx.DiffTolerance)
+		if ctx.Debug > 0 {
+			Printf("Compared table %s: %d series\n", table, len(tableDiffs))
+		}
+		diffs = append(diffs, tableDiffs...)
+	}
+	return
+}
@@ -0,0 +1,100 @@
+package devstatscode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestDiffSeriesTable(t *testing.T) {
+	row := func(tm, period string, value float64) lib.DiffRow {
+		return lib.DiffRow{"time": tm, "period": period, "value": value}
+	}
+	rowsA := []lib.DiffRow{
+		row("2021-03-01 00:00:00", "d", 1),
+		row("2021-03-02 00:00:00", "d", 100),
+		row("2021-03-03 00:00:00", "d", 3),
+		row("2021-03-01 00:00:00", "w", 7),
+		row("2021-03-01 00:00:00", "m", 30),
+	}
+	rowsB := []lib.DiffRow{
+		row("2021-03-01 00:00:00", "d", 1.005),
+		row("2021-03-02 00:00:00", "d", 120),
+		row("2021-03-04 00:00:00", "d", 4),
+		row("2021-03-01 00:00:00", "w", 7),
+		row("2021-03-01 00:00:00", "y", 365),
+	}
+	diffs := lib.DiffSeriesTable("sa", rowsA, rowsB, 0.01)
+	expected := []lib.SeriesDiff{
+		{Table: "sa", Period: "d", PointsA: 3, PointsB: 3, Added: 1, Removed: 1, Changed: 1, MaxAbsDiff: 20, MaxRelDiff: 20.0 / 120.0, SumA: 104, SumB: 125.005},
+		{Table: "sa", Period: "m", PointsA: 1, Removed: 1, SumA: 30},
+		{Table: "sa", Period: "w", PointsA: 1, PointsB: 1, SumA: 7, SumB: 7},
+		{Table: "sa", Period: "y", PointsB: 1, Added: 1, SumB: 365},
+	}
+	statuses := []string{"changed", "removed", "", "added"}
+	if len(diffs) != len(expected) {
+		t.Fatalf("expected %d series, got %d", len(expected), len(diffs))
+	}
+	for i, diff := range diffs {
+		if *diff != expected[i] || diff.Status() != statuses[i] {
+			t.Errorf("series %d: expected %+v (%s), got %+v (%s)", i+1, expected[i], statuses[i], *diff, diff.Status())
+		}
+	}
+	// Column present only in one source's row is a change
+	rowC := lib.DiffRow{"time": "2021-03-01 00:00:00", "period": "d", "value": 1.0}
+	rowD := lib.DiffRow{"time": "2021-03-01 00:00:00", "period": "d", "value": 1.0, "descr": "x"}
+	for i, pair := range [][2]lib.DiffRow{{rowC, rowD}, {rowD, rowC}} {
+		diffs = lib.DiffSeriesTable("sa", []lib.DiffRow{pair[0]}, []lib.DiffRow{pair[1]}, 0.01)
+		if len(diffs) != 1 || diffs[0].Changed != 1 {
+			t.Errorf("case %d: expected extra column to be a change, got %+v", i+1, diffs)
+		}
+	}
+	if !lib.DiffValuesEqual(0.001, 0.0, 0.01) || lib.DiffValuesEqual(0.02, 0.0, 0.01) || lib.DiffValuesEqual("a", "b", 1) {
+		t.Errorf("unexpected values comparison result")
+	}
+}
+
+func TestSnapshotDiffSource(t *testing.T) {
+	var ctx lib.Ctx
+	ctx.Init()
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	columns := []lib.ExportColumn{{Name: "time", Type: "time"}, {Name: "period", Type: "string"}, {Name: "value", Type: "float"}, {Name: "descr", Type: "string"}}
+	err = os.MkdirAll(dir+"/sa", 0755)
+	if err == nil {
+		err = lib.WriteExportCSV(
+			dir+"/sa/2021-03.csv",
+			columns,
+			[][]interface{}{{time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), "d", 1.5, nil}},
+		)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	manifest := lib.ExportManifest{
+		Format: "csv",
+		Tables: map[string]*lib.ExportTable{
+			"sa": {Columns: columns, Partitions: map[string]*lib.ExportPartition{"2021-03": {File: "sa/2021-03.csv", Rows: 1}}},
+		},
+	}
+	data, _ := json.Marshal(manifest)
+	if err = ioutil.WriteFile(dir+"/"+lib.ExportManifestFile, data, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	src := lib.NewSnapshotDiffSource(&ctx, dir)
+	tables := src.Tables()
+	rows := src.Rows("sa")
+	if len(tables) != 1 || tables[0] != "sa" || len(rows) != 1 {
+		t.Fatalf("unexpected snapshot data: %v, %v", tables, rows)
+	}
+	if rows[0]["time"] != "2021-03-01 00:00:00" || rows[0]["period"] != "d" || rows[0]["value"] != 1.5 || rows[0]["descr"] != nil {
+		t.Errorf("unexpected row: %v", rows[0])
+	}
+}
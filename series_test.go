@@ -702,3 +702,81 @@ func TestProcessAnnotations(t *testing.T) {
 		lib.ExecSQLWithErr(c, &ctx, "delete from \"tquick_ranges\"")
 	}
 }
+
+func TestApplyRetention(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+	ctx.TestMode = true
+
+	// Do not allow to run tests in "gha" database
+	if ctx.PgDB != "dbtest" {
+		t.Errorf("tests can only be run on \"dbtest\" database")
+		return
+	}
+	// Drop database if exists
+	lib.DropDatabaseIfExists(&ctx)
+
+	// Create database if needed
+	createdDatabase := lib.CreateDatabaseIfNeeded(&ctx)
+	if !createdDatabase {
+		t.Errorf("failed to create database \"%s\"", ctx.PgDB)
+	}
+
+	// Connect to Postgres DB
+	c := lib.PgConn(&ctx)
+
+	// Drop database after tests
+	defer func() {
+		lib.FatalOnError(c.Close())
+		// Drop database after tests
+		lib.DropDatabaseIfExists(&ctx)
+	}()
+
+	// Histogram shaped table (fake times) and a regular table with a histogram point in a regular period
+	ft := testlib.YMDHMS
+	hist := ft(2012, 7, 1)
+	for _, table := range []string{"shist", "sdata"} {
+		lib.ExecSQLWithErr(
+			c,
+			&ctx,
+			"create table \""+table+"\"(time timestamp not null, series text not null, period text not null, "+
+				"value double precision, primary key(time, series, period))",
+		)
+	}
+	points := []struct {
+		table  string
+		time   time.Time
+		series string
+		period string
+	}{
+		{table: "shist", time: hist, series: "a", period: "w"},
+		{table: "shist", time: hist, series: "a", period: "m"},
+		{table: "shist", time: hist, series: "b", period: "y10"},
+		{table: "sdata", time: ft(2020, 1, 1), series: "a", period: "m"},
+		{table: "sdata", time: ft(2021, 3, 1), series: "a", period: "m"},
+		{table: "sdata", time: hist, series: "hist", period: "m"},
+	}
+	for _, p := range points {
+		lib.ExecSQLWithErr(
+			c,
+			&ctx,
+			"insert into \""+p.table+"\"(time, series, period, value) values($1, $2, $3, 1)",
+			p.time,
+			p.series,
+			p.period,
+		)
+	}
+	rules := []lib.RetentionRule{{Series: ".*", Period: "w", KeepDays: 30}, {Series: ".*", Period: "m", KeepMonths: 6}, {Period: "y", KeepDays: 1}}
+	stats := lib.ApplyRetention(c, &ctx, rules, ft(2021, 3, 17, 12))
+	if len(stats) != 1 || stats[0].Table != "sdata" || stats[0].Period != "m" || stats[0].Deleted != 1 {
+		t.Errorf("expected only sdata period m to be affected with 1 point removed, got %+v", stats)
+	}
+	for table, expected := range map[string]int{"shist": 3, "sdata": 2} {
+		n := 0
+		lib.FatalOnError(lib.QueryRowSQL(c, &ctx, "select count(*) from \""+table+"\"").Scan(&n))
+		if n != expected {
+			t.Errorf("expected %d points in %s, got %d", expected, table, n)
+		}
+	}
+}
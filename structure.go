@@ -24,7 +24,7 @@ func Structure(ctx *Ctx) {
 			ctx,
 			CreateTable(
 				"gha_events("+
-					"id bigint not null primary key, "+
+					"id bigint not null, "+
 					"type varchar(40) not null, "+
 					"actor_id bigint not null, "+
 					"repo_id bigint not null, "+
@@ -33,10 +33,14 @@ func Structure(ctx *Ctx) {
 					"org_id bigint, "+
 					"forkee_id bigint, "+
 					"dup_actor_login varchar(120) not null, "+
-					"dup_repo_name varchar(160) not null"+
+					"dup_repo_name varchar(160) not null, "+
+					PartitionPrimaryKey(ctx.Partition, "gha_events", "id")+
 					")",
-			),
+			)+PartitionBy(ctx.Partition, "gha_events"),
 		)
+		if ctx.Partition != "" {
+			CreatePartitions(c, ctx, "gha_events", ctx.Partition, ctx.DefaultStartDate, time.Now())
+		}
 	}
 	if ctx.Index {
 		ExecSQLWithErr(c, ctx, "create index events_type_idx on gha_events(type)")
@@ -286,7 +290,7 @@ func Structure(ctx *Ctx) {
 			ctx,
 			CreateTable(
 				"gha_payloads("+
-					"event_id bigint not null primary key, "+
+					"event_id bigint not null, "+
 					"push_id bigint, "+
 					"size int, "+
 					"ref varchar(200), "+
@@ -309,10 +313,14 @@ func Structure(ctx *Ctx) {
 					"dup_repo_id bigint not null, "+
 					"dup_repo_name varchar(160) not null, "+
 					"dup_type varchar(40) not null, "+
-					"dup_created_at {{ts}} not null"+
+					"dup_created_at {{ts}} not null, "+
+					PartitionPrimaryKey(ctx.Partition, "gha_payloads", "event_id")+
 					")",
-			),
+			)+PartitionBy(ctx.Partition, "gha_payloads"),
 		)
+		if ctx.Partition != "" {
+			CreatePartitions(c, ctx, "gha_payloads", ctx.Partition, ctx.DefaultStartDate, time.Now())
+		}
 	}
 	if ctx.Index {
 		ExecSQLWithErr(c, ctx, "create index payloads_action_idx on gha_payloads(action)")
@@ -370,10 +378,13 @@ func Structure(ctx *Ctx) {
 					"loc_added int, "+
 					"loc_removed int, "+
 					"files_changed int, "+
-					"primary key(sha, event_id)"+
+					PartitionPrimaryKey(ctx.Partition, "gha_commits", "sha", "event_id")+
 					")",
-			),
+			)+PartitionBy(ctx.Partition, "gha_commits"),
 		)
+		if ctx.Partition != "" {
+			CreatePartitions(c, ctx, "gha_commits", ctx.Partition, ctx.DefaultStartDate, time.Now())
+		}
 	}
 	if ctx.Index {
 		ExecSQLWithErr(c, ctx, "create index commits_event_id_idx on gha_commits(event_id)")
@@ -1070,6 +1081,18 @@ func Structure(ctx *Ctx) {
 		ExecSQLWithErr(c, ctx, "create index logs_run_dt_idx on gha_logs(run_dt)")
 	}
 
+	// Metrics runs statistics table, calc_metric saves it into `devstats` database (the same way as logs)
+	metricsRuns := ctx.PgDB == Devstats
+	if ctx.Table && metricsRuns {
+		ExecSQLWithErr(c, ctx, "drop table if exists gha_metrics_runs")
+		ExecSQLWithErr(c, ctx, CreateTable(metricsRunsTableDef()))
+	}
+	if ctx.Index && metricsRuns {
+		ExecSQLWithErr(c, ctx, "create index metrics_runs_dt_idx on gha_metrics_runs(dt)")
+		ExecSQLWithErr(c, ctx, "create index metrics_runs_proj_idx on gha_metrics_runs(proj)")
+		ExecSQLWithErr(c, ctx, "create index metrics_runs_sql_file_idx on gha_metrics_runs(sql_file)")
+	}
+
 	// `Commit - file list it refers to` mapping table, used by `get_repos` tool
 	if ctx.Table {
 		ExecSQLWithErr(c, ctx, "drop table if exists gha_commits_files")
@@ -1197,8 +1220,11 @@ func Structure(ctx *Ctx) {
 					"repo_name varchar(160) not null, "+
 					"type varchar(40) not null"+
 					")",
-			),
+			)+PartitionBy(ctx.Partition, "gha_texts"),
 		)
+		if ctx.Partition != "" {
+			CreatePartitions(c, ctx, "gha_texts", ctx.Partition, ctx.DefaultStartDate, time.Now())
+		}
 	}
 	if ctx.Index {
 		ExecSQLWithErr(c, ctx, "create index texts_event_id_idx on gha_texts(event_id)")
@@ -1335,6 +1361,45 @@ func Structure(ctx *Ctx) {
 		ExecSQLWithErr(c, ctx, "create index computed_metric_idx on gha_computed(metric)")
 		ExecSQLWithErr(c, ctx, "create index computed_dt_idx on gha_computed(dt)")
 	}
+	if ctx.Table {
+		ExecSQLWithErr(c, ctx, "drop table if exists gha_anomalies")
+		ExecSQLWithErr(c, ctx, CreateTable(anomaliesTableDef()))
+	}
+	if ctx.Index {
+		ExecSQLWithErr(c, ctx, "create index anomalies_dt_idx on gha_anomalies(dt)")
+		ExecSQLWithErr(c, ctx, "create index anomalies_time_idx on gha_anomalies(time)")
+	}
+	if ctx.Table {
+		ExecSQLWithErr(c, ctx, "drop table if exists gha_explain_plans")
+		ExecSQLWithErr(c, ctx, CreateTable(explainPlansTableDef()))
+	}
+	if ctx.Index {
+		ExecSQLWithErr(c, ctx, "create index explain_plans_dt_idx on gha_explain_plans(dt)")
+	}
+	// Series catalog describes all TSDB series tables and variables
+	if ctx.Table {
+		ExecSQLWithErr(c, ctx, "drop table if exists tseries_catalog")
+		ExecSQLWithErr(c, ctx, CreateTable(seriesCatalogTableDef()))
+	}
+	if ctx.Index {
+		ExecSQLWithErr(c, ctx, "create index tseries_catalog_kind_idx on tseries_catalog(kind)")
+	}
+	// Incremental gha2es checkpoints and days fingerprints
+	if ctx.Table {
+		ExecSQLWithErr(c, ctx, "drop table if exists gha_es_raw_checkpoints")
+		ExecSQLWithErr(c, ctx, CreateTable(esRawCheckpointsTableDef()))
+		ExecSQLWithErr(c, ctx, "drop table if exists gha_es_raw_days")
+		ExecSQLWithErr(c, ctx, CreateTable(esRawDaysTableDef()))
+	}
+	// Freshly created structure is at the latest schema version
+	if ctx.Table {
+		ExecSQLWithErr(c, ctx, "drop table if exists gha_schema_migrations")
+		MarkSchemaMigrated(c, ctx)
+	}
+	if ctx.Table {
+		ExecSQLWithErr(c, ctx, "drop table if exists gha_hist_partials")
+		ExecSQLWithErr(c, ctx, CreateTable(histPartialsTableDef()))
+	}
 	if ctx.Table {
 		ExecSQLWithErr(c, ctx, "drop table if exists gha_parsed")
 		ExecSQLWithErr(
@@ -25,6 +25,18 @@ type Tag struct {
 	Disabled   bool                 `yaml:"disabled"`
 }
 
+// Columns contains list of columns that must be present on a certain series
+type Columns struct {
+	Columns []Column `yaml:"columns"`
+}
+
+// Column contain configuration of columns needed on a specific series
+type Column struct {
+	TableRegexp string `yaml:"table_regexp"`
+	Tag         string `yaml:"tag"`
+	Column      string `yaml:"column"`
+}
+
 // ProcessTag - insert given Tag into Postgres TSDB
 func ProcessTag(con *sql.DB, es *ES, ctx *Ctx, tg *Tag, replaces [][]string) {
 	// Batch TS points
@@ -153,7 +165,11 @@ func ProcessTag(con *sql.DB, es *ES, ctx *Ctx, tg *Tag, replaces [][]string) {
 
 	// Write the batch
 	if !ctx.SkipTSDB {
-		WriteTSPoints(ctx, con, &pts, "", nil)
+		err := WriteTSPointsE(ctx, con, &pts, "", nil)
+		if err != nil {
+			Printf("Skipped invalid tags: %v\n", err)
+		}
+		UpdateSeriesCatalog(con, ctx, SeriesCatalogEntries(&pts, "", "tags:"+tg.Name, err))
 	} else if ctx.Debug > 0 {
 		Printf("Skipping tags series write\n")
 	}
@@ -1,7 +1,11 @@
 package devstatscode
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -40,6 +44,25 @@ func (ps *TSPoints) Str() string {
 	return s
 }
 
+// MarshalJSON - returns JSON representation of the point
+func (p TSPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(
+		struct {
+			Time   time.Time              `json:"time"`
+			Name   string                 `json:"name"`
+			Period string                 `json:"period"`
+			Tags   map[string]string      `json:"tags,omitempty"`
+			Fields map[string]interface{} `json:"fields"`
+		}{
+			Time:   p.t,
+			Name:   p.name,
+			Period: p.period,
+			Tags:   p.tags,
+			Fields: p.fields,
+		},
+	)
+}
+
 // NewTSPoint returns new point as specified by args
 func NewTSPoint(ctx *Ctx, name, period string, tags map[string]string, fields map[string]interface{}, t time.Time, exact bool) TSPoint {
 	var (
@@ -88,3 +111,304 @@ func AddTSPoint(ctx *Ctx, pts *TSPoints, pt TSPoint) {
 		Printf("AddTSPoint: point added, now %d points\n", len(*pts))
 	}
 }
+
+// TS point validation error kinds
+const (
+	TSErrInvalidName     = "invalid name"
+	TSErrUnsupportedType = "unsupported type"
+	TSErrTypeConflict    = "type conflict"
+	TSErrColumnMismatch  = "column mismatch"
+)
+
+// TSPointError - structured TS point validation error
+// Table is the series/tags table the point would be written to, Column is empty when the table name is invalid
+type TSPointError struct {
+	Kind   string
+	Series string
+	Table  string
+	Column string
+	Msg    string
+}
+
+// Error - returns error message
+func (e *TSPointError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("%s: series %s (table %s): %s", e.Kind, e.Series, e.Table, e.Msg)
+	}
+	return fmt.Sprintf("%s: series %s (table %s, column %s): %s", e.Kind, e.Series, e.Table, e.Column, e.Msg)
+}
+
+// TSPointErrors - all validation errors found in a batch of points
+type TSPointErrors []*TSPointError
+
+// Error - returns all error messages joined
+func (es TSPointErrors) Error() string {
+	msgs := []string{}
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Series - returns names of series having errors
+func (es TSPointErrors) Series() map[string]struct{} {
+	series := make(map[string]struct{})
+	for _, e := range es {
+		series[e.Series] = struct{}{}
+	}
+	return series
+}
+
+// TSFieldType - returns TS field type: 0 - float64, 1 - time.Time, 2 - string, -1 - unsupported
+func TSFieldType(value interface{}) int {
+	switch value.(type) {
+	case float64:
+		return 0
+	case time.Time:
+		return 1
+	case string:
+		return 2
+	}
+	return -1
+}
+
+// tsFieldTypeName - returns Postgres column type used for a given TS field type
+func tsFieldTypeName(ty int) string {
+	switch ty {
+	case 0:
+		return "double precision"
+	case 1:
+		return "timestamp without time zone"
+	}
+	return "text"
+}
+
+// validTSName - checks if identifier fits in Postgres identifier length limit (without printing notice)
+func validTSName(name string) bool {
+	return len(escapeName(name)) <= 63
+}
+
+// ValidateTSPoint - validates point's table, tag and field names and field types
+// mergeSeries is the merge series name used when writing this point ("" when each series has its own table)
+func ValidateTSPoint(pt *TSPoint, mergeSeries string) (errs TSPointErrors) {
+	if pt.tags != nil {
+		table := "t" + pt.name
+		if mergeSeries != "" {
+			table = pt.name
+		}
+		if !validTSName(table) {
+			errs = append(errs, &TSPointError{Kind: TSErrInvalidName, Series: pt.name, Table: table, Msg: "table name too long"})
+		}
+		for tagName := range pt.tags {
+			if !validTSName(tagName) {
+				errs = append(errs, &TSPointError{Kind: TSErrInvalidName, Series: pt.name, Table: table, Column: tagName, Msg: "column name too long"})
+			}
+		}
+	}
+	if pt.fields != nil {
+		table := "s" + pt.name
+		if mergeSeries != "" {
+			table = "s" + mergeSeries
+		}
+		if !validTSName(table) {
+			errs = append(errs, &TSPointError{Kind: TSErrInvalidName, Series: pt.name, Table: table, Msg: "table name too long"})
+		}
+		for fieldName, fieldValue := range pt.fields {
+			if !validTSName(fieldName) {
+				errs = append(errs, &TSPointError{Kind: TSErrInvalidName, Series: pt.name, Table: table, Column: fieldName, Msg: "column name too long"})
+			}
+			if TSFieldType(fieldValue) < 0 {
+				errs = append(
+					errs,
+					&TSPointError{
+						Kind:   TSErrUnsupportedType,
+						Series: pt.name,
+						Table:  table,
+						Column: fieldName,
+						Msg:    fmt.Sprintf("value %+v has unsupported type %T", fieldValue, fieldValue),
+					},
+				)
+			}
+		}
+	}
+	return
+}
+
+// NewTSPointE returns new point as specified by args, validates it eagerly and returns structured errors
+// mergeSeries is the merge series name this point will be written with ("" when each series has its own table)
+func NewTSPointE(ctx *Ctx, name, period, mergeSeries string, tags map[string]string, fields map[string]interface{}, t time.Time, exact bool) (TSPoint, error) {
+	pt := NewTSPoint(ctx, name, period, tags, fields, t, exact)
+	errs := ValidateTSPoint(&pt, mergeSeries)
+	if len(errs) > 0 {
+		return pt, errs
+	}
+	return pt, nil
+}
+
+// tsFieldsTable - returns fields table name of a given point
+func tsFieldsTable(pt *TSPoint, mergeSeries string) string {
+	if mergeSeries != "" {
+		return "s" + mergeSeries
+	}
+	return "s" + pt.name
+}
+
+// AddTSPointE validates point and adds it to the batch
+// Point is not added when it is invalid or when its field types conflict with types of fields
+// of the same table already in the batch
+func AddTSPointE(ctx *Ctx, pts *TSPoints, pt TSPoint, mergeSeries string) error {
+	errs := ValidateTSPoint(&pt, mergeSeries)
+	if len(errs) == 0 && pt.fields != nil {
+		table := tsFieldsTable(&pt, mergeSeries)
+		for _, p := range *pts {
+			if p.fields == nil || tsFieldsTable(&p, mergeSeries) != table {
+				continue
+			}
+			for fieldName, fieldValue := range pt.fields {
+				prev, ok := p.fields[fieldName]
+				if !ok || TSFieldType(prev) == TSFieldType(fieldValue) {
+					continue
+				}
+				errs = append(
+					errs,
+					&TSPointError{
+						Kind:   TSErrTypeConflict,
+						Series: pt.name,
+						Table:  table,
+						Column: fieldName,
+						Msg:    fmt.Sprintf("value %+v,%T, previous values were %T", fieldValue, fieldValue, prev),
+					},
+				)
+			}
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	AddTSPoint(ctx, pts, pt)
+	return nil
+}
+
+// ValidateTSPoints - validates batch of points: names, field types, field type conflicts between points
+// of the same table and (when con is not nil) existing tables' column types
+func ValidateTSPoints(ctx *Ctx, con *sql.DB, pts *TSPoints, mergeSeries string) (errs TSPointErrors) {
+	if mergeSeries != "" && !validTSName("s"+mergeSeries) {
+		for _, p := range *pts {
+			errs = append(errs, &TSPointError{Kind: TSErrInvalidName, Series: p.name, Table: "s" + mergeSeries, Msg: "table name too long"})
+		}
+		return
+	}
+	types := make(map[string]map[string]int)
+	for i := range *pts {
+		p := &(*pts)[i]
+		errs = append(errs, ValidateTSPoint(p, mergeSeries)...)
+		if p.fields == nil {
+			continue
+		}
+		table := tsFieldsTable(p, mergeSeries)
+		if types[table] == nil {
+			types[table] = make(map[string]int)
+		}
+		for fieldName, fieldValue := range p.fields {
+			ty := TSFieldType(fieldValue)
+			if ty < 0 {
+				continue
+			}
+			prev, ok := types[table][fieldName]
+			if !ok {
+				types[table][fieldName] = ty
+				continue
+			}
+			if prev != ty {
+				errs = append(
+					errs,
+					&TSPointError{
+						Kind:   TSErrTypeConflict,
+						Series: p.name,
+						Table:  table,
+						Column: fieldName,
+						Msg:    fmt.Sprintf("value %+v,%T, previous values have %s type", fieldValue, fieldValue, tsFieldTypeName(prev)),
+					},
+				)
+			}
+		}
+	}
+	if con == nil {
+		return
+	}
+	for table, columns := range types {
+		if !validTSName(table) || !TableExists(con, ctx, table) {
+			continue
+		}
+		rows := QuerySQLWithErr(
+			con,
+			ctx,
+			"select column_name, data_type from information_schema.columns "+
+				"where table_schema = 'public' and table_name = $1",
+			table,
+		)
+		existing := make(map[string]string)
+		name, dataType := "", ""
+		for rows.Next() {
+			FatalOnError(rows.Scan(&name, &dataType))
+			existing[name] = dataType
+		}
+		FatalOnError(rows.Err())
+		FatalOnError(rows.Close())
+		for i := range *pts {
+			p := &(*pts)[i]
+			if p.fields == nil || tsFieldsTable(p, mergeSeries) != table {
+				continue
+			}
+			for fieldName := range p.fields {
+				dataType, ok := existing[fieldName]
+				if !ok || dataType == tsFieldTypeName(columns[fieldName]) {
+					continue
+				}
+				errs = append(
+					errs,
+					&TSPointError{
+						Kind:   TSErrColumnMismatch,
+						Series: p.name,
+						Table:  table,
+						Column: fieldName,
+						Msg:    fmt.Sprintf("existing column has %s type, value requires %s", dataType, tsFieldTypeName(columns[fieldName])),
+					},
+				)
+			}
+		}
+	}
+	return
+}
+
+// WriteTSPointsE - validates batch of points and writes all points of valid series using WriteTSPoints
+// Points of series having any validation error are skipped, returned error lists all problems (it is TSPointErrors)
+func WriteTSPointsE(ctx *Ctx, con *sql.DB, pts *TSPoints, mergeSeries string, mut *sync.Mutex) error {
+	errs := ValidateTSPoints(ctx, con, pts, mergeSeries)
+	if len(errs) == 0 {
+		WriteTSPoints(ctx, con, pts, mergeSeries, mut)
+		return nil
+	}
+	valid := ValidTSPoints(pts, errs)
+	if len(valid) > 0 {
+		WriteTSPoints(ctx, con, &valid, mergeSeries, mut)
+	}
+	return errs
+}
+
+// ValidTSPoints - returns points without series rejected by WriteTSPointsE error, so other outputs skip them too
+func ValidTSPoints(pts *TSPoints, writeErr error) TSPoints {
+	errs, ok := writeErr.(TSPointErrors)
+	if !ok || len(errs) == 0 {
+		return *pts
+	}
+	bad := errs.Series()
+	var valid TSPoints
+	for _, p := range *pts {
+		if _, ok := bad[p.name]; !ok {
+			valid = append(valid, p)
+		}
+	}
+	return valid
+}
@@ -0,0 +1,85 @@
+package devstatscode
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestTSPointValidation(t *testing.T) {
+	// Environment context parse
+	var ctx lib.Ctx
+	ctx.Init()
+
+	tm := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	long := strings.Repeat("x", 70)
+	var testCases = []struct {
+		name     string
+		merge    string
+		tags     map[string]string
+		fields   map[string]interface{}
+		expected []string
+	}{
+		{name: "ok", fields: map[string]interface{}{"value": 1.0, "name": "a", "dt": tm}},
+		{name: "ok", tags: map[string]string{"tag": "a"}},
+		{name: long, fields: map[string]interface{}{"value": 1.0}, expected: []string{lib.TSErrInvalidName}},
+		{name: long, merge: "merged", fields: map[string]interface{}{"value": 1.0}},
+		{name: "a", merge: long, fields: map[string]interface{}{"value": 1.0}, expected: []string{lib.TSErrInvalidName}},
+		{name: "a", tags: map[string]string{long: "a"}, expected: []string{lib.TSErrInvalidName}},
+		{name: "a", fields: map[string]interface{}{"value": 1}, expected: []string{lib.TSErrUnsupportedType}},
+	}
+	for index, test := range testCases {
+		_, err := lib.NewTSPointE(&ctx, test.name, "d", test.merge, test.tags, test.fields, tm, false)
+		kinds := []string{}
+		if err != nil {
+			errs, ok := err.(lib.TSPointErrors)
+			if !ok {
+				t.Fatalf("test number %d, expected TSPointErrors, got %T", index+1, err)
+			}
+			for _, e := range errs {
+				kinds = append(kinds, e.Kind)
+			}
+		}
+		if strings.Join(kinds, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("test number %d, expected %v, got %v (%v)", index+1, test.expected, kinds, err)
+		}
+	}
+
+	// Type conflicts between points of the same table
+	var pts lib.TSPoints
+	add := func(name, merge string, value interface{}) error {
+		return lib.AddTSPointE(&ctx, &pts, lib.NewTSPoint(&ctx, name, "d", nil, map[string]interface{}{"value": value}, tm, false), merge)
+	}
+	if err := add("a", "", 1.0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := add("b", "", "x"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := add("a", "", "x")
+	if errs, ok := err.(lib.TSPointErrors); !ok || len(errs) != 1 || errs[0].Kind != lib.TSErrTypeConflict || errs[0].Table != "sa" {
+		t.Errorf("expected type conflict, got %v", err)
+	}
+	if len(pts) != 2 {
+		t.Errorf("expected 2 points in batch, got %d", len(pts))
+	}
+	errs := lib.ValidateTSPoints(&ctx, nil, &pts, "m")
+	if len(errs) != 1 || errs[0].Kind != lib.TSErrTypeConflict || errs[0].Table != "sm" {
+		t.Errorf("expected type conflict in merged table, got %v", errs)
+	}
+	if _, ok := errs.Series()["b"]; !ok {
+		t.Errorf("expected series 'b' to be invalid, got %v", errs.Series())
+	}
+	// Points of invalid series are skipped by all outputs
+	if valid := lib.ValidTSPoints(&pts, errs); len(valid) != 1 {
+		t.Errorf("expected 1 valid point, got %d", len(valid))
+	}
+	if errs := lib.ValidateTSPoints(&ctx, nil, &pts, ""); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if valid := lib.ValidTSPoints(&pts, nil); len(valid) != 2 {
+		t.Errorf("expected all 2 points to be valid, got %d", len(valid))
+	}
+}
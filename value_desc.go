@@ -0,0 +1,134 @@
+package devstatscode
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ValueDescriptionFunc - returns description of a metric value (stored in series "descr" column)
+type ValueDescriptionFunc func(value float64) string
+
+// valueDescriptions - registry of value description functions that metrics can use via `desc:` property
+var (
+	valueDescriptionsMtx sync.RWMutex
+	valueDescriptions    = map[string]ValueDescriptionFunc{
+		"time_diff_as_string": DescriblePeriodInHours,
+		"duration_seconds":    func(v float64) string { return DescriblePeriodInHours(v / 3600.0) },
+		"duration_minutes":    func(v float64) string { return DescriblePeriodInHours(v / 60.0) },
+		"duration_days":       func(v float64) string { return DescriblePeriodInHours(v * 24.0) },
+		"percent":             func(v float64) string { return DescribePercent(v * 100.0) },
+		"percent_value":       DescribePercent,
+		"human_count":         DescribeHumanCount,
+		"semver":              DescribeSemver,
+	}
+)
+
+// RegisterValueDescription - registers a new value description function under a given name
+// Returns error when name is empty, function is nil or name is already registered
+func RegisterValueDescription(name string, fn ValueDescriptionFunc) error {
+	if name == "" || fn == nil {
+		return fmt.Errorf("value description function needs a name and a function")
+	}
+	valueDescriptionsMtx.Lock()
+	defer valueDescriptionsMtx.Unlock()
+	if _, ok := valueDescriptions[name]; ok {
+		return fmt.Errorf("value description function '%s' is already registered", name)
+	}
+	valueDescriptions[name] = fn
+	return nil
+}
+
+// ValueDescriptionNames - returns sorted names of all registered value description functions
+func ValueDescriptionNames() (names []string) {
+	valueDescriptionsMtx.RLock()
+	defer valueDescriptionsMtx.RUnlock()
+	for name := range valueDescriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// CheckValueDescription - checks if value description function with a given name is registered
+func CheckValueDescription(name string) error {
+	valueDescriptionsMtx.RLock()
+	_, ok := valueDescriptions[name]
+	valueDescriptionsMtx.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown value description function '%s', known: %s", name, strings.Join(ValueDescriptionNames(), ", "))
+	}
+	return nil
+}
+
+// CheckMetricsValueDescriptions - checks value description functions of all enabled metrics of the current project
+// Sync calls it before scheduling any metric, so an unknown function doesn't fail sync after some metrics were calculated
+func CheckMetricsValueDescriptions(ctx *Ctx, allMetrics *AllMetrics) error {
+	problems := []string{}
+	for _, metric := range allMetrics.Metrics {
+		if metric.Disabled || metric.Desc == "" || ExcludedForProject(ctx.Project, metric.Project) {
+			continue
+		}
+		err := CheckValueDescription(metric.Desc)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("metric '%s': %v", metric.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// valueDescription - return string description for given float value using registered function descFunc
+// Function names are validated before metric calculation starts (and by lint_yaml)
+func valueDescription(descFunc string, value float64) string {
+	valueDescriptionsMtx.RLock()
+	fn, ok := valueDescriptions[descFunc]
+	valueDescriptionsMtx.RUnlock()
+	if !ok {
+		FatalOnError(CheckValueDescription(descFunc))
+	}
+	return fn(value)
+}
+
+// DescribePercent - returns percentage description like 12.5%, value is given in percents
+func DescribePercent(value float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", value), "0"), ".") + "%"
+}
+
+// DescribeHumanCount - returns short human readable count like 999, 1.2k, 3.4M or 5B
+func DescribeHumanCount(value float64) string {
+	units := []struct {
+		div float64
+		sfx string
+	}{{1, ""}, {1e3, "k"}, {1e6, "M"}, {1e9, "B"}}
+	abs := math.Abs(value)
+	i := 0
+	for i+1 < len(units) && abs >= units[i+1].div {
+		i++
+	}
+	// Rounding can carry over to the next unit, like 999999 -> 1000.0k -> 1M
+	scale := 10.0
+	if i == 0 {
+		scale = 1.0
+	}
+	if i+1 < len(units) && math.Round(abs/units[i].div*scale)/scale >= 1000 {
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f", value)
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", value/units[i].div), ".0") + units[i].sfx
+}
+
+// DescribeSemver - returns semantic version encoded as major * 1000000 + minor * 1000 + patch, like 1028003 -> 1.28.3
+func DescribeSemver(value float64) string {
+	if value < 0 {
+		return "invalid version"
+	}
+	v := int64(value + 0.5)
+	return fmt.Sprintf("%d.%d.%d", v/1000000, (v/1000)%1000, v%1000)
+}
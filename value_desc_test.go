@@ -0,0 +1,84 @@
+package devstatscode
+
+import (
+	"testing"
+
+	lib "github.com/cncf/devstatscode"
+)
+
+func TestValueDescriptionFormatters(t *testing.T) {
+	var testCases = []struct {
+		fn       func(float64) string
+		value    float64
+		expected string
+	}{
+		{fn: lib.DescribePercent, value: 12.5, expected: "12.5%"},
+		{fn: lib.DescribePercent, value: 100, expected: "100%"},
+		{fn: lib.DescribePercent, value: 0.333333, expected: "0.33%"},
+		{fn: lib.DescribeHumanCount, value: 999, expected: "999"},
+		{fn: lib.DescribeHumanCount, value: 1000, expected: "1k"},
+		{fn: lib.DescribeHumanCount, value: 1234, expected: "1.2k"},
+		{fn: lib.DescribeHumanCount, value: 3450000, expected: "3.5M"},
+		{fn: lib.DescribeHumanCount, value: -2000000000, expected: "-2B"},
+		{fn: lib.DescribeHumanCount, value: 999.6, expected: "1k"},
+		{fn: lib.DescribeHumanCount, value: 999949, expected: "999.9k"},
+		{fn: lib.DescribeHumanCount, value: 999999, expected: "1M"},
+		{fn: lib.DescribeHumanCount, value: -999999999, expected: "-1B"},
+		{fn: lib.DescribeSemver, value: 1028003, expected: "1.28.3"},
+		{fn: lib.DescribeSemver, value: 2000000, expected: "2.0.0"},
+		{fn: lib.DescribeSemver, value: -1, expected: "invalid version"},
+	}
+	for index, test := range testCases {
+		got := test.fn(test.value)
+		if got != test.expected {
+			t.Errorf("test number %d, value %v, expected '%s', got '%s'", index+1, test.value, test.expected, got)
+		}
+	}
+}
+
+func TestValueDescriptionRegistry(t *testing.T) {
+	for _, name := range []string{"time_diff_as_string", "duration_seconds", "percent", "human_count", "semver"} {
+		if err := lib.CheckValueDescription(name); err != nil {
+			t.Errorf("expected built-in value description function '%s', got error: %v", name, err)
+		}
+	}
+	if err := lib.CheckValueDescription("test_stars"); err == nil {
+		t.Errorf("expected error for unknown value description function")
+	}
+	err := lib.RegisterValueDescription("test_stars", func(v float64) string { return lib.DescribeHumanCount(v) + " stars" })
+	if err != nil {
+		t.Errorf("expected registration to succeed, got error: %v", err)
+	}
+	if err := lib.CheckValueDescription("test_stars"); err != nil {
+		t.Errorf("expected registered value description function, got error: %v", err)
+	}
+	if err := lib.RegisterValueDescription("test_stars", lib.DescribeSemver); err == nil {
+		t.Errorf("expected error when registering function twice")
+	}
+	if err := lib.RegisterValueDescription("", lib.DescribeSemver); err == nil {
+		t.Errorf("expected error when registering function without a name")
+	}
+	if err := lib.RegisterValueDescription("test_nil", nil); err == nil {
+		t.Errorf("expected error when registering nil function")
+	}
+}
+
+func TestCheckMetricsValueDescriptions(t *testing.T) {
+	var ctx lib.Ctx
+	ctx.Project = "kubernetes"
+	allMetrics := lib.AllMetrics{
+		Metrics: []lib.Metric{
+			{Name: "a", Desc: "percent"},
+			{Name: "b"},
+			{Name: "c", Desc: "unknown_off", Disabled: true},
+			{Name: "d", Desc: "unknown_other", Project: "prometheus"},
+		},
+	}
+	if err := lib.CheckMetricsValueDescriptions(&ctx, &allMetrics); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	allMetrics.Metrics = append(allMetrics.Metrics, lib.Metric{Name: "e", Desc: "unknown_func"})
+	if err := lib.CheckMetricsValueDescriptions(&ctx, &allMetrics); err == nil {
+		t.Errorf("expected error for metric using unknown value description function")
+	}
+}
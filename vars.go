@@ -0,0 +1,23 @@
+package devstatscode
+
+// Vars contain list of Postgres variables to set
+type Vars struct {
+	Vars []Var `yaml:"vars"`
+}
+
+// Var contain each Postgres data
+type Var struct {
+	Name          string     `yaml:"name"`
+	Type          string     `yaml:"type"`
+	Value         string     `yaml:"value"`
+	Command       []string   `yaml:"command"`
+	Replaces      [][]string `yaml:"replaces"`
+	Disabled      bool       `yaml:"disabled"`
+	NoWrite       bool       `yaml:"no_write"`
+	Queries       [][]string `yaml:"queries"`
+	Loops         [][]int    `yaml:"loops"`
+	QueriesBefore bool       `yaml:"queries_before"`
+	QueriesAfter  bool       `yaml:"queries_after"`
+	LoopsBefore   bool       `yaml:"loops_before"`
+	LoopsAfter    bool       `yaml:"loops_after"`
+}